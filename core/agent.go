@@ -0,0 +1,402 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxAgentFrame is max length of a frame of agent protocol
+const maxAgentFrame = 1 << 20
+
+// Operations of agent protocol
+const (
+	agentOpList = "list"
+	agentOpFind = "find"
+	agentOpGet  = "get"
+	agentOpAdd  = "add"
+)
+
+// agentRequest is a request to agent, each connection carries one request
+// and its response, both are JSON prefixed by 4 bytes big endian length
+type agentRequest struct {
+	Op       string
+	Word     string         `json:",omitempty"`
+	ID       string         `json:",omitempty"`
+	NoHeader bool           `json:",omitempty"`
+	Password *agentPassword `json:",omitempty"`
+}
+
+// agentPassword is a password added by agent, plaintext fields of
+// PasswordBasic aren't serialized so they're carried beside it
+type agentPassword struct {
+	PasswordBasic
+	ID            string
+	Account       []byte
+	Password      []byte
+	Notes         []byte            `json:",omitempty"`
+	TOTPSecret    []byte            `json:",omitempty"`
+	CustomFields  map[string]string `json:",omitempty"`
+	CreatedAt     int64
+	LastUpdatedAt int64
+}
+
+func newAgentPassword(pw *Password) *agentPassword {
+	p := &agentPassword{
+		PasswordBasic: pw.PasswordBasic,
+		ID:            pw.ID,
+		Account:       pw.PlainAccount,
+		Password:      pw.PlainPassword,
+		Notes:         pw.PlainNotes,
+		TOTPSecret:    pw.PlainTOTPSecret,
+		CustomFields:  pw.CustomFields,
+		CreatedAt:     pw.CreatedAt,
+		LastUpdatedAt: pw.LastUpdatedAt,
+	}
+	p.PlainAccount, p.PlainPassword, p.PlainNotes, p.PlainTOTPSecret = nil, nil, nil, nil
+	p.PasswordBasic.CustomFields = nil
+	return p
+}
+
+// password returns a new Password of p, secrets are copied
+func (p *agentPassword) password() *Password {
+	pw := NewPassword("", "", "", "")
+	pw.PasswordBasic = p.PasswordBasic
+	if pw.Tags == nil {
+		pw.Tags = []string{}
+	}
+	pw.ID = p.ID
+	pw.PlainAccount = Secret(p.Account).clone()
+	pw.PlainPassword = Secret(p.Password).clone()
+	pw.PlainNotes = Secret(p.Notes).clone()
+	pw.PlainTOTPSecret = Secret(p.TOTPSecret).clone()
+	if len(p.CustomFields) > 0 {
+		pw.CustomFields = make(map[string]string, len(p.CustomFields))
+		for k, v := range p.CustomFields {
+			pw.CustomFields[k] = v
+		}
+	}
+	if p.CreatedAt != 0 {
+		pw.CreatedAt, pw.LastUpdatedAt = p.CreatedAt, p.LastUpdatedAt
+	}
+	return pw
+}
+
+func (p *agentPassword) wipe() {
+	Secret(p.Account).Wipe()
+	Secret(p.Password).Wipe()
+	Secret(p.Notes).Wipe()
+	Secret(p.TOTPSecret).Wipe()
+}
+
+type agentResponse struct {
+	Error    string `json:",omitempty"`
+	Output   []byte `json:",omitempty"`
+	ID       string `json:",omitempty"`
+	New      bool   `json:",omitempty"`
+	Password []byte `json:",omitempty"`
+}
+
+func writeFrame(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	defer Secret(data).Wipe()
+	if len(data) > maxAgentFrame {
+		return errAgentFrameTooLarge
+	}
+	frame := make([]byte, 4+len(data))
+	defer Secret(frame).Wipe()
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxAgentFrame {
+		return errAgentFrameTooLarge
+	}
+	data := make([]byte, n)
+	defer Secret(data).Wipe()
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Agent holds an unlocked box and answers requests of AgentClient over a
+// unix socket, like ssh-agent, so master password isn't needed by each
+// command. Box is locked and agent stops after it's idle for a while.
+type Agent struct {
+	box      *Box
+	path     string
+	listener net.Listener
+	idle     time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewAgent listens on unix socket path for requests to box, box must be
+// unlocked. It fails if path exists. Directory of path is created with
+// mode 0700 if it doesn't exist, it must be accessible by owner only
+// otherwise, so nobody else can connect even before socket is chmod'ed.
+// Agent stops after it's idle for idle if it's positive.
+func NewAgent(box *Box, path string, idle time.Duration) (*Agent, error) {
+	if err := checkAgentDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return nil, newErrAgentSocketExists(path)
+	}
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	agent := &Agent{
+		box:      box,
+		path:     path,
+		listener: listener,
+		idle:     idle,
+		done:     make(chan struct{}),
+	}
+	if idle > 0 {
+		agent.timer = time.AfterFunc(idle, func() { agent.Close() })
+	}
+	return agent, nil
+}
+
+// checkAgentDir creates dir of agent socket with mode 0700 if it doesn't
+// exist, existing dir must be accessible by owner only
+func checkAgentDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return newErrAgentDirNotPrivate(dir, info.Mode())
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return newErrAgentDirNotPrivate(dir, info.Mode())
+	}
+	return nil
+}
+
+// Serve answers requests until agent is closed
+func (agent *Agent) Serve() error {
+	for {
+		conn, err := agent.listener.Accept()
+		if err != nil {
+			select {
+			case <-agent.done:
+				agent.wg.Wait()
+				return nil
+			default:
+				return err
+			}
+		}
+		agent.wg.Add(1)
+		go func() {
+			defer agent.wg.Done()
+			defer conn.Close()
+			agent.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops agent, locks box and removes the socket
+func (agent *Agent) Close() error {
+	var err error
+	agent.closeOnce.Do(func() {
+		close(agent.done)
+		agent.mu.Lock()
+		if agent.timer != nil {
+			agent.timer.Stop()
+		}
+		agent.mu.Unlock()
+		// box is locked before Serve returns
+		err = agent.box.Lock()
+		if closeErr := agent.listener.Close(); err == nil {
+			err = closeErr
+		}
+		// listener of unix socket removes the file, it's removed again in
+		// case the listener didn't own it
+		os.Remove(agent.path)
+	})
+	return err
+}
+
+func (agent *Agent) touch() {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	if agent.timer != nil {
+		agent.timer.Reset(agent.idle)
+	}
+}
+
+func (agent *Agent) serveConn(conn net.Conn) {
+	agent.touch()
+	req := agentRequest{}
+	if err := readFrame(conn, &req); err != nil {
+		writeFrame(conn, agentResponse{Error: err.Error()})
+		return
+	}
+	resp := agent.handle(&req)
+	if req.Password != nil {
+		req.Password.wipe()
+	}
+	writeFrame(conn, resp)
+	Secret(resp.Output).Wipe()
+	Secret(resp.Password).Wipe()
+}
+
+func (agent *Agent) handle(req *agentRequest) agentResponse {
+	resp := agentResponse{}
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	switch req.Op {
+	case agentOpList:
+		err = agent.box.List(&buf, req.NoHeader)
+	case agentOpFind:
+		err = agent.box.Find(&buf, req.Word)
+	case agentOpGet:
+		resp.Password, err = agent.box.plainPassword(req.ID)
+	case agentOpAdd:
+		if req.Password == nil {
+			err = errInvalidAgentRequest
+			break
+		}
+		resp.ID, resp.New, err = agent.box.Add(req.Password.password())
+	default:
+		err = errInvalidAgentRequest
+	}
+	if err != nil {
+		Secret(buf.Bytes()).Wipe()
+		Secret(resp.Password).Wipe()
+		return agentResponse{Error: err.Error()}
+	}
+	resp.Output = buf.Bytes()
+	return resp
+}
+
+// plainPassword returns a copy of plaintext password of id, id may be a
+// prefix of ID as Get accepts
+func (box *Box) plainPassword(id string) (Secret, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	found, err := box.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found.sealed {
+		return found.PlainPassword.clone(), nil
+	}
+	pw := *found
+	if err := box.keys.decrypt(&pw); err != nil {
+		return nil, err
+	}
+	defer pw.wipe()
+	return pw.PlainPassword.clone(), nil
+}
+
+// AgentClient sends requests to Agent listening on a unix socket
+type AgentClient struct {
+	path string
+}
+
+// DialAgent returns client of agent listening on unix socket path, it
+// fails if no agent is listening
+func DialAgent(path string) (*AgentClient, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return &AgentClient{path: path}, nil
+}
+
+func (client *AgentClient) call(req agentRequest) (agentResponse, error) {
+	resp := agentResponse{}
+	conn, err := net.Dial("unix", client.path)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	if err := writeFrame(conn, req); err != nil {
+		return resp, err
+	}
+	if err := readFrame(conn, &resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// List writes all passwords of box of agent to w
+func (client *AgentClient) List(w io.Writer, noHeader bool) error {
+	resp, err := client.call(agentRequest{Op: agentOpList, NoHeader: noHeader})
+	if err != nil {
+		return err
+	}
+	defer Secret(resp.Output).Wipe()
+	_, err = w.Write(resp.Output)
+	return err
+}
+
+// Find writes passwords of box of agent matching word to w
+func (client *AgentClient) Find(w io.Writer, word string) error {
+	resp, err := client.call(agentRequest{Op: agentOpFind, Word: word})
+	if err != nil {
+		return err
+	}
+	defer Secret(resp.Output).Wipe()
+	_, err = w.Write(resp.Output)
+	return err
+}
+
+// Get returns plaintext password of id
+func (client *AgentClient) Get(id string) (Secret, error) {
+	resp, err := client.call(agentRequest{Op: agentOpGet, ID: id})
+	if err != nil {
+		return nil, err
+	}
+	return Secret(resp.Password), nil
+}
+
+// Add adds pw to box of agent like Box.Add
+func (client *AgentClient) Add(pw *Password) (id string, new bool, err error) {
+	resp, err := client.call(agentRequest{Op: agentOpAdd, Password: newAgentPassword(pw)})
+	return resp.ID, resp.New, err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package core
+
+import "net"
+
+// listenPrivate listens on unix socket path, which is protected by its
+// private directory only
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startTestAgent(t *testing.T, box *Box, idle time.Duration) (*Agent, *AgentClient, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.d", "agent.sock")
+	agent, err := NewAgent(box, path, idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go agent.Serve()
+	client, err := DialAgent(path)
+	if err != nil {
+		agent.Close()
+		t.Fatal(err)
+	}
+	return agent, client, path
+}
+
+func TestAgentSocketPermissions(t *testing.T) {
+	box := newTestBox(t)
+	agent, _, path := startTestAgent(t, box, 0)
+	defer agent.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("socket mode %v", perm)
+	}
+	if info, _ := os.Stat(filepath.Dir(path)); info.Mode().Perm() != 0700 {
+		t.Fatalf("directory mode %v", info.Mode().Perm())
+	}
+	if _, err := NewAgent(box, path, 0); err == nil {
+		t.Fatal("second agent started on the same socket")
+	}
+
+	public := filepath.Join(t.TempDir(), "public")
+	if err := os.Mkdir(public, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(public, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAgent(box, filepath.Join(public, "agent.sock"), 0); err == nil {
+		t.Fatal("agent started in a directory accessible by others")
+	}
+}
+
+func TestAgentGetListFind(t *testing.T) {
+	box := newTestBox(t)
+	id, _, err := box.Add(NewPassword("email", "me@example.com", "pw123456", "example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, client, _ := startTestAgent(t, box, 0)
+	defer agent.Close()
+
+	for _, lookup := range []string{id, id[:shortIDLength]} {
+		passwd, err := client.Get(lookup)
+		if err != nil || string(passwd) != "pw123456" {
+			t.Fatalf("Get(%s) = %q, %v", lookup, passwd, err)
+		}
+	}
+	if _, err := client.Get("nonexistent"); err == nil {
+		t.Fatal("Get of unknown id succeeded")
+	}
+	var buf bytes.Buffer
+	if err := client.List(&buf, false); err != nil || !strings.Contains(buf.String(), "me@example.com") {
+		t.Fatalf("List: %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if err := client.Find(&buf, "example"); err != nil || !strings.Contains(buf.String(), id[:shortIDLength]) {
+		t.Fatalf("Find: %q, %v", buf.String(), err)
+	}
+}
+
+func TestAgentAddKeepsAllFields(t *testing.T) {
+	box := newTestBox(t)
+	agent, client, _ := startTestAgent(t, box, 0)
+	defer agent.Close()
+
+	pw := NewPassword("dev", "me", "", "example.com")
+	pw.URL = "https://example.com/login"
+	pw.Tags = []string{"work"}
+	pw.PlainNotes = Secret("notes")
+	pw.PlainTOTPSecret = Secret("JBSWY3DPEHPK3PXP")
+	pw.CustomFields = map[string]string{"pin": "1234"}
+	pw.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	pw.Derived = true
+	pw.DeriveLength = 20
+	pw.DeriveCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	pw.DeriveCounter = 3
+	id, isNew, err := client.Add(pw)
+	if err != nil || !isNew {
+		t.Fatalf("Add: %v", err)
+	}
+	got, err := box.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.wipe()
+	if got.URL != pw.URL || len(got.Tags) != 1 || got.Tags[0] != "work" || got.ExpiresAt != pw.ExpiresAt {
+		t.Fatalf("metadata lost: %+v", got.PasswordBasic)
+	}
+	if string(got.PlainNotes) != "notes" || string(got.PlainTOTPSecret) != "JBSWY3DPEHPK3PXP" {
+		t.Fatal("secrets lost")
+	}
+	if got.CustomFields["pin"] != "1234" {
+		t.Fatalf("custom fields lost: %v", got.CustomFields)
+	}
+	if !got.Derived || got.DeriveLength != 20 || got.DeriveCharset != pw.DeriveCharset || got.DeriveCounter != 3 {
+		t.Fatalf("derivation lost: %+v", got.PasswordBasic)
+	}
+	if len(got.PlainPassword) != 20 {
+		t.Fatalf("derived password %q", got.PlainPassword)
+	}
+}
+
+func TestAgentIdleLocksBox(t *testing.T) {
+	box := newTestBox(t)
+	agent, _, path := startTestAgent(t, box, 100*time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for !box.Locked() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !box.Locked() {
+		agent.Close()
+		t.Fatal("box not locked after idle")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket left: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package core
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on unix socket path created with mode 0600, umask
+// is process wide so it's restored right after
+func listenPrivate(path string) (net.Listener, error) {
+	mask := syscall.Umask(0077)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// UnlockPolicy limits how fast Unlock can be retried after failures. The
+// n-th consecutive failure delays the next attempt by BaseDelay doubled
+// n-1 times, at most MaxDelay, and after MaxAttempts failures by CoolDown.
+type UnlockPolicy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAttempts is number of failures before cool-down, no cool-down if 0
+	MaxAttempts int
+	CoolDown    time.Duration
+}
+
+// DefaultUnlockPolicy returns the policy used if no policy specified
+func DefaultUnlockPolicy() UnlockPolicy {
+	return UnlockPolicy{
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		MaxAttempts: 10,
+		CoolDown:    15 * time.Minute,
+	}
+}
+
+// delay returns how long the next attempt is delayed after failures
+func (policy UnlockPolicy) delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	if policy.MaxAttempts > 0 && failures >= policy.MaxAttempts {
+		return policy.CoolDown
+	}
+	delay := policy.BaseDelay
+	for i := 1; i < failures && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+// UnlockDelayError is returned by Unlock if it's retried too early after
+// failed attempts, Wait tells how long to wait
+type UnlockDelayError struct {
+	Failures int
+	Wait     time.Duration
+}
+
+// Error implements error interface
+func (err *UnlockDelayError) Error() string {
+	return fmt.Sprintf("%d failed unlock attempts, retry in %v", err.Failures, err.Wait.Round(time.Second))
+}
+
+// AttemptRepository is a repository which persists failed unlock attempts
+// of its box, so delays survive restarts of process. Failed attempts of
+// other repositories are counted in memory.
+type AttemptRepository interface {
+	BoxRepository
+	// LoadAttempts returns data saved by SaveAttempts, empty if nothing saved
+	LoadAttempts() ([]byte, error)
+	// SaveAttempts saves data, empty data clears saved data
+	SaveAttempts(data []byte) error
+}
+
+// unlockAttempts is record of consecutive failed unlock attempts
+type unlockAttempts struct {
+	Failures    int
+	LastFailure int64
+}
+
+// LoadAttempts implements AttemptRepository.LoadAttempts method, attempts
+// are stored beside Filename as Filename.attempts
+func (repo *FileRepository) LoadAttempts() ([]byte, error) {
+	data, err := ioutil.ReadFile(repo.Filename + ".attempts")
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+// SaveAttempts implements AttemptRepository.SaveAttempts method
+func (repo *FileRepository) SaveAttempts(data []byte) error {
+	if len(data) == 0 {
+		err := os.Remove(repo.Filename + ".attempts")
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(repo.Filename+".attempts", data, 0600)
+}
+
+func (box *Box) unlockPolicy() UnlockPolicy {
+	if box.options.UnlockPolicy != nil {
+		return *box.options.UnlockPolicy
+	}
+	return DefaultUnlockPolicy()
+}
+
+func (box *Box) loadAttempts() (unlockAttempts, error) {
+	repo, ok := box.repo.(AttemptRepository)
+	if !ok {
+		return box.attempts, nil
+	}
+	attempts := unlockAttempts{}
+	data, err := repo.LoadAttempts()
+	if err != nil || len(data) == 0 {
+		return attempts, err
+	}
+	err = json.Unmarshal(data, &attempts)
+	return attempts, err
+}
+
+func (box *Box) saveAttempts(attempts unlockAttempts) error {
+	box.attempts = attempts
+	repo, ok := box.repo.(AttemptRepository)
+	if !ok {
+		return nil
+	}
+	if attempts.Failures == 0 {
+		return repo.SaveAttempts(nil)
+	}
+	data, err := json.Marshal(attempts)
+	if err != nil {
+		return err
+	}
+	return repo.SaveAttempts(data)
+}
+
+// checkAttempts returns failed attempts, and *UnlockDelayError if
+// unlocking is delayed by them
+func (box *Box) checkAttempts() (unlockAttempts, error) {
+	attempts, err := box.loadAttempts()
+	if err != nil {
+		return attempts, err
+	}
+	next := time.Unix(0, attempts.LastFailure).Add(box.unlockPolicy().delay(attempts.Failures))
+	if wait := time.Until(next); wait > 0 {
+		return attempts, &UnlockDelayError{Failures: attempts.Failures, Wait: wait}
+	}
+	return attempts, nil
+}
+
+// attempt calls fn which unlocks box by master password, both Unlock and
+// Init. It's delayed as UnlockPolicy tells after failures, fn failing for
+// a wrong master password is counted, and failures are cleared once fn
+// succeeds.
+func (box *Box) attempt(fn func() error) error {
+	attempts, err := box.checkAttempts()
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if recordErr := box.recordFailure(err); recordErr != nil {
+			return fmt.Errorf("%w, failed attempt not recorded: %v", err, recordErr)
+		}
+		return err
+	}
+	if attempts.Failures > 0 {
+		// box is unlocked even if failed attempts can't be cleared
+		box.saveAttempts(unlockAttempts{})
+	}
+	return nil
+}
+
+// recordFailure counts failure of unlocking by err, failures of repository
+// don't count
+func (box *Box) recordFailure(err error) error {
+	if !isWrongMasterPassword(err) {
+		return nil
+	}
+	attempts, loadErr := box.loadAttempts()
+	if loadErr != nil {
+		return loadErr
+	}
+	attempts.Failures++
+	attempts.LastFailure = time.Now().UnixNano()
+	return box.saveAttempts(attempts)
+}
+
+func isWrongMasterPassword(err error) bool {
+	return errors.Is(err, errWrongMasterPassword) ||
+		errors.Is(err, errWrongMasterPasswordOrKeyfile) ||
+		errors.Is(err, errProbablyWrongMasterPassword) ||
+		errors.Is(err, errWrongPepper)
+}
+
+// ResetUnlockAttempts clears failed unlock attempts of box, box must be
+// unlocked so only who knows master password can reset them
+func (box *Box) ResetUnlockAttempts() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	return box.saveAttempts(unlockAttempts{})
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInitDelayedAfterWrongMasterPassword(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "password.data")
+	policy := UnlockPolicy{BaseDelay: 200 * time.Millisecond, MaxDelay: time.Second}
+	newBox := func() *Box {
+		return NewBoxWithOptions(NewFileRepository(filename), Options{UnlockPolicy: &policy})
+	}
+	if err := newBox().Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	box := newBox()
+	if err := box.Init("wrong#Master1"); !isWrongMasterPassword(err) {
+		t.Fatalf("Init with wrong master password: %v", err)
+	}
+	// a new process is delayed too, even by the right master password
+	var delayErr *UnlockDelayError
+	if err := newBox().Init(testMasterPassword); !errors.As(err, &delayErr) {
+		t.Fatalf("want *UnlockDelayError, got %v", err)
+	}
+	if delayErr.Failures != 1 || delayErr.Wait <= 0 {
+		t.Fatalf("unexpected delay %+v", delayErr)
+	}
+
+	time.Sleep(policy.BaseDelay)
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if attempts, _ := box.loadAttempts(); attempts.Failures != 0 {
+		t.Fatalf("failures not cleared: %d", attempts.Failures)
+	}
+}
+
+func TestUnlockDelayedAfterWrongMasterPassword(t *testing.T) {
+	policy := UnlockPolicy{BaseDelay: 200 * time.Millisecond, MaxDelay: time.Second}
+	box := NewBoxWithOptions(NewMemoryRepository(nil), Options{UnlockPolicy: &policy})
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := box.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := box.Unlock("wrong#Master1"); !isWrongMasterPassword(err) {
+		t.Fatalf("Unlock with wrong master password: %v", err)
+	}
+	var delayErr *UnlockDelayError
+	if err := box.Unlock(testMasterPassword); !errors.As(err, &delayErr) {
+		t.Fatalf("want *UnlockDelayError, got %v", err)
+	}
+	time.Sleep(policy.BaseDelay)
+	if err := box.Unlock(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnlockPolicyDelay(t *testing.T) {
+	policy := UnlockPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, MaxAttempts: 10, CoolDown: time.Hour}
+	for _, c := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, time.Hour},
+	} {
+		if got := policy.delay(c.failures); got != c.want {
+			t.Errorf("delay(%d) = %v, want %v", c.failures, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// AuditReport is result of Audit
+type AuditReport struct {
+	// Reused passwords, ids grouped by identical password
+	Reused map[string][]string
+
+	// Ids of expired passwords
+	Expired []string
+}
+
+// OK reports whether nothing was found by audit
+func (r AuditReport) OK() bool {
+	return len(r.Reused) == 0 && len(r.Expired) == 0
+}
+
+// Audit reports passwords reused or expired
+func (box *Box) Audit() (AuditReport, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	report := AuditReport{}
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return report, err
+	}
+	var err error
+	if report.Reused, err = box.duplicatePasswords(); err != nil {
+		return report, err
+	}
+	now := time.Now()
+	for _, pw := range box.sortedPasswords() {
+		if pw.expiresBefore(now) {
+			report.Expired = append(report.Expired, pw.ID)
+		}
+	}
+	return report, nil
+}
+
+// DuplicatePasswords groups ids of passwords by identical plaintext, only
+// groups of more than one password are returned. Groups are keyed by an
+// HMAC of the password under a random key, so keys don't reveal anything
+// and differ between calls.
+func (box *Box) DuplicatePasswords() (map[string][]string, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	return box.duplicatePasswords()
+}
+
+func (box *Box) duplicatePasswords() (map[string][]string, error) {
+	key, err := randomBytes(keyLength)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(key).Wipe()
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe()
+	groups := map[string][]string{}
+	for _, pw := range passwords {
+		if len(pw.PlainPassword) == 0 {
+			continue
+		}
+		group := hex.EncodeToString(hmacSHA256(key, pw.PlainPassword))
+		groups[group] = append(groups[group], pw.ID)
+	}
+	for group, ids := range groups {
+		if len(ids) < 2 {
+			delete(groups, group)
+			continue
+		}
+		sort.Strings(ids)
+	}
+	return groups, nil
+}
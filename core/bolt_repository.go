@@ -0,0 +1,158 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultBoltTimeout is how long BoltRepository waits for lock of database
+// held by another process by default
+const DefaultBoltTimeout = time.Second
+
+var (
+	boltMetaBucket      = []byte("meta")
+	boltPasswordsBucket = []byte("passwords")
+	boltHeaderKey       = []byte("header")
+)
+
+// BoltRepository implements EntryRepository interface, box is stored in a
+// bbolt database file. Header of box is stored in bucket meta, passwords
+// in bucket passwords keyed by ID. Database is opened by each call and
+// locked meanwhile, so other processes can use it between calls.
+type BoltRepository struct {
+	Filename string
+
+	// Timeout of waiting for lock of database held by another process,
+	// 0 means DefaultBoltTimeout
+	Timeout time.Duration
+}
+
+// NewBoltRepository creates a BoltRepository
+func NewBoltRepository(filename string) *BoltRepository {
+	return &BoltRepository{Filename: filename}
+}
+
+// Load implements BoxRepository.Load method, empty data returned if file not exist
+func (repo *BoltRepository) Load() ([]byte, error) {
+	if _, err := os.Stat(repo.Filename); os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	db, err := repo.open(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var data []byte
+	err = db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		if meta == nil || meta.Get(boltHeaderKey) == nil {
+			data = []byte{}
+			return nil
+		}
+		header := meta.Get(boltHeaderKey)
+		passwords := []json.RawMessage{}
+		if bucket := tx.Bucket(boltPasswordsBucket); bucket != nil {
+			// keys are iterated in order of ID, values are valid only
+			// during the transaction
+			err := bucket.ForEach(func(_, v []byte) error {
+				passwords = append(passwords, append(json.RawMessage{}, v...))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		data, err = joinBoxData(header, passwords)
+		return err
+	})
+	return data, err
+}
+
+// Save implements BoxRepository.Save method. Header of box and passwords
+// are written in one transaction, unchanged passwords are not rewritten.
+func (repo *BoltRepository) Save(data []byte) error {
+	header, passwords, err := splitBoxData(data)
+	if err != nil {
+		return err
+	}
+	return repo.update(header, func(bucket *bolt.Bucket) error {
+		for id, pw := range passwords {
+			if old := bucket.Get([]byte(id)); old != nil && bytes.Equal(old, pw) {
+				continue
+			}
+			if err := bucket.Put([]byte(id), pw); err != nil {
+				return err
+			}
+		}
+		stale := [][]byte{}
+		err := bucket.ForEach(func(k, _ []byte) error {
+			if _, ok := passwords[string(k)]; !ok {
+				stale = append(stale, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveOne implements EntryRepository.SaveOne method
+func (repo *BoltRepository) SaveOne(header []byte, id string, password []byte) error {
+	return repo.update(header, func(bucket *bolt.Bucket) error {
+		return bucket.Put([]byte(id), password)
+	})
+}
+
+// DeleteOne implements EntryRepository.DeleteOne method
+func (repo *BoltRepository) DeleteOne(header []byte, id string) error {
+	return repo.update(header, func(bucket *bolt.Bucket) error {
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// update writes header and calls fn with bucket of passwords in one transaction
+func (repo *BoltRepository) update(header []byte, fn func(bucket *bolt.Bucket) error) error {
+	db, err := repo.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(boltHeaderKey, header); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(boltPasswordsBucket)
+		if err != nil {
+			return err
+		}
+		return fn(bucket)
+	})
+}
+
+func (repo *BoltRepository) open(readOnly bool) (*bolt.DB, error) {
+	timeout := repo.Timeout
+	if timeout <= 0 {
+		timeout = DefaultBoltTimeout
+	}
+	db, err := bolt.Open(repo.Filename, 0600, &bolt.Options{Timeout: timeout, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, newErrDatabaseLocked(repo.Filename)
+	}
+	return db, err
+}
@@ -1,13 +1,17 @@
 package core
 
 import (
-	"crypto/aes"
+	"bytes"
+	"crypto/ed25519"
 	"crypto/md5"
 	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"math/rand"
+	"os"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -17,10 +21,6 @@ import (
 	"github.com/mkideal/pkg/textutil"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 func md5sum(i interface{}) string {
 	switch v := i.(type) {
 	case string:
@@ -34,229 +34,1786 @@ func md5sum(i interface{}) string {
 	}
 }
 
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 // BoxRepository define repo for storing passwords
 type BoxRepository interface {
 	Load() ([]byte, error)
 	Save([]byte) error
 }
 
+// Options represents options of box
+type Options struct {
+	// KDF used for new boxes and for upgrading legacy md5-keyed boxes,
+	// one of KDFPBKDF2, KDFScrypt and KDFArgon2id. Salt is always generated by box.
+	KDF KDFConfig
+
+	// Cipher for encrypting passwords of new boxes, CipherAESGCM or CipherChaCha20Poly1305
+	Cipher string
+
+	// KeySize of AES in bits for new boxes, KeySize128 or KeySize256
+	KeySize int
+
+	// EncryptMetadata encrypts category, site, URL and tags of passwords in new boxes
+	EncryptMetadata bool
+
+	// EncryptTimestamps encrypts creation, update, expiry and history time
+	// stamps of passwords in new boxes, so repo doesn't reveal activity
+	EncryptTimestamps bool
+
+	// EncryptFile encrypts the box as a whole, so repo reveals nothing but
+	// key derivation parameters. Boxes not yet encrypted as a whole are
+	// converted when initialized.
+	EncryptFile bool
+
+	// Keyfile is content of keyfile for unlocking boxes which require a keyfile
+	Keyfile []byte
+
+	// Pepper for unlocking boxes which require a pepper, it's read from
+	// environment variable ONEPW_PEPPER if empty
+	Pepper []byte
+
+	// ChallengeResponder of hardware token for unlocking boxes which require a token
+	ChallengeResponder ChallengeResponder
+
+	// IgnoreMAC loads box even if MAC of box mismatch, for recovering a damaged box
+	IgnoreMAC bool
+
+	// Restricted allows approved algorithms only, PBKDF2 and AES-GCM. Boxes
+	// using md5, AES-CFB or other algorithms are refused.
+	Restricted bool
+
+	// WrongPasswordThreshold is fraction of garbled accounts from which
+	// master password of legacy boxes without verifier or MAC is regarded
+	// wrong, DefaultWrongPasswordThreshold if 0, not checked if negative
+	WrongPasswordThreshold float64
+
+	// ExportKey signs exports of box, a key derived from master password is
+	// used if nil
+	ExportKey ed25519.PrivateKey
+
+	// ImportKey verifies signatures of imports, public key of ExportKey is
+	// used if nil, or a key derived from master password if both nil
+	ImportKey ed25519.PublicKey
+
+	// HistoryLimit is max number of previous passwords kept per password,
+	// DefaultHistoryLimit if 0, previous passwords are not kept if negative
+	HistoryLimit int
+
+	// MasterPasswordPolicy checked when a box is created or master password
+	// is changed, DefaultMasterPasswordPolicy if nil
+	MasterPasswordPolicy *MasterPasswordPolicy
+
+	// UnlockPolicy delays Unlock after failed attempts, DefaultUnlockPolicy if nil
+	UnlockPolicy *UnlockPolicy
+}
+
+// maxHintLength is max length of hint of master password in bytes
+const maxHintLength = 128
+
 // Box represents password box
 type Box struct {
-	sync.RWMutex
+	mu             sync.RWMutex
 	masterPassword string
 	repo           BoxRepository
 	passwords      map[string]*Password
+	options        Options
+
+	// trash holds removed passwords by ID until trash is emptied
+	trash map[string]*Password
+
+	// key derivation config, cipher and key size read from repo, keys derived from master password
+	kdf     KDFConfig
+	cipher  string
+	keySize int
+	keys    *boxKeys
+
+	// key slots wrapping data key of box, slot is the one unlocked by master password
+	slots []keySlot
+	slot  int
+
+	// recoveryKey unlocks recovery slot instead of master password while
+	// box is being recovered
+	recoveryKey []byte
+
+	// pendingKDF re-keys box when it's saved next time if not nil
+	pendingKDF *KDFConfig
+
+	// attempts are failed unlock attempts if repo doesn't persist them
+	attempts unlockAttempts
+
+	// keyfile mixed into master key if keyfileRequired
+	keyfile         []byte
+	keyfileRequired bool
+
+	// pepper appended to master password if pepperRequired, pepperCheck
+	// is a short hash of the pepper for telling a wrong one
+	pepper         []byte
+	pepperRequired bool
+	pepperCheck    []byte
+
+	// challenge sent to hardware token, its response is mixed into master key.
+	// Response is cached with the challenge it answers, so token is asked once.
+	challenge         []byte
+	response          []byte
+	responseChallenge []byte
+
+	// ephemeral wipes plaintext right after encryption
+	ephemeral bool
+
+	// encryptMetadata encrypts metadata of passwords besides account and password
+	encryptMetadata bool
+
+	// encryptTimestamps encrypts time stamps of passwords with metadata
+	encryptTimestamps bool
+
+	// encryptFile encrypts serialized box as a whole before saving
+	encryptFile bool
+
+	// hint of master password, stored in plaintext
+	hint string
+
+	// syncID identifies box among boxes synced by SyncWith, syncMarkers
+	// are unix time of the last sync with each of them by their syncID
+	syncID      string
+	syncMarkers map[string]int64
+
+	// digest of data last loaded from or saved to repo
+	digest [sha256.Size]byte
+
+	// locked box has master password, keys and plaintext wiped until unlocked
+	locked bool
+
+	// readOnly box is never saved
+	readOnly bool
+
+	// box is locked after being idle for autoLock if it's positive, or
+	// discarded if autoDiscard is set. lastUsed is unix time in nanoseconds
+	// of last operation.
+	autoLock    time.Duration
+	autoDiscard bool
+	lockTimer   *time.Timer
+	lastUsed    int64
+}
+
+// keyState is snapshot of box states related to keys
+type keyState struct {
+	masterPassword  string
+	kdf             KDFConfig
+	keySize         int
+	keys            *boxKeys
+	slots           []keySlot
+	slot            int
+	keyfile         []byte
+	keyfileRequired bool
+	pepper          []byte
+	pepperRequired  bool
+	pepperCheck     []byte
+	challenge       []byte
+}
+
+func (box *Box) keyState() keyState {
+	return keyState{
+		masterPassword:  box.masterPassword,
+		kdf:             box.kdf,
+		keySize:         box.keySize,
+		keys:            box.keys,
+		slots:           box.slots,
+		slot:            box.slot,
+		keyfile:         box.keyfile,
+		keyfileRequired: box.keyfileRequired,
+		pepper:          box.pepper,
+		pepperRequired:  box.pepperRequired,
+		pepperCheck:     box.pepperCheck,
+		challenge:       box.challenge,
+	}
+}
+
+func (box *Box) restoreKeyState(state keyState) {
+	box.masterPassword = state.masterPassword
+	box.kdf = state.kdf
+	box.keySize = state.keySize
+	box.keys = state.keys
+	box.slots = state.slots
+	box.slot = state.slot
+	box.keyfile = state.keyfile
+	box.keyfileRequired = state.keyfileRequired
+	box.pepper = state.pepper
+	box.pepperRequired = state.pepperRequired
+	box.pepperCheck = state.pepperCheck
+	box.challenge = state.challenge
 }
 
 // Init initialize box with master password
 func (box *Box) Init(masterPassword string) error {
-	//TODO: check masterPassword
-	if len(masterPassword) < 6 {
-		return errMasterPasswordTooShort
+	if masterPassword == "" {
+		return errEmptyMasterPassword
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if box.locked {
+		return errBoxLocked
 	}
-	box.Lock()
-	defer box.Unlock()
-	box.masterPassword = masterPassword
-	if err := box.load(); err != nil {
+	box.touch()
+	if err := box.checkRestrictedOptions(); err != nil {
 		return err
 	}
-	for _, pw := range box.passwords {
-		if err := box.encrypt(pw); err != nil {
+	if !box.isReadOnly() {
+		unlock, err := box.lockRepo()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	// wrong master passwords are delayed as Unlock does
+	err := box.attempt(func() error {
+		box.masterPassword = masterPassword
+		if err := box.load(); err != nil {
+			box.masterPassword = ""
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// strength of master password is checked only for new boxes, so boxes
+	// created before the policy can still be opened
+	if box.kdf.isLegacy() && len(box.passwords) == 0 {
+		if err := box.masterPasswordPolicy().Check(masterPassword); err != nil {
+			box.masterPassword = ""
+			return err
+		}
+	}
+	if box.kdf.isLegacy() || len(box.slots) == 0 {
+		// passwords are re-encrypted by the new key below
+		if err := box.unsealAll(); err != nil {
 			return err
 		}
+		if box.kdf.isLegacy() {
+			box.keySize = box.options.KeySize
+			if err := box.rekey(box.options.KDF); err != nil {
+				return err
+			}
+			box.cipher = box.options.Cipher
+			box.encryptMetadata = box.options.EncryptMetadata
+			box.encryptTimestamps = box.options.EncryptTimestamps
+		} else {
+			// boxes before key slots are keyed by master password directly
+			if err := box.rekey(box.kdf); err != nil {
+				return err
+			}
+		}
+		for _, pw := range box.allPasswords() {
+			if err := box.encrypt(pw); err != nil {
+				return err
+			}
+		}
+	}
+	if box.isReadOnly() {
+		// box converted above is kept in memory only
+		return nil
 	}
 	return box.save()
 }
 
+// checkUnlocked returns errBoxLocked if box is locked, or notReady if box
+// has no master password. Idle time of auto-lock restarts on every check.
+func (box *Box) checkUnlocked(notReady error) error {
+	if box.locked {
+		return errBoxLocked
+	}
+	if notReady != nil && box.masterPassword == "" {
+		return notReady
+	}
+	box.touch()
+	return nil
+}
+
+func (box *Box) masterPasswordPolicy() MasterPasswordPolicy {
+	if box.options.MasterPasswordPolicy != nil {
+		return *box.options.MasterPasswordPolicy
+	}
+	return DefaultMasterPasswordPolicy()
+}
+
 // NewBox creates box with repo
 func NewBox(repo BoxRepository) *Box {
+	return NewBoxWithOptions(repo, Options{KDF: DefaultKDFConfig()})
+}
+
+// NewBoxWithOptions creates box with repo and options
+func NewBoxWithOptions(repo BoxRepository, opts Options) *Box {
+	opts.KDF = opts.KDF.withDefaults()
+	if opts.Cipher == "" {
+		opts.Cipher = CipherAESGCM
+	}
+	if opts.KeySize == 0 {
+		opts.KeySize = DefaultKeySize
+	}
+	if len(opts.Pepper) == 0 {
+		if pepper := os.Getenv(PepperEnv); pepper != "" {
+			opts.Pepper = []byte(pepper)
+		}
+	}
 	box := &Box{
 		repo:      repo,
 		passwords: map[string]*Password{},
+		trash:     map[string]*Password{},
+		options:   opts,
+		keyfile:   opts.Keyfile,
+		pepper:    opts.Pepper,
 	}
 	return box
 }
 
-// Load loads password box
-func (box *Box) Load() error {
-	box.Lock()
-	defer box.Unlock()
-	return box.load()
+// rekey replaces key slot of master password by a slot derived by cfg with
+// a new salt, and derives keys of box from data key. A new data key is
+// generated if box has no key slot yet, other slots are kept otherwise.
+func (box *Box) rekey(cfg KDFConfig) error {
+	var (
+		dataKey []byte
+		slots   []keySlot
+		err     error
+	)
+	if len(box.slots) == 0 {
+		if dataKey, err = randomBytes(keyLength); err != nil {
+			return err
+		}
+		slots = make([]keySlot, 1)
+		box.slot = 0
+	} else {
+		dataKey = box.keys.master
+		slots = append([]keySlot{}, box.slots...)
+	}
+	slot, err := box.newKeySlot(box.masterPassword, cfg, dataKey)
+	if err != nil {
+		return err
+	}
+	keys, err := newBoxKeys(dataKey, formatVersion, box.keySize)
+	if err != nil {
+		return err
+	}
+	slots[box.slot] = slot
+	box.slots = slots
+	box.kdf = slot.KDF
+	box.keys = keys
+	return nil
 }
 
-func (box *Box) load() error {
-	data, err := box.repo.Load()
+// newKeySlot creates a key slot wrapping dataKey by key derived from
+// masterPassword by cfg with a new salt
+func (box *Box) newKeySlot(masterPassword string, cfg KDFConfig, dataKey []byte) (keySlot, error) {
+	cfg, err := cfg.withSalt()
+	if err != nil {
+		return keySlot{}, err
+	}
+	key, err := box.deriveMasterKey(masterPassword, cfg)
+	if err != nil {
+		return keySlot{}, err
+	}
+	slot := keySlot{KDF: cfg}
+	if slot.IV, slot.Key, err = wrapKey(key, dataKey); err != nil {
+		return keySlot{}, err
+	}
+	return slot, nil
+}
+
+// openKeySlots returns index of the slot unlocked by masterPassword and
+// data key wrapped in it
+func (box *Box) openKeySlots(masterPassword string, slots []keySlot) (int, []byte, error) {
+	for i, slot := range slots {
+		if slot.isRecovery() {
+			continue
+		}
+		if err := slot.KDF.checkLimits(); err != nil {
+			return 0, nil, err
+		}
+		key, err := box.deriveMasterKey(masterPassword, slot.KDF)
+		if err != nil {
+			return 0, nil, err
+		}
+		if dataKey, err := unwrapKey(key, slot.IV, slot.Key); err == nil {
+			return i, dataKey, nil
+		}
+	}
+	return 0, nil, box.errWrongMasterPassword()
+}
+
+// unlockKey returns key of box, it's data key unlocked from key slots, or
+// master key derived by kdf for boxes before key slots
+func (box *Box) unlockKey(kdf KDFConfig, slots []keySlot) ([]byte, error) {
+	if box.recoveryKey != nil {
+		return box.openRecoverySlot(slots)
+	}
+	if len(slots) == 0 {
+		return box.deriveMasterKey(box.masterPassword, kdf)
+	}
+	i, dataKey, err := box.openKeySlots(box.masterPassword, slots)
+	if err != nil {
+		return nil, err
+	}
+	box.slot = i
+	return dataKey, nil
+}
+
+func (box *Box) errWrongMasterPassword() error {
+	if box.keyfileRequired {
+		return errWrongMasterPasswordOrKeyfile
+	}
+	return errWrongMasterPassword
+}
+
+// AddKeySlot adds a key slot so box can also be unlocked by newPassword,
+// existingPassword must unlock one of current slots. Index of the new
+// slot returned.
+func (box *Box) AddKeySlot(existingPassword, newPassword string) (int, error) {
+	if err := box.masterPasswordPolicy().Check(newPassword); err != nil {
+		return 0, err
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return 0, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if _, _, err := box.openKeySlots(existingPassword, box.slots); err != nil {
+		return 0, err
+	}
+	slot, err := box.newKeySlot(newPassword, box.kdf, box.keys.master)
+	if err != nil {
+		return 0, err
+	}
+	old := box.slots
+	box.slots = append(append([]keySlot{}, old...), slot)
+	if err := box.save(); err != nil {
+		box.slots = old
+		return 0, err
+	}
+	return len(box.slots) - 1, nil
+}
+
+// RemoveKeySlot removes key slot of index and saves box. The last slot and
+// the slot of current master password can't be removed.
+func (box *Box) RemoveKeySlot(index int) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
 	if err != nil {
 		return err
 	}
-	return box.unmarshal(data)
+	defer unlock()
+	if index < 0 || index >= len(box.slots) {
+		return newErrKeySlotNotFound(index)
+	}
+	if len(box.slots) == 1 {
+		return errRemoveLastKeySlot
+	}
+	if index == box.slot {
+		return errRemoveCurrentKeySlot
+	}
+	state := box.keyState()
+	slots := append([]keySlot{}, box.slots[:index]...)
+	box.slots = append(slots, box.slots[index+1:]...)
+	if index < box.slot {
+		box.slot--
+	}
+	err = box.save()
+	if err != nil {
+		box.restoreKeyState(state)
+	}
+	return err
 }
 
-// Save saves password box
-func (box *Box) Save() error {
-	box.Lock()
-	defer box.Unlock()
-	return box.save()
+// KeySlotCount returns number of key slots of box
+func (box *Box) KeySlotCount() int {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	return len(box.slots)
 }
 
-func (box *Box) save() error {
-	data, err := box.marshal()
+// Count returns number of passwords of box, master password isn't required
+func (box *Box) Count() int {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	return len(box.passwords)
+}
+
+// deriveMasterKey derives master key from masterPassword by cfg, and
+// mixes pepper, keyfile and response of hardware token into it if box requires
+func (box *Box) deriveMasterKey(masterPassword string, cfg KDFConfig) ([]byte, error) {
+	// fail before running the expensive key derivation
+	if box.keyfileRequired && len(box.keyfile) == 0 {
+		return nil, errKeyfileRequired
+	}
+	if box.pepperRequired {
+		if err := box.checkPepper(); err != nil {
+			return nil, err
+		}
+		masterPassword += string(box.pepper)
+	}
+	var response []byte
+	if len(box.challenge) > 0 {
+		var err error
+		if response, err = box.tokenResponse(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := cfg.deriveKey(masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	if box.keyfileRequired {
+		sum := sha256.Sum256(box.keyfile)
+		if key, err = hkdfKey(append(key, sum[:]...), keyfileLabel); err != nil {
+			return nil, err
+		}
+	}
+	if len(response) > 0 {
+		sum := sha256.Sum256(response)
+		if key, err = hkdfKey(append(key, sum[:]...), tokenLabel); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// ChangeKDF re-keys box with a new key derivation config and saves it,
+// all passwords are re-encrypted by the new key
+func (box *Box) ChangeKDF(cfg KDFConfig) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cfg = cfg.withDefaults()
+	if err := cfg.checkLimits(); err != nil {
+		return err
+	}
+	if err := box.checkRestrictedKDF(cfg); err != nil {
+		return err
+	}
+	return box.rekeyAndSave(cfg, nil)
+}
+
+// KDFParams returns key derivation parameters of current master password,
+// salt is not included
+func (box *Box) KDFParams() KDFConfig {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	cfg := box.kdf
+	cfg.Salt = nil
+	return cfg
+}
+
+// SetKDFParams sets key derivation parameters of current master password,
+// box is re-keyed by them with a new salt when it's saved next time
+func (box *Box) SetKDFParams(cfg KDFConfig) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if cfg.Type == KDFMD5 {
+		return errInvalidKDFParams
+	}
+	cfg = cfg.withDefaults()
+	if err := cfg.checkLimits(); err != nil {
+		return err
+	}
+	if err := box.checkRestrictedKDF(cfg); err != nil {
+		return err
+	}
+	box.pendingKDF = &cfg
+	return nil
+}
+
+// ChangeCipher re-encrypts all passwords by cipher and saves box. Passwords
+// of a box may be encrypted by different ciphers after a partial migration,
+// they are all unified to cipher.
+func (box *Box) ChangeCipher(cipherName string) error {
+	if err := checkCipher(cipherName); err != nil {
+		return err
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := box.checkRestrictedCipher(cipherName); err != nil {
+		return err
+	}
+	old := box.cipher
+	return box.withReencrypted(func() error {
+		box.cipher = cipherName
+		err := box.save()
+		if err != nil {
+			box.cipher = old
+		}
+		return err
+	})
+}
+
+// ChangeKeySize re-encrypts all passwords by a key of AES of bits size and
+// saves box, e.g. a box of AES-128 is upgraded to AES-256 by it. Master
+// password is kept, ChangeMasterPassword changes both at once.
+func (box *Box) ChangeKeySize(bits int) error {
+	if err := checkKeySize(bits); err != nil {
+		return err
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return box.rekeyAndSave(box.kdf, func() {
+		box.keySize = bits
+	})
+}
+
+// ChangeMasterPassword re-encrypts all passwords by new master password and
+// a key of AES of keySize bits, then saves box. Key size of box is kept if
+// keySize is 0, e.g. a box of AES-128 is re-keyed to AES-256 by 256.
+func (box *Box) ChangeMasterPassword(oldPassword, newPassword string, keySize int) error {
+	if err := box.masterPasswordPolicy().Check(newPassword); err != nil {
+		return err
+	}
+	if keySize != 0 {
+		if err := checkKeySize(keySize); err != nil {
+			return err
+		}
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !secretEqualString(oldPassword, box.masterPassword) {
+		return errWrongMasterPassword
+	}
+	return box.rekeyAndSave(box.kdf, func() {
+		box.masterPassword = newPassword
+		if keySize != 0 {
+			box.keySize = keySize
+		}
+	})
+}
+
+// SetKeyfile makes keyfile required for unlocking box, or replaces the
+// current keyfile, and saves box re-keyed by it
+func (box *Box) SetKeyfile(keyfile []byte) error {
+	if len(keyfile) == 0 {
+		return errEmptyKeyfile
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// keyfile is mixed into keys of all slots, which can't be re-derived
+	// without their master passwords
+	if box.passwordSlotCount() > 1 {
+		return errKeyfileWithKeySlots
+	}
+	return box.rekeyAndSave(box.kdf, func() {
+		box.keyfile = keyfile
+		box.keyfileRequired = true
+	})
+}
+
+// SetEncryptMetadata sets whether category, site, URL and tags of passwords
+// are encrypted, and saves box
+func (box *Box) SetEncryptMetadata(encrypt bool) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	old := box.encryptMetadata
+	return box.withReencrypted(func() error {
+		box.encryptMetadata = encrypt
+		err := box.save()
+		if err != nil {
+			box.encryptMetadata = old
+		}
+		return err
+	})
+}
+
+// SetEncryptTimestamps sets whether creation, update, expiry and history
+// time stamps of passwords are encrypted, and saves box
+func (box *Box) SetEncryptTimestamps(encrypt bool) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	old := box.encryptTimestamps
+	return box.withReencrypted(func() error {
+		box.encryptTimestamps = encrypt
+		err := box.save()
+		if err != nil {
+			box.encryptTimestamps = old
+		}
+		return err
+	})
+}
+
+// SetEncryptFile sets whether box is encrypted as a whole, and saves box
+func (box *Box) SetEncryptFile(encrypt bool) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	old := box.encryptFile
+	box.encryptFile = encrypt
+	err = box.save()
+	if err != nil {
+		box.encryptFile = old
+	}
+	return err
+}
+
+// SetHint sets hint of master password and saves box, an empty hint clears it.
+// Hint is stored in plaintext, so it must not contain master password.
+func (box *Box) SetHint(hint string) error {
+	if len(hint) > maxHintLength {
+		return errHintTooLong
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if hint != "" && strings.Contains(hint, box.masterPassword) {
+		return errHintContainsMasterPassword
+	}
+	old := box.hint
+	box.hint = hint
+	err = box.save()
+	if err != nil {
+		box.hint = old
+	}
+	return err
+}
+
+// Hint returns hint of master password, it's available even if box
+// failed to be initialized by a wrong master password
+func (box *Box) Hint() string {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	return box.hint
+}
+
+// RemoveKeyfile removes keyfile requirement of box and saves box
+func (box *Box) RemoveKeyfile() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !box.keyfileRequired {
+		return nil
+	}
+	if box.passwordSlotCount() > 1 {
+		return errKeyfileWithKeySlots
+	}
+	return box.rekeyAndSave(box.kdf, func() {
+		box.keyfileRequired = false
+	})
+}
+
+// ReencryptAll re-encrypts all passwords by current key with fresh IVs and saves box once
+func (box *Box) ReencryptAll() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// marshal encrypts every dirty password with fresh IVs by current cipher
+	return box.withReencrypted(box.save)
+}
+
+// Rekey re-encrypts every password with fresh IVs and saves box once, so
+// ciphers leaked before are useless for later versions of box. It returns
+// the number of passwords rotated, the ones in trash included.
+func (box *Box) Rekey() (int, error) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return 0, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if err := box.withReencrypted(box.save); err != nil {
+		return 0, err
+	}
+	return len(box.allPasswords()), nil
+}
+
+// rekeyAndSave applies change to box, re-keys box by cfg, then saves box.
+// Box is restored if any step fails, so repo keeps the old state
+func (box *Box) rekeyAndSave(cfg KDFConfig, change func()) error {
+	return box.withReencrypted(func() error {
+		state := box.keyState()
+		if change != nil {
+			change()
+		}
+		err := box.rekey(cfg)
+		if err == nil {
+			err = box.save()
+		}
+		if err != nil {
+			box.restoreKeyState(state)
+		}
+		return err
+	})
+}
+
+// Load loads password box
+func (box *Box) Load() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	return box.load()
+}
+
+func (box *Box) load() error {
+	data, err := box.repo.Load()
+	if err != nil {
+		return err
+	}
+	if err := box.unmarshal(data); err != nil {
+		return err
+	}
+	box.digest = box.digestOf(data)
+	return nil
+}
+
+// digestOf returns digest of data loaded from or saved to repo. Boxes are
+// stored by entries in an EntryRepository and loaded in another form, so
+// data is digested in the form it's loaded.
+func (box *Box) digestOf(data []byte) [sha256.Size]byte {
+	if _, ok := box.repo.(EntryRepository); ok {
+		if canonical, err := canonicalBoxData(data); err == nil {
+			data = canonical
+		}
+	}
+	return sha256.Sum256(data)
+}
+
+// lockRepo locks repo if it's a LockingRepository until unlock is called.
+// Box is reloaded if repo was changed by others since loaded, so changes
+// made after locked don't overwrite those saved by other processes.
+func (box *Box) lockRepo() (unlock func(), err error) {
+	repo, ok := box.repo.(LockingRepository)
+	if !ok {
+		return func() {}, nil
+	}
+	if err := repo.Lock(); err != nil {
+		return nil, err
+	}
+	unlock = func() { repo.Unlock() }
+	if box.keys == nil {
+		// box isn't loaded yet
+		return unlock, nil
+	}
+	data, err := repo.Load()
+	if err == nil && box.digestOf(data) != box.digest {
+		err = box.replace(data)
+	}
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// Reload reloads box from repo if it was changed by others, e.g. synced by
+// Dropbox. Passwords in memory are replaced by those in repo, since every
+// change of box is saved at once, only changes failed to be saved are
+// discarded. Box is kept as is if reloading fails.
+func (box *Box) Reload() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	data, err := box.repo.Load()
+	if err != nil {
+		return err
+	}
+	if box.digestOf(data) == box.digest {
+		return nil
+	}
+	return box.replace(data)
+}
+
+// replace replaces passwords and header of box by those unmarshaled from
+// data with master password of box, box is kept as is if it fails
+func (box *Box) replace(data []byte) error {
+	fresh := NewBoxWithOptions(box.repo, box.options)
+	fresh.masterPassword = box.masterPassword
+	fresh.keyfile = box.keyfile
+	fresh.pepper = box.pepper
+	fresh.response, fresh.responseChallenge = box.response, box.responseChallenge
+	if err := fresh.unmarshal(data); err != nil {
+		return err
+	}
+	if fresh.keys == nil {
+		return errReloadEmptyBox
+	}
+	for _, pw := range box.allPasswords() {
+		pw.wipe()
+	}
+	box.passwords = fresh.passwords
+	box.trash = fresh.trash
+	box.kdf = fresh.kdf
+	box.cipher = fresh.cipher
+	box.keySize = fresh.keySize
+	box.keys = fresh.keys
+	box.slots = fresh.slots
+	box.slot = fresh.slot
+	box.keyfileRequired = fresh.keyfileRequired
+	box.pepperRequired = fresh.pepperRequired
+	box.pepperCheck = fresh.pepperCheck
+	box.challenge = fresh.challenge
+	box.response = fresh.response
+	box.responseChallenge = fresh.responseChallenge
+	box.encryptMetadata = fresh.encryptMetadata
+	box.encryptTimestamps = fresh.encryptTimestamps
+	box.encryptFile = fresh.encryptFile
+	box.hint = fresh.hint
+	box.syncID = fresh.syncID
+	box.syncMarkers = fresh.syncMarkers
+	box.digest = box.digestOf(data)
+	if box.ephemeral {
+		return box.seal()
+	}
+	return nil
+}
+
+// Save saves password box
+func (box *Box) Save() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	return box.save()
+}
+
+func (box *Box) save() error {
+	if err := box.checkWritable(); err != nil {
+		return err
+	}
+	if cfg := box.pendingKDF; cfg != nil {
+		box.pendingKDF = nil
+		if err := box.rekeyAndSave(*cfg, nil); err != nil {
+			box.pendingKDF = cfg
+			return err
+		}
+		return nil
+	}
+	data, err := box.marshal()
+	if err != nil {
+		return err
+	}
+	debug.Debugf("marshal result: %v", string(data))
+	if err := box.repo.Save(data); err != nil {
+		return err
+	}
+	box.saved(data)
+	return nil
+}
+
+// saveOne saves box after password id was added, updated or removed, only
+// header of box and the password are written if repository is an
+// EntryRepository
+func (box *Box) saveOne(id string) error {
+	if err := box.checkWritable(); err != nil {
+		return err
+	}
+	repo, ok := box.repo.(EntryRepository)
+	if !ok || box.pendingKDF != nil || box.encryptFile {
+		return box.save()
+	}
+	for _, pw := range box.allPasswords() {
+		// ciphers of other passwords would change too
+		if pw.ID != id && !pw.sealed && pw.dirty {
+			return box.save()
+		}
+	}
+	data, err := box.marshal()
+	if err != nil {
+		return err
+	}
+	header, passwords, err := splitBoxData(data)
+	if err != nil {
+		return err
+	}
+	if pw, ok := passwords[id]; ok {
+		err = repo.SaveOne(header, id, pw)
+	} else {
+		err = repo.DeleteOne(header, id)
+	}
+	if err != nil {
+		return err
+	}
+	box.saved(data)
+	return nil
+}
+
+// saved records data saved to repository
+func (box *Box) saved(data []byte) {
+	box.digest = box.digestOf(data)
+	// ciphers were just encrypted by marshal, plaintext is decrypted again
+	// when it's needed
+	for _, pw := range box.allPasswords() {
+		if !pw.sealed {
+			pw.wipe()
+		}
+	}
+}
+
+// Add adds a new password to box, or replaces password of pw.ID. Box is
+// kept as is if it fails to be saved.
+func (box *Box) Add(pw *Password) (id string, new bool, err error) {
+	debug.Debugf("Add new password: %v", pw)
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err = box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return
+	}
+	if err = box.checkWritable(); err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = box.lockRepo(); err != nil {
+		return
+	}
+	defer unlock()
+	if len(pw.PlainTOTPSecret) > 0 {
+		var key []byte
+		if key, err = decodeTOTPSecret(pw.PlainTOTPSecret); err != nil {
+			return
+		}
+		Secret(key).Wipe()
+	}
+	// replaced password is updated by a copy, so it's put back as is if box
+	// fails to be saved
+	var replaced *Password
+	if old, ok := box.passwords[pw.ID]; ok {
+		// replaced password is kept in history
+		var updated *Password
+		if updated, err = box.decryptedCopy(old); err != nil {
+			return
+		}
+		updated.LastUpdatedAt = time.Now().Unix()
+		updated.migrate(pw, box.historyLimit())
+		pw, replaced = updated, old
+		new = false
+	} else if pw.ID != "" {
+		err = newErrPasswordNotFound(pw.ID)
+		return
+	} else {
+		id, err = box.allocID()
+		if err != nil {
+			return
+		}
+		pw.ID = id
+		new = true
+	}
+	if err = box.encrypt(pw); err != nil {
+		return
+	}
+	box.passwords[pw.ID] = pw
+	debug.Debugf("add new password: %v", pw)
+	if err = box.saveOne(pw.ID); err != nil {
+		if replaced != nil {
+			pw.wipe()
+			box.passwords[pw.ID] = replaced
+		} else {
+			delete(box.passwords, pw.ID)
+			pw.ID, id = "", ""
+		}
+		return
+	}
+	if replaced != nil {
+		replaced.wipe()
+	}
+	return
+}
+
+// AddBatch adds passwords to box like Add and saves box once, IDs of them
+// are returned in order. Box is rolled back if any of them fails to be
+// added or box fails to be saved.
+func (box *Box) AddBatch(pws []*Password) ([]string, error) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	if err := box.checkWritable(); err != nil {
+		return nil, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	for _, pw := range pws {
+		if len(pw.PlainTOTPSecret) > 0 {
+			key, err := decodeTOTPSecret(pw.PlainTOTPSecret)
+			if err != nil {
+				return nil, err
+			}
+			Secret(key).Wipe()
+		}
+		if _, ok := box.passwords[pw.ID]; !ok && pw.ID != "" {
+			return nil, newErrPasswordNotFound(pw.ID)
+		}
+	}
+	// replaced passwords are updated by copies, so they're put back as is
+	// by rollback
+	replaced := map[string]*Password{}
+	added := []*Password{}
+	rollback := func() {
+		for id, old := range replaced {
+			box.passwords[id].wipe()
+			box.passwords[id] = old
+		}
+		for _, pw := range added {
+			delete(box.passwords, pw.ID)
+			pw.ID = ""
+		}
+	}
+	ids := make([]string, 0, len(pws))
+	for _, pw := range pws {
+		if old, ok := box.passwords[pw.ID]; ok {
+			updated, err := box.decryptedCopy(old)
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+			if _, ok := replaced[pw.ID]; ok {
+				// updated twice in batch
+				old.wipe()
+			} else {
+				replaced[pw.ID] = old
+			}
+			updated.LastUpdatedAt = time.Now().Unix()
+			updated.migrate(pw, box.historyLimit())
+			pw = updated
+		} else {
+			id, err := box.allocID()
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+			pw.ID = id
+			added = append(added, pw)
+		}
+		if err := box.encrypt(pw); err != nil {
+			rollback()
+			return nil, err
+		}
+		box.passwords[pw.ID] = pw
+		ids = append(ids, pw.ID)
+	}
+	if err := box.save(); err != nil {
+		rollback()
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Remove moves passwords by ids to trash, they're kept encrypted until
+// restored by Restore or removed for good by EmptyTrash. Passwords are
+// kept if box fails to be saved.
+func (box *Box) Remove(ids []string, all bool) ([]string, error) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	if err := box.checkWritable(); err != nil {
+		return nil, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	deletedIds := []string{}
+	passwords := make([]*Password, 0)
+
+	for _, id := range ids {
+		size := len(deletedIds)
+		if foundPw, ok := box.passwords[id]; !ok {
+			for _, pw := range box.passwords {
+				if strings.HasPrefix(pw.ID, id) {
+					deletedIds = append(deletedIds, pw.ID)
+					passwords = append(passwords, pw)
+				}
+			}
+		} else {
+			deletedIds = append(deletedIds, id)
+			passwords = append(passwords, foundPw)
+		}
+		if len(deletedIds) == size {
+			return nil, newErrPasswordNotFound(id)
+		}
+		if len(deletedIds) > 1+size && !all {
+			return nil, newErrAmbiguous(passwords[size:])
+		}
+	}
+	deleted := make([]string, 0, len(deletedIds))
+	trashed := make([]*Password, 0, len(deletedIds))
+	for _, id := range deletedIds {
+		if pw, ok := box.passwords[id]; ok {
+			box.moveToTrash(pw)
+			deleted = append(deleted, id)
+			trashed = append(trashed, pw)
+		}
+	}
+	if len(deleted) == 1 {
+		err = box.saveOne(deleted[0])
+	} else {
+		err = box.save()
+	}
+	if err != nil {
+		box.restoreAllFromTrash(trashed)
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// Get returns a decrypted copy of password id, id may be a prefix of ID as
+// Remove accepts. Secrets of the copy should be wiped after use.
+func (box *Box) Get(id string) (*Password, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	found, err := box.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return box.decryptedCopy(found)
+}
+
+// decryptedCopy returns a copy of found with plaintext, nothing of the copy
+// is shared with found or other copies
+func (box *Box) decryptedCopy(found *Password) (*Password, error) {
+	pw := *found
+	pw.Tags = append([]string{}, found.Tags...)
+	if pw.sealed {
+		if err := box.keys.decrypt(&pw); err != nil {
+			return nil, err
+		}
+		return &pw, nil
+	}
+	pw.PlainAccount = found.PlainAccount.clone()
+	pw.PlainPassword = found.PlainPassword.clone()
+	pw.PlainNotes = found.PlainNotes.clone()
+	pw.PlainTOTPSecret = found.PlainTOTPSecret.clone()
+	pw.History = found.cloneHistory()
+	if found.CustomFields != nil {
+		pw.CustomFields = make(map[string]string, len(found.CustomFields))
+		for k, v := range found.CustomFields {
+			pw.CustomFields[k] = v
+		}
+	}
+	pw.dirty = false
+	return &pw, nil
+}
+
+// lookup returns password whose ID is id, or the only password whose ID
+// has prefix id
+func (box *Box) lookup(id string) (*Password, error) {
+	if pw, ok := box.passwords[id]; ok {
+		return pw, nil
+	}
+	found := box.find(func(pw *Password) bool { return strings.HasPrefix(pw.ID, id) })
+	switch {
+	case id == "" || len(found) == 0:
+		return nil, newErrPasswordNotFound(id)
+	case len(found) > 1:
+		return nil, newErrAmbiguous(found)
+	}
+	return found[0], nil
+}
+
+// RemoveByAccount moves passwords by category and account to trash, they
+// are kept if box fails to be saved
+func (box *Box) RemoveByAccount(category, account string, all bool) ([]string, error) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	if err := box.checkWritable(); err != nil {
+		return nil, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := box.unsealAll(); err != nil {
+		return nil, err
+	}
+	passwords := box.find(func(pw *Password) bool {
+		return pw.Category == category && secretEqual(pw.PlainAccount, []byte(account))
+	})
+	if len(passwords) == 0 {
+		return nil, newErrPasswordNotFoundWithAccount(category, account)
+	}
+	if len(passwords) > 1 && !all {
+		return nil, newErrAmbiguous(passwords)
+	}
+	ids := []string{}
+	for _, pw := range passwords {
+		box.moveToTrash(pw)
+		ids = append(ids, pw.ID)
+	}
+	if err := box.save(); err != nil {
+		box.restoreAllFromTrash(passwords)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Clear moves all passwords of box to trash, they are kept if box fails
+// to be saved
+func (box *Box) Clear() ([]string, error) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	if err := box.checkWritable(); err != nil {
+		return nil, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	ids := make([]string, 0, len(box.passwords))
+	trashed := make([]*Password, 0, len(box.passwords))
+	for _, pw := range box.passwords {
+		ids = append(ids, pw.ID)
+		trashed = append(trashed, pw)
+		box.moveToTrash(pw)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	if err := box.save(); err != nil {
+		box.restoreAllFromTrash(trashed)
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (box *Box) find(cond func(*Password) bool) []*Password {
+	ret := []*Password{}
+	for _, pw := range box.passwords {
+		if cond(pw) {
+			ret = append(ret, pw)
+		}
+	}
+	return ret
+}
+
+// List writes all passwords to specified writer
+func (box *Box) List(w io.Writer, noHeader bool) error {
+	return box.ListSorted(w, noHeader, SortByID)
+}
+
+// ListSorted writes all passwords sorted by key to specified writer
+func (box *Box) ListSorted(w io.Writer, noHeader bool, by SortKey) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.unsealCached(); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	if by != SortByID {
+		sort.Stable(sortedSlice{passwords, by.less()})
+	}
+	var table textutil.Table
+	table = passwordSlice(passwords)
+	if !noHeader {
+		table = textutil.AddTableHeader(table, passwordHeader)
+	}
+	textutil.WriteTable(w, table)
+	return nil
+}
+
+// ListPage writes passwords sorted by id from offset to specified writer,
+// limit passwords at most, all the rest if limit isn't positive. An offset
+// out of range writes an empty table.
+func (box *Box) ListPage(w io.Writer, offset, limit int, noHeader bool) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.unsealCached(); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	if offset < 0 || offset > len(passwords) {
+		offset = len(passwords)
+	}
+	passwords = passwords[offset:]
+	if limit > 0 && limit < len(passwords) {
+		passwords = passwords[:limit]
+	}
+	// only passwords of the page are decrypted
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	var table textutil.Table
+	table = passwordSlice(passwords)
+	if !noHeader {
+		table = textutil.AddTableHeader(table, passwordHeader)
+	}
+	textutil.WriteTable(w, table)
+	return nil
+}
+
+// Find finds password by word
+func (box *Box) Find(w io.Writer, word string) error {
+	return box.FindWith(w, word, MatchOptions{})
+}
+
+// FindWith finds password by word matched as opts tells
+func (box *Box) FindWith(w io.Writer, word string, opts MatchOptions) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.unsealCached(); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	table := passwordSlice{}
+	for _, pw := range passwords {
+		if pw.matchWith(word, opts) {
+			table = append(table, pw)
+		}
+	}
+	textutil.WriteTable(w, table)
+	return nil
+}
+
+// FindByTag writes passwords tagged by tag to specified writer
+func (box *Box) FindByTag(w io.Writer, tag string) error {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	// only passwords found are decrypted, transiently
+	table := passwordSlice{}
+	for _, pw := range box.sortedPasswords() {
+		if pw.hasTag(tag) {
+			table = append(table, pw)
+		}
+	}
+	wipe, err := box.reveal(table)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	textutil.WriteTable(w, table)
+	return nil
+}
+
+// FindRegexp writes passwords whose category, account or URL matches
+// regular expression pattern to specified writer
+func (box *Box) FindRegexp(w io.Writer, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return newErrInvalidPattern(pattern, err)
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.unsealCached(); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
 	if err != nil {
 		return err
 	}
-	debug.Debugf("marshal result: %v", string(data))
-	return box.repo.Save(data)
+	defer wipe()
+	table := passwordPtrSlice{}
+	for i := range passwords {
+		pw := &passwords[i]
+		if re.MatchString(pw.Category) || re.Match(pw.PlainAccount) || re.MatchString(pw.URL) {
+			table = append(table, pw)
+		}
+	}
+	textutil.WriteTable(w, table)
+	return nil
 }
 
-// Add adds a new password to box
-func (box *Box) Add(pw *Password) (id string, new bool, err error) {
-	debug.Debugf("Add new password: %v", pw)
-	box.Lock()
-	defer box.Unlock()
-	if box.masterPassword == "" {
-		err = errEmptyMasterPassword
-		return
+// ListExpiring writes passwords which expire within duration from now, or
+// have expired, to specified writer. Passwords never expire are skipped.
+func (box *Box) ListExpiring(w io.Writer, within time.Duration) error {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
 	}
-	if old, ok := box.passwords[pw.ID]; ok {
-		old.LastUpdatedAt = time.Now().Unix()
-		old.migrate(pw)
-		pw = old
-		new = false
-	} else if pw.ID != "" {
-		err = newErrPasswordNotFound(pw.ID)
-		return
-	} else {
-		id, err = box.allocID()
-		if err != nil {
-			return
+	deadline := time.Now().Add(within)
+	table := expiringSlice{}
+	for _, pw := range box.sortedPasswords() {
+		if pw.expiresBefore(deadline) {
+			table = append(table, pw)
 		}
-		pw.ID = id
-		new = true
 	}
-	if err = box.encrypt(pw); err != nil {
-		return
+	// the soonest first
+	sort.SliceStable(table, func(i, j int) bool {
+		return table[i].ExpiresAt < table[j].ExpiresAt
+	})
+	wipe, err := box.reveal(table)
+	if err != nil {
+		return err
 	}
-	box.passwords[pw.ID] = pw
-	debug.Debugf("add new password: %v", pw)
-	err = box.save()
-	return
+	defer wipe()
+	textutil.WriteTable(w, textutil.AddTableHeader(table, expiringHeader))
+	return nil
 }
 
-// Remove removes passwords by ids
-func (box *Box) Remove(ids []string, all bool) ([]string, error) {
-	box.Lock()
-	defer box.Unlock()
-	if box.masterPassword == "" {
-		return nil, errEmptyMasterPassword
+// Wipe zeroes plaintext of all passwords in memory. Ciphers are kept,
+// and passwords are decrypted again only while being used.
+func (box *Box) Wipe() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	return box.seal()
+}
+
+// SetEphemeralPlaintext sets whether plaintext is wiped right after encryption.
+// If enabled, passwords are decrypted transiently by List and Find.
+func (box *Box) SetEphemeralPlaintext(ephemeral bool) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	box.ephemeral = ephemeral
+	if ephemeral {
+		return box.seal()
 	}
-	deletedIds := []string{}
-	passwords := make([]*Password, 0)
+	return nil
+}
 
-	for _, id := range ids {
-		size := len(deletedIds)
-		if foundPw, ok := box.passwords[id]; !ok {
-			for _, pw := range box.passwords {
-				if strings.HasPrefix(pw.ID, id) {
-					deletedIds = append(deletedIds, pw.ID)
-					passwords = append(passwords, pw)
-				}
-			}
-		} else {
-			deletedIds = append(deletedIds, id)
-			passwords = append(passwords, foundPw)
+// seal encrypts unsealed passwords, then wipes their plaintext
+func (box *Box) seal() error {
+	if box.keys == nil {
+		return nil
+	}
+	for _, pw := range box.allPasswords() {
+		if pw.sealed {
+			continue
 		}
-		if len(deletedIds) == size {
-			return nil, newErrPasswordNotFound(id)
+		if pw.dirty {
+			if err := box.encrypt(pw); err != nil {
+				return err
+			}
 		}
-		if len(deletedIds) > 1+size && !all {
-			return nil, newErrAmbiguous(passwords[size:])
+		pw.wipe()
+	}
+	return nil
+}
+
+// reveal decrypts sealed passwords of copies returned by sortedPasswords,
+// the returned function must be called to wipe them after use
+func (box *Box) reveal(passwords []Password) (wipe func(), err error) {
+	revealed := []*Password{}
+	wipe = func() {
+		for _, pw := range revealed {
+			pw.wipe()
 		}
 	}
-	deleted := make([]string, 0, len(deletedIds))
-	for _, id := range deletedIds {
-		if _, ok := box.passwords[id]; ok {
-			delete(box.passwords, id)
-			deleted = append(deleted, id)
+	for i := range passwords {
+		if pw := &passwords[i]; pw.sealed {
+			if err := box.keys.decrypt(pw); err != nil {
+				wipe()
+				return nil, err
+			}
+			revealed = append(revealed, pw)
 		}
 	}
-	return deleted, box.save()
+	return wipe, nil
 }
 
-// RemoveByAccount removes passwords by category and account
-func (box *Box) RemoveByAccount(category, account string, all bool) ([]string, error) {
-	box.Lock()
-	defer box.Unlock()
-	if box.masterPassword == "" {
-		return nil, errEmptyMasterPassword
-	}
-	passwords := box.find(func(pw *Password) bool {
-		return pw.Category == category && pw.PlainAccount == account
-	})
-	if len(passwords) == 0 {
-		return nil, newErrPasswordNotFoundWithAccount(category, account)
+// withUnsealed calls fn with all passwords decrypted, trash too, passwords sealed before
+// are wiped again if fn succeeded. Ciphers may be changed by fn, so plaintext
+// is kept on failure.
+func (box *Box) withUnsealed(fn func() error) error {
+	sealed := []*Password{}
+	for _, pw := range box.allPasswords() {
+		if pw.sealed {
+			if err := box.keys.decrypt(pw); err != nil {
+				return err
+			}
+			sealed = append(sealed, pw)
+		}
 	}
-	if len(passwords) > 1 && !all {
-		return nil, newErrAmbiguous(passwords)
+	if err := fn(); err != nil {
+		return err
 	}
-	ids := []string{}
-	for _, pw := range passwords {
-		delete(box.passwords, pw.ID)
-		ids = append(ids, pw.ID)
+	for _, pw := range sealed {
+		pw.wipe()
 	}
-	return ids, box.save()
+	return nil
 }
 
-// Clear clear password box
-func (box *Box) Clear() ([]string, error) {
-	box.Lock()
-	defer box.Unlock()
-	ids := make([]string, 0, len(box.passwords))
-	for _, pw := range box.passwords {
-		ids = append(ids, pw.ID)
-		delete(box.passwords, pw.ID)
-	}
-	if len(ids) > 0 {
-		return ids, box.save()
-	}
-	return ids, nil
+// withReencrypted calls fn with all passwords decrypted and marked dirty,
+// so they are encrypted again by keys of box when saved. Passwords stay
+// dirty if fn failed, their ciphers may be written by keys restored since.
+func (box *Box) withReencrypted(fn func() error) error {
+	return box.withUnsealed(func() error {
+		box.markDirty()
+		if err := fn(); err != nil {
+			box.markDirty()
+			return err
+		}
+		return nil
+	})
 }
 
-func (box *Box) find(cond func(*Password) bool) []*Password {
-	ret := []*Password{}
-	for _, pw := range box.passwords {
-		if cond(pw) {
-			ret = append(ret, pw)
+// markDirty marks all unsealed passwords dirty, in trash too
+func (box *Box) markDirty() {
+	for _, pw := range box.allPasswords() {
+		if !pw.sealed {
+			pw.dirty = true
 		}
 	}
-	return ret
 }
 
-// List writes all passwords to specified writer
-func (box *Box) List(w io.Writer, noHeader bool) error {
-	box.RLock()
-	defer box.RUnlock()
-	if box.masterPassword == "" {
-		return errEmptyMasterPassword
-	}
-	var table textutil.Table
-	table = passwordSlice(box.sortedPasswords())
-	if !noHeader {
-		table = textutil.AddTableHeader(table, passwordHeader)
+// unsealCached decrypts sealed passwords in place, plaintext is cached
+// until box is saved or locked. Ephemeral boxes are left sealed, copies
+// of passwords are revealed transiently instead.
+func (box *Box) unsealCached() error {
+	if box.ephemeral {
+		return nil
 	}
-	textutil.WriteTable(w, table)
-	return nil
+	return box.unsealAll()
 }
 
-// Find finds password by word
-func (box *Box) Find(w io.Writer, word string) error {
-	box.RLock()
-	defer box.RUnlock()
-	if box.masterPassword == "" {
-		return errEmptyMasterPassword
+// unsealAll decrypts all sealed passwords in place
+func (box *Box) unsealAll() error {
+	for _, pw := range box.passwords {
+		if pw.sealed {
+			if err := box.keys.decrypt(pw); err != nil {
+				return err
+			}
+		}
 	}
-	table := passwordPtrSlice(box.find(func(pw *Password) bool { return pw.match(word) }))
-	sort.Stable(table)
-	textutil.WriteTable(w, table)
 	return nil
 }
 
@@ -269,86 +1826,331 @@ func (box *Box) sortedPasswords() []Password {
 	return passwords
 }
 
+// idLength is length of IDs of passwords in bytes, IDs are hex encoded
+const idLength = 16
+
+// allocID returns a random 128-bit ID in hex, collision with IDs in box or
+// trash is astronomically unlikely so it's not checked
 func (box *Box) allocID() (string, error) {
-	count := 0
-	for count < 10 {
-		id := md5sum(rand.Int63())
-		if _, ok := box.passwords[id]; !ok {
-			return id, nil
-		}
+	b, err := randomBytes(idLength)
+	if err != nil {
+		return "", err
 	}
-	return "", errAllocateID
+	return hex.EncodeToString(b), nil
 }
 
 func (box *Box) marshal() ([]byte, error) {
-	for _, pw := range box.passwords {
+	if box.keys == nil {
+		return nil, errBoxNotInitialized
+	}
+	for _, pw := range box.allPasswords() {
+		// ciphers of sealed and unchanged passwords are still valid, so
+		// saving an unmodified box writes the same bytes
+		if pw.sealed || !pw.dirty {
+			continue
+		}
 		if err := box.encrypt(pw); err != nil {
 			return nil, err
 		}
+		if box.ephemeral {
+			pw.wipe()
+		}
 	}
-	passwords := box.sortedPasswords()
-	return json.MarshalIndent(passwords, "", "    ")
+	bd := boxData{
+		Version:           formatVersion,
+		KDF:               KDFConfig{Type: kdfKeySlots},
+		Cipher:            box.cipher,
+		KeySize:           box.keySize,
+		KeyfileRequired:   box.keyfileRequired,
+		PepperRequired:    box.pepperRequired,
+		PepperCheck:       box.pepperCheck,
+		Challenge:         box.challenge,
+		Hint:              box.hint,
+		EncryptMetadata:   box.encryptMetadata,
+		EncryptTimestamps: box.encryptTimestamps,
+		KeySlots:          box.slots,
+		SyncID:            box.syncID,
+		SyncMarkers:       box.syncMarkers,
+		Passwords:         box.sortedPasswords(),
+	}
+	if len(box.trash) > 0 {
+		bd.Trash = box.sortedTrash()
+	}
+	for _, passwords := range [][]Password{bd.Passwords, bd.Trash} {
+		for i := range passwords {
+			if bd.EncryptMetadata {
+				passwords[i].hideMetadata()
+			}
+			if bd.EncryptTimestamps {
+				passwords[i].hideTimestamps()
+			}
+		}
+	}
+	macdata, err := bd.macData()
+	if err != nil {
+		return nil, err
+	}
+	bd.MAC = box.keys.mac(macdata)
+	if box.encryptFile {
+		data, err := json.Marshal(bd)
+		if err != nil {
+			return nil, err
+		}
+		return box.encryptBoxFile(data)
+	}
+	return json.MarshalIndent(bd, "", "    ")
+}
+
+// encryptBoxFile encrypts serialized box as a whole
+func (box *Box) encryptBoxFile(data []byte) ([]byte, error) {
+	eb := encryptedBox{
+		Version:         formatVersion,
+		KDF:             KDFConfig{Type: kdfKeySlots},
+		KeySize:         box.keySize,
+		KeyfileRequired: box.keyfileRequired,
+		PepperRequired:  box.pepperRequired,
+		PepperCheck:     box.pepperCheck,
+		Challenge:       box.challenge,
+		Hint:            box.hint,
+		KeySlots:        box.slots,
+	}
+	ad, err := eb.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	if eb.IV, eb.Data, err = box.keys.seal(CipherAESGCM, "", data, ad); err != nil {
+		return nil, err
+	}
+	header, err := json.MarshalIndent(eb, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, encryptedBoxMagic...), header...), nil
+}
+
+// decryptBoxFile decrypts box encrypted as a whole, key of box is returned
+// so it needn't be unlocked again
+func (box *Box) decryptBoxFile(data []byte) ([]byte, []byte, error) {
+	eb, err := parseEncryptedBox(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := eb.KDF.checkLimits(); err != nil {
+		return nil, nil, err
+	}
+	if err := box.checkRestrictedHeader(eb.KDF, eb.KeySlots); err != nil {
+		return nil, nil, err
+	}
+	box.keyfileRequired = eb.KeyfileRequired
+	box.pepperRequired = eb.PepperRequired
+	box.pepperCheck = eb.PepperCheck
+	box.challenge = eb.Challenge
+	box.hint = eb.Hint
+	key, err := box.unlockKey(eb.KDF, eb.KeySlots)
+	if err != nil {
+		return nil, nil, err
+	}
+	keySize := eb.KeySize
+	if keySize == 0 {
+		keySize = DefaultKeySize
+	}
+	if err := checkKeySize(keySize); err != nil {
+		return nil, nil, err
+	}
+	keys, err := newBoxKeys(key, eb.Version, keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(eb.KeySlots) == 0 && !keys.checkVerifier(eb.Verifier) {
+		return nil, nil, box.errWrongMasterPassword()
+	}
+	ad, err := eb.additionalData()
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := keys.open(CipherAESGCM, "", eb.IV, eb.Data, ad)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, key, nil
 }
 
 func (box *Box) unmarshal(data []byte) error {
-	if data == nil || len(data) == 0 {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		box.encryptFile = box.options.EncryptFile
+		box.resetPasswords()
 		return nil
 	}
-	passwords := make([]Password, 0)
-	err := json.Unmarshal(data, &passwords)
+	var key []byte
+	box.encryptFile = box.options.EncryptFile || isEncryptedBox(data)
+	if isEncryptedBox(data) {
+		if box.masterPassword == "" {
+			return errBoxNotInitialized
+		}
+		var err error
+		if data, key, err = box.decryptBoxFile(data); err != nil {
+			return err
+		}
+	}
+	bd, err := parseBoxData(data)
 	if err != nil {
 		return err
 	}
+	passwords := bd.Passwords
 	debug.Debugf("unmarshal result: %v", passwords)
 
-	for i := range passwords {
-		pw := &(passwords[i])
-		if box.masterPassword != "" {
-			if err := box.decrypt(pw); err != nil {
-				return err
-			}
+	if err := bd.KDF.checkLimits(); err != nil {
+		return err
+	}
+	if err := box.checkRestrictedHeader(bd.KDF, bd.KeySlots); err != nil {
+		return err
+	}
+	box.kdf = bd.KDF
+	box.cipher = bd.Cipher
+	if box.cipher == "" {
+		box.cipher = CipherAESGCM
+	}
+	if err := checkCipher(box.cipher); err != nil {
+		return err
+	}
+	// key size is missing in boxes before AES-128 supported
+	box.keySize = bd.KeySize
+	if box.keySize == 0 {
+		box.keySize = DefaultKeySize
+	}
+	if err := checkKeySize(box.keySize); err != nil {
+		return err
+	}
+	box.keyfileRequired = bd.KeyfileRequired
+	box.pepperRequired = bd.PepperRequired
+	box.pepperCheck = bd.PepperCheck
+	box.challenge = bd.Challenge
+	box.hint = bd.Hint
+	box.encryptMetadata = bd.EncryptMetadata
+	box.encryptTimestamps = bd.EncryptTimestamps
+	box.slots = bd.KeySlots
+	box.syncID = bd.SyncID
+	box.syncMarkers = bd.SyncMarkers
+	if err := box.checkRestrictedPasswords(box.cipher, passwords); err != nil {
+		return err
+	}
+	if err := box.checkRestrictedPasswords(box.cipher, bd.Trash); err != nil {
+		return err
+	}
+	if box.masterPassword == "" {
+		box.resetPasswords()
+		for i := range passwords {
+			box.passwords[passwords[i].ID] = &passwords[i]
 		}
-		box.passwords[pw.ID] = pw
+		for i := range bd.Trash {
+			box.trash[bd.Trash[i].ID] = &bd.Trash[i]
+		}
+		return nil
 	}
-	debug.Debugf("load result: %v", box.passwords)
-	return nil
-}
 
-func (box *Box) encrypt(pw *Password) error {
-	block, err := aes.NewCipher([]byte(md5sum(box.masterPassword)))
+	if key == nil {
+		if key, err = box.unlockKey(box.kdf, box.slots); err != nil {
+			return err
+		}
+	}
+	if len(box.slots) > 0 {
+		box.kdf = box.slots[box.slot].KDF
+	}
+	keys, err := newBoxKeys(key, bd.Version, box.keySize)
 	if err != nil {
 		return err
 	}
-	if len(pw.AccountIV) != block.BlockSize() {
-		pw.AccountIV = make([]byte, block.BlockSize())
-		if _, err := crand.Read(pw.AccountIV); err != nil {
+	if len(bd.Verifier) > 0 && !keys.checkVerifier(bd.Verifier) {
+		return box.errWrongMasterPassword()
+	}
+	if len(bd.MAC) > 0 {
+		macdata, err := bd.macData()
+		if err != nil {
+			return err
+		}
+		if !keys.checkMAC(macdata, bd.MAC) {
+			if !box.options.IgnoreMAC {
+				return errBoxTampered
+			}
+			debug.Debugf("MAC of box mismatch, ignored")
+		}
+	}
+	// metadata is needed for sorting and listing, account, password and
+	// notes are decrypted on demand
+	all := make([]*Password, 0, len(passwords)+len(bd.Trash))
+	for i := range passwords {
+		all = append(all, &passwords[i])
+	}
+	for i := range bd.Trash {
+		all = append(all, &bd.Trash[i])
+	}
+	for _, pw := range all {
+		if err := keys.decryptMetadata(pw); err != nil {
 			return err
 		}
+		pw.sealed = true
 	}
-	if len(pw.PasswordIV) != block.BlockSize() {
-		pw.PasswordIV = make([]byte, block.BlockSize())
-		if _, err := crand.Read(pw.PasswordIV); err != nil {
+	// passwords will be saved by keys of current format version
+	if bd.Version != formatVersion {
+		// plaintext may be migrated too
+		for _, pw := range all {
+			if err := keys.decrypt(pw); err != nil {
+				return err
+			}
+			pw.dirty = true
+		}
+		canary := len(bd.Verifier) > 0 || len(bd.MAC) > 0 || len(bd.KeySlots) > 0
+		if !canary && box.probablyWrongPassword(passwords) {
+			for _, pw := range all {
+				pw.wipe()
+			}
+			return errProbablyWrongMasterPassword
+		}
+		if err := bd.migrate(); err != nil {
+			return err
+		}
+		if keys, err = newBoxKeys(key, formatVersion, box.keySize); err != nil {
 			return err
 		}
 	}
-	pw.CipherAccount = cfbEncrypt(block, pw.AccountIV, []byte(pw.PlainAccount))
-	pw.CipherPassword = cfbEncrypt(block, pw.PasswordIV, []byte(pw.PlainPassword))
+	box.resetPasswords()
+	for i := range bd.Passwords {
+		pw := &bd.Passwords[i]
+		box.passwords[pw.ID] = pw
+	}
+	for i := range bd.Trash {
+		pw := &bd.Trash[i]
+		box.trash[pw.ID] = pw
+	}
+	box.keys = keys
+	debug.Debugf("load result: %v", box.passwords)
 	return nil
 }
 
-func (box *Box) decrypt(pw *Password) error {
-	block, err := aes.NewCipher([]byte(md5sum(box.masterPassword)))
-	if err != nil {
-		return err
+// resetPasswords wipes and removes passwords and trash of box, so box
+// loaded again holds only those loaded
+func (box *Box) resetPasswords() {
+	for _, pw := range box.allPasswords() {
+		pw.wipe()
 	}
-	if len(pw.AccountIV) != block.BlockSize() {
-		return errLengthOfIV
+	box.passwords = map[string]*Password{}
+	box.trash = map[string]*Password{}
+}
+
+// encrypt encrypts pw by keys of box, pw is clean after that
+func (box *Box) encrypt(pw *Password) error {
+	if err := box.keys.encrypt(pw, box.cipher); err != nil {
+		return err
 	}
-	if len(pw.PasswordIV) != block.BlockSize() {
-		return errLengthOfIV
+	if box.encryptMetadata || box.encryptTimestamps {
+		if err := box.keys.encryptMetadata(pw, box.cipher, box.encryptTimestamps); err != nil {
+			return err
+		}
+	} else {
+		pw.MetadataIV, pw.CipherMetadata = nil, nil
 	}
-	pw.PlainAccount = string(cfbDecrypt(block, pw.AccountIV, pw.CipherAccount))
-	pw.PlainPassword = string(cfbDecrypt(block, pw.PasswordIV, pw.CipherPassword))
+	pw.dirty = false
 	return nil
 }
 
@@ -384,3 +2186,69 @@ func (ps passwordPtrSlice) ColCount() int {
 func (ps passwordPtrSlice) Get(i, j int) string {
 	return ps[i].get(j)
 }
+
+// SortKey decides order of passwords listed by ListSorted
+type SortKey int
+
+const (
+	// SortByID sorts passwords by ID
+	SortByID SortKey = iota
+	// SortByCategory sorts passwords by category, then by account
+	SortByCategory
+	// SortByAccount sorts passwords by account, then by category
+	SortByAccount
+	// SortByUpdated sorts passwords by time updated, the latest first
+	SortByUpdated
+)
+
+// less returns comparator of passwords by key, ties are left in order of ID
+func (by SortKey) less() func(a, b *Password) bool {
+	switch by {
+	case SortByCategory:
+		return func(a, b *Password) bool {
+			if a.Category != b.Category {
+				return a.Category < b.Category
+			}
+			return bytes.Compare(a.PlainAccount, b.PlainAccount) < 0
+		}
+	case SortByAccount:
+		return func(a, b *Password) bool {
+			if c := bytes.Compare(a.PlainAccount, b.PlainAccount); c != 0 {
+				return c < 0
+			}
+			return a.Category < b.Category
+		}
+	case SortByUpdated:
+		return func(a, b *Password) bool { return a.LastUpdatedAt > b.LastUpdatedAt }
+	}
+	return func(a, b *Password) bool { return a.ID < b.ID }
+}
+
+// sortedSlice is passwordSlice sorted by a pluggable comparator
+type sortedSlice struct {
+	passwordSlice
+	less func(a, b *Password) bool
+}
+
+func (ss sortedSlice) Less(i, j int) bool {
+	return ss.less(&ss.passwordSlice[i], &ss.passwordSlice[j])
+}
+
+// expiringSlice is passwordSlice with expiry column
+type expiringSlice []Password
+
+var expiringHeader = append(append([]string{}, passwordHeader...), "EXPIRES_AT")
+
+func (ps expiringSlice) RowCount() int { return len(ps) }
+func (ps expiringSlice) ColCount() int {
+	if len(ps) == 0 {
+		return 0
+	}
+	return ps[0].colCount() + 1
+}
+func (ps expiringSlice) Get(i, j int) string {
+	if j == ps[i].colCount() {
+		return time.Unix(ps[i].ExpiresAt, 0).Format(time.RFC3339)
+	}
+	return ps[i].get(j)
+}
@@ -0,0 +1,569 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// savedKeySize returns key size in header of box saved to repo
+func savedKeySize(t *testing.T, repo *MemoryRepository) int {
+	t.Helper()
+	bd, err := parseBoxData(repo.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bd.KeySize
+}
+
+// reopen initializes a new box of repo by master password
+func reopen(t *testing.T, repo BoxRepository, masterPassword string) *Box {
+	t.Helper()
+	box := NewBox(repo)
+	if err := box.Init(masterPassword); err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+func TestKeySizeRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		from, to int
+	}{
+		{"128 to 256", KeySize128, KeySize256},
+		{"256 to 128", KeySize256, KeySize128},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMemoryRepository(nil)
+			box := NewBoxWithOptions(repo, Options{KeySize: tt.from})
+			if err := box.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := savedKeySize(t, repo); size != tt.from {
+				t.Fatalf("key size %d saved", size)
+			}
+			if got := getPassword(t, reopen(t, repo, testMasterPassword), id); string(got.PlainPassword) != "pw123456" {
+				t.Fatalf("password %q", got.PlainPassword)
+			}
+
+			if err := box.ChangeKeySize(tt.to); err != nil {
+				t.Fatal(err)
+			}
+			if size := savedKeySize(t, repo); size != tt.to {
+				t.Fatalf("key size %d saved after changed", size)
+			}
+			if got := getPassword(t, reopen(t, repo, testMasterPassword), id); string(got.PlainPassword) != "pw123456" {
+				t.Fatalf("password %q after key size changed", got.PlainPassword)
+			}
+
+			if err := box.ChangeMasterPassword(testMasterPassword, "n3w-Master#pw", 0); err != nil {
+				t.Fatal(err)
+			}
+			if size := savedKeySize(t, repo); size != tt.to {
+				t.Fatalf("key size %d saved after master password changed", size)
+			}
+			if got := getPassword(t, reopen(t, repo, "n3w-Master#pw"), id); string(got.PlainPassword) != "pw123456" {
+				t.Fatalf("password %q after master password changed", got.PlainPassword)
+			}
+		})
+	}
+}
+
+func TestChangeMasterPasswordKeySize(t *testing.T) {
+	repo := NewMemoryRepository(nil)
+	box := NewBoxWithOptions(repo, Options{KeySize: KeySize128})
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := box.ChangeMasterPassword(testMasterPassword, "n3w-Master#pw", 192); err == nil {
+		t.Fatal("re-keyed by 192-bit key")
+	}
+	if size := savedKeySize(t, repo); size != KeySize128 {
+		t.Fatalf("key size %d saved after re-key failed", size)
+	}
+
+	// re-keyed from 128 to 256 bits with the new master password
+	if err := box.ChangeMasterPassword(testMasterPassword, "n3w-Master#pw", KeySize256); err != nil {
+		t.Fatal(err)
+	}
+	if size := savedKeySize(t, repo); size != KeySize256 {
+		t.Fatalf("key size %d saved", size)
+	}
+	if err := NewBox(repo).Init(testMasterPassword); err == nil {
+		t.Fatal("opened by old master password")
+	}
+	if got := getPassword(t, reopen(t, repo, "n3w-Master#pw"), id); string(got.PlainPassword) != "pw123456" {
+		t.Fatalf("password %q after re-keyed", got.PlainPassword)
+	}
+}
+
+func TestUnsupportedKeySize(t *testing.T) {
+	box := newTestBox(t)
+	if err := box.ChangeKeySize(192); err == nil {
+		t.Fatal("changed key size to 192 bits")
+	}
+	repo := box.repo.(*MemoryRepository)
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(repo.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["KeySize"] = json.RawMessage("512")
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBox(NewMemoryRepository(data)).Init(testMasterPassword); err == nil {
+		t.Fatal("opened box of 512-bit key")
+	}
+}
+
+// checkSealed checks that every password of box is sealed or not, sealed
+// passwords have no plaintext
+func checkSealed(t *testing.T, box *Box, sealed bool) {
+	t.Helper()
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	for _, pw := range box.allPasswords() {
+		if pw.sealed != sealed {
+			t.Fatalf("password %s sealed %v", pw.ID, pw.sealed)
+		}
+		if pw.sealed && (pw.PlainAccount != nil || pw.PlainPassword != nil) {
+			t.Fatalf("sealed password %s has plaintext", pw.ID)
+		}
+	}
+}
+
+func TestLazyDecryption(t *testing.T) {
+	box := newTestBox(t)
+	ids, err := box.AddBatch([]*Password{
+		NewPassword("github", "me", "pw123456", "github.com"),
+		NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// plaintext is wiped once saved
+	checkSealed(t, box, true)
+
+	loaded := reopen(t, box.repo, testMasterPassword)
+	checkSealed(t, loaded, true)
+	if loaded.passwords[ids[0]].Category != "github" {
+		t.Fatal("metadata not decrypted when loaded")
+	}
+	// Get decrypts a copy only
+	if got := getPassword(t, loaded, ids[0]); string(got.PlainPassword) != "pw123456" {
+		t.Fatalf("Get: %q", got.PlainPassword)
+	}
+	checkSealed(t, loaded, true)
+
+	// List caches plaintext until box saved or locked
+	var buf bytes.Buffer
+	if err := loaded.List(&buf, false); err != nil || !strings.Contains(buf.String(), "pw1234567") {
+		t.Fatalf("List: %q, %v", buf.String(), err)
+	}
+	checkSealed(t, loaded, false)
+	if err := loaded.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Unlock(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	checkSealed(t, loaded, true)
+
+	// ephemeral box reveals copies only
+	if err := loaded.SetEphemeralPlaintext(true); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := loaded.Find(&buf, "you"); err != nil || !strings.Contains(buf.String(), "pw1234567") {
+		t.Fatalf("Find: %q, %v", buf.String(), err)
+	}
+	checkSealed(t, loaded, true)
+}
+
+func TestDirtyAndSealedRoundTrip(t *testing.T) {
+	box := newTestBox(t)
+	ids, err := box.AddBatch([]*Password{
+		NewPassword("github", "me", "pw123456", "github.com"),
+		NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+		NewPassword("bitbucket", "them", "pw12345678", "bitbucket.org"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := reopen(t, box.repo, testMasterPassword)
+	before, err := parseBoxData(box.repo.(*MemoryRepository).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one password is changed while others stay sealed
+	passwd := "changed123"
+	if err := loaded.Update(ids[1], PasswordUpdate{Password: &passwd}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Remove([]string{ids[2]}, false); err != nil {
+		t.Fatal(err)
+	}
+	after, err := parseBoxData(box.repo.(*MemoryRepository).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphers := map[string][]byte{}
+	for _, pw := range before.Passwords {
+		ciphers[pw.ID] = pw.CipherPassword
+	}
+	for _, pw := range after.Passwords {
+		if unchanged := bytes.Equal(pw.CipherPassword, ciphers[pw.ID]); unchanged != (pw.ID == ids[0]) {
+			t.Fatalf("password %s re-encrypted %v", pw.ID, !unchanged)
+		}
+	}
+
+	reopened := reopen(t, box.repo, testMasterPassword)
+	for id, want := range map[string]string{ids[0]: "pw123456", ids[1]: "changed123"} {
+		if got := getPassword(t, reopened, id); string(got.PlainPassword) != want {
+			t.Fatalf("password %s is %q, want %q", id, got.PlainPassword, want)
+		}
+	}
+	if trashed, ok := reopened.trash[ids[2]]; !ok || trashed.Category != "bitbucket" {
+		t.Fatal("removed password not in trash")
+	}
+	if err := reopened.withUnsealed(func() error { return nil }); err != nil {
+		t.Fatalf("trash doesn't decrypt: %v", err)
+	}
+}
+
+// newBenchmarkBox returns serialized box of n passwords saved with cheap
+// key derivation, so loading it is dominated by parsing
+func newBenchmarkBox(b *testing.B, n int) ([]byte, Options) {
+	opts := Options{KDF: KDFConfig{Type: KDFPBKDF2, Iterations: 1000}}
+	repo := NewMemoryRepository(nil)
+	box := NewBoxWithOptions(repo, opts)
+	if err := box.Init(testMasterPassword); err != nil {
+		b.Fatal(err)
+	}
+	pws := make([]*Password, n)
+	for i := range pws {
+		pws[i] = NewPassword("category", fmt.Sprintf("account%d", i), fmt.Sprintf("password%d", i), "example.com")
+	}
+	if _, err := box.AddBatch(pws); err != nil {
+		b.Fatal(err)
+	}
+	return repo.Bytes(), opts
+}
+
+func benchmarkLoad(b *testing.B, decryptAll bool) {
+	data, opts := newBenchmarkBox(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		box := NewBoxWithOptions(NewMemoryRepository(data), opts)
+		if err := box.Init(testMasterPassword); err != nil {
+			b.Fatal(err)
+		}
+		if decryptAll {
+			if err := box.unsealAll(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkLoad loads a box of 10k passwords decrypting nothing but
+// metadata, compared with BenchmarkLoadDecryptAll the cost of decryption
+// isn't paid on load
+func BenchmarkLoad(b *testing.B)           { benchmarkLoad(b, false) }
+func BenchmarkLoadDecryptAll(b *testing.B) { benchmarkLoad(b, true) }
+
+func TestSaveUnmodifiedByteIdentical(t *testing.T) {
+	box := newTestBox(t)
+	if _, err := box.AddBatch([]*Password{
+		NewPassword("github", "me", "pw123456", "github.com"),
+		NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	repo := box.repo.(*MemoryRepository)
+	saved := repo.Bytes()
+	checkUnchanged := func(what string, box *Box) {
+		t.Helper()
+		// decrypted passwords are not dirty
+		if err := box.List(io.Discard, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := box.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(repo.Bytes(), saved) {
+			t.Fatalf("%s: box changed by saving\n%s\n%s", what, saved, repo.Bytes())
+		}
+	}
+	checkUnchanged("saved box", box)
+	checkUnchanged("reopened box", reopen(t, repo, testMasterPassword))
+
+	// re-encryption rewrites all ciphers
+	if err := box.ReencryptAll(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(repo.Bytes(), saved) {
+		t.Fatal("box not re-encrypted")
+	}
+	saved = repo.Bytes()
+	checkUnchanged("re-encrypted box", reopen(t, repo, testMasterPassword))
+}
+
+func TestReloadDropsRemovedPasswords(t *testing.T) {
+	box := newTestBox(t)
+	ids, err := box.AddBatch([]*Password{
+		NewPassword("github", "me", "pw123456", "github.com"),
+		NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+		NewPassword("bitbucket", "them", "pw12345678", "bitbucket.org"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := box.Remove([]string{ids[2]}, false); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded := func(what string, box *Box, want ...string) {
+		t.Helper()
+		if len(box.trash) != 0 || len(box.passwords) != len(want) {
+			t.Fatalf("%s: %d passwords and %d in trash, want %v", what, len(box.passwords), len(box.trash), want)
+		}
+		for _, id := range want {
+			if _, ok := box.passwords[id]; !ok {
+				t.Fatalf("%s: password %s not loaded", what, id)
+			}
+		}
+	}
+
+	// removed by another process, then box inited again
+	other := reopen(t, box.repo, testMasterPassword)
+	if _, err := other.Remove([]string{ids[0]}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.EmptyTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded("inited again", box, ids[1])
+	if err := box.Save(); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded("saved after inited again", reopen(t, box.repo, testMasterPassword), ids[1])
+
+	// removed while box locked
+	if err := box.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	other = reopen(t, box.repo, testMasterPassword)
+	if _, err := other.Remove([]string{ids[1]}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.EmptyTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := box.Unlock(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	checkLoaded("unlocked", box)
+}
+
+func TestAddRemoveClear(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// change changes box holding passwords of ids
+		change func(box *Box, ids []string) error
+		// failSaves are number of saves failed
+		failSaves int
+		err       error
+		// passwords and trash are numbers of them after changed
+		passwords, trash int
+	}{
+		{"add", func(box *Box, ids []string) error {
+			_, _, err := box.Add(NewPassword("forum", "me", "pw123", "forum.com"))
+			return err
+		}, 0, nil, 4, 0},
+		{"add fails", func(box *Box, ids []string) error {
+			_, _, err := box.Add(NewPassword("forum", "me", "pw123", "forum.com"))
+			return err
+		}, 1, errInjectedFailure, 3, 0},
+		{"add replacing fails", func(box *Box, ids []string) error {
+			pw := NewPassword("github", "me", "changed123", "github.com")
+			pw.ID = ids[0]
+			_, _, err := box.Add(pw)
+			return err
+		}, 1, errInjectedFailure, 3, 0},
+		{"add with unknown ID", func(box *Box, ids []string) error {
+			pw := NewPassword("github", "me", "changed123", "github.com")
+			pw.ID = "ffffffff"
+			_, _, err := box.Add(pw)
+			return err
+		}, 0, newErrPasswordNotFound("ffffffff"), 3, 0},
+		{"remove", func(box *Box, ids []string) error {
+			_, err := box.Remove([]string{ids[0]}, false)
+			return err
+		}, 0, nil, 2, 1},
+		{"remove fails", func(box *Box, ids []string) error {
+			_, err := box.Remove([]string{ids[0]}, false)
+			return err
+		}, 1, errInjectedFailure, 3, 0},
+		{"remove many fails", func(box *Box, ids []string) error {
+			_, err := box.Remove(ids, false)
+			return err
+		}, 1, errInjectedFailure, 3, 0},
+		{"remove not found", func(box *Box, ids []string) error {
+			_, err := box.Remove([]string{"ffffffff"}, false)
+			return err
+		}, 0, newErrPasswordNotFound("ffffffff"), 3, 0},
+		{"remove by account fails", func(box *Box, ids []string) error {
+			_, err := box.RemoveByAccount("github", "me", false)
+			return err
+		}, 1, errInjectedFailure, 3, 0},
+		{"clear", func(box *Box, ids []string) error {
+			_, err := box.Clear()
+			return err
+		}, 0, nil, 0, 3},
+		{"clear fails", func(box *Box, ids []string) error {
+			_, err := box.Clear()
+			return err
+		}, 1, errInjectedFailure, 3, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewMemRepository(nil)
+			box := NewBox(repo)
+			if err := box.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			ids, err := box.AddBatch([]*Password{
+				NewPassword("github", "me", "pw123456", "github.com"),
+				NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+				NewPassword("bitbucket", "them", "pw12345678", "bitbucket.org"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved := repo.Bytes()
+			repo.FailSaves(tc.failSaves, nil)
+
+			err = tc.change(box, ids)
+			if fmt.Sprint(err) != fmt.Sprint(tc.err) {
+				t.Fatalf("error %v, want %v", err, tc.err)
+			}
+			if len(box.passwords) != tc.passwords || len(box.trash) != tc.trash {
+				t.Fatalf("%d passwords and %d in trash, want %d and %d", len(box.passwords), len(box.trash), tc.passwords, tc.trash)
+			}
+			if err != nil {
+				// box is kept as saved
+				if !bytes.Equal(repo.Bytes(), saved) {
+					t.Fatal("repository changed")
+				}
+				if got := getPassword(t, box, ids[0]); string(got.PlainPassword) != "pw123456" {
+					t.Fatalf("password is %q", got.PlainPassword)
+				}
+			}
+			// box in memory is the one saved
+			loaded := reopen(t, repo, testMasterPassword)
+			if len(loaded.passwords) != tc.passwords || len(loaded.trash) != tc.trash {
+				t.Fatalf("%d passwords and %d in trash saved", len(loaded.passwords), len(loaded.trash))
+			}
+		})
+	}
+}
+
+func TestInitLoadFails(t *testing.T) {
+	repo := NewMemRepository(nil)
+	if err := NewBox(repo).Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	repo.FailLoads(1, nil)
+	box := NewBox(repo)
+	if err := box.Init(testMasterPassword); err != errInjectedFailure {
+		t.Fatalf("Init: %v", err)
+	}
+	if _, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com")); err != errEmptyMasterPassword {
+		t.Fatalf("Add to box failed to load: %v", err)
+	}
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookupByPrefix(t *testing.T) {
+	box := newTestBox(t)
+	// 17 IDs of hex share the first digit at least by two
+	passwords := []*Password{}
+	for i := 0; i < 17; i++ {
+		pw := NewPassword("github", fmt.Sprintf("me%d", i), "pw123456", "github.com")
+		pw.PlainTOTPSecret = []byte("JBSWY3DPEHPK3PXP")
+		passwords = append(passwords, pw)
+	}
+	ids, err := box.AddBatch(passwords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ambiguous := ""
+	for i, id := range ids {
+		for _, other := range ids[i+1:] {
+			if id[0] == other[0] {
+				ambiguous = id[:1]
+			}
+		}
+	}
+	recipient, _, err := GenerateShareKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, lookup := range map[string]func(id string) error{
+		"SetField": func(id string) error { return box.SetField(id, "pin", "1234") },
+		"GetField": func(id string) error { _, err := box.GetField(id, "pin"); return err },
+		"History":  func(id string) error { _, err := box.History(id); return err },
+		"TOTP":     func(id string) error { _, _, err := box.TOTP(id); return err },
+		"ShareEntry": func(id string) error {
+			_, err := box.ShareEntry(id, recipient)
+			return err
+		},
+	} {
+		if err := lookup(ids[0][:len(ids[0])-1]); err != nil {
+			t.Fatalf("%s by unique prefix: %v", name, err)
+		}
+		if err := lookup(ambiguous); !errors.Is(err, errAmbiguous) {
+			t.Fatalf("%s by ambiguous prefix: %v", name, err)
+		}
+	}
+}
+
+func TestRekeyCountsTrash(t *testing.T) {
+	box := newTestBox(t)
+	ids, err := box.AddBatch([]*Password{
+		NewPassword("github", "me", "pw123456", "github.com"),
+		NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := box.Remove([]string{ids[1]}, false); err != nil {
+		t.Fatal(err)
+	}
+	trashed := string(box.trash[ids[1]].CipherPassword)
+	if n, err := box.Rekey(); err != nil || n != 2 {
+		t.Fatalf("Rekey: %d, %v", n, err)
+	}
+	if string(box.trash[ids[1]].CipherPassword) == trashed {
+		t.Fatal("password in trash not rotated")
+	}
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// bundleMagic prefixes encrypted exports written by ExportEncrypted
+var bundleMagic = []byte("onepw-encrypted-export\n")
+
+// bundleVersion is version of format of encrypted exports
+const bundleVersion = 1
+
+// encryptedBundle is envelope of an encrypted export. Data is bundleEntry
+// list encrypted by AES-GCM with a key derived from passphrase of export
+// by KDF, it has nothing to do with keys of box.
+type encryptedBundle struct {
+	Version int
+	KDF     KDFConfig
+	IV      []byte
+	Data    []byte
+}
+
+// additionalData returns data authenticated with Data, so header can't be modified
+func (eb encryptedBundle) additionalData() ([]byte, error) {
+	eb.IV, eb.Data = nil, nil
+	header, err := json.Marshal(eb)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, bundleMagic...), header...), nil
+}
+
+// bundleEntry is a decrypted password in encrypted exports
+type bundleEntry struct {
+	ID            string
+	Category      string
+	Account       []byte
+	Password      []byte
+	Notes         []byte            `json:",omitempty"`
+	TOTPSecret    []byte            `json:",omitempty"`
+	CustomFields  map[string]string `json:",omitempty"`
+	Site          string
+	URL           string          `json:",omitempty"`
+	Tags          []string        `json:",omitempty"`
+	Ext           string          `json:",omitempty"`
+	ExpiresAt     int64           `json:",omitempty"`
+	History       []bundleHistory `json:",omitempty"`
+	CreatedAt     int64
+	LastUpdatedAt int64
+}
+
+type bundleHistory struct {
+	Password   []byte
+	ReplacedAt int64
+}
+
+// newBundleEntry returns entry of decrypted pw. Derived passwords are
+// exported as stored ones, another box would derive other passwords.
+func newBundleEntry(pw *Password) bundleEntry {
+	e := bundleEntry{
+		ID:            pw.ID,
+		Category:      pw.Category,
+		Account:       pw.PlainAccount,
+		Password:      pw.PlainPassword,
+		Notes:         pw.PlainNotes,
+		TOTPSecret:    pw.PlainTOTPSecret,
+		CustomFields:  pw.CustomFields,
+		Site:          pw.Site,
+		URL:           pw.URL,
+		Tags:          pw.Tags,
+		Ext:           pw.Ext,
+		ExpiresAt:     pw.ExpiresAt,
+		CreatedAt:     pw.CreatedAt,
+		LastUpdatedAt: pw.LastUpdatedAt,
+	}
+	for _, h := range pw.History {
+		e.History = append(e.History, bundleHistory{Password: h.PlainPassword, ReplacedAt: h.ReplacedAt})
+	}
+	return e
+}
+
+// password returns decrypted Password of e, secrets are shared with e
+func (e *bundleEntry) password() *Password {
+	pw := NewPassword(e.Category, "", "", e.Site)
+	pw.ID = e.ID
+	pw.PlainAccount = e.Account
+	pw.PlainPassword = e.Password
+	pw.PlainNotes = e.Notes
+	pw.PlainTOTPSecret = e.TOTPSecret
+	pw.CustomFields = e.CustomFields
+	pw.URL = e.URL
+	if e.Tags != nil {
+		pw.Tags = e.Tags
+	}
+	pw.Ext = e.Ext
+	pw.ExpiresAt = e.ExpiresAt
+	for _, h := range e.History {
+		pw.History = append(pw.History, HistoryEntry{PlainPassword: h.Password, ReplacedAt: h.ReplacedAt})
+	}
+	pw.CreatedAt = e.CreatedAt
+	pw.LastUpdatedAt = e.LastUpdatedAt
+	return pw
+}
+
+// bundleAEAD returns AEAD keyed by passphrase through cfg
+func bundleAEAD(cfg KDFConfig, passphrase string) (cipher.AEAD, error) {
+	key, err := cfg.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(key).Wipe()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ExportEncrypted writes all passwords to w encrypted by a key derived from
+// passphrase with its own salt, so the export can be restored or shared
+// without revealing master password. Passphrase is checked by master
+// password policy of box.
+func (box *Box) ExportEncrypted(w io.Writer, passphrase string) error {
+	if err := box.masterPasswordPolicy().Check(passphrase); err != nil {
+		return err
+	}
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	entries := make([]bundleEntry, 0, len(passwords))
+	for i := range passwords {
+		entries = append(entries, newBundleEntry(&passwords[i]))
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	defer Secret(data).Wipe()
+
+	eb := encryptedBundle{Version: bundleVersion}
+	if eb.KDF, err = box.options.KDF.withSalt(); err != nil {
+		return err
+	}
+	aead, err := bundleAEAD(eb.KDF, passphrase)
+	if err != nil {
+		return err
+	}
+	ad, err := eb.additionalData()
+	if err != nil {
+		return err
+	}
+	if eb.IV, err = randomBytes(aead.NonceSize()); err != nil {
+		return err
+	}
+	eb.Data = aead.Seal(nil, eb.IV, data, ad)
+	out, err := json.MarshalIndent(eb, "", "    ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(bundleMagic); err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// ImportEncrypted decrypts export written by ExportEncrypted by passphrase,
+// and merges its passwords into box by ID, the newest passwords are kept.
+// Passwords updated at the same time in both are returned as conflicts.
+func (box *Box) ImportEncrypted(r io.Reader, passphrase string) ([]Conflict, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, bundleMagic) {
+		return nil, errNotEncryptedExport
+	}
+	eb := encryptedBundle{}
+	if err := json.Unmarshal(data[len(bundleMagic):], &eb); err != nil {
+		return nil, errNotEncryptedExport
+	}
+	if eb.Version > bundleVersion {
+		return nil, newErrUnsupportedVersion(eb.Version)
+	}
+	if eb.KDF.isLegacy() {
+		return nil, errInvalidKDFParams
+	}
+	if err := eb.KDF.checkLimits(); err != nil {
+		return nil, err
+	}
+	if err := box.checkRestrictedKDF(eb.KDF); err != nil {
+		return nil, err
+	}
+	aead, err := bundleAEAD(eb.KDF, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ad, err := eb.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	if len(eb.IV) != aead.NonceSize() {
+		return nil, errLengthOfIV
+	}
+	plaintext, err := aead.Open(nil, eb.IV, eb.Data, ad)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	defer Secret(plaintext).Wipe()
+	entries := []bundleEntry{}
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, err
+	}
+	passwords := make(map[string]*Password, len(entries))
+	for i := range entries {
+		pw := entries[i].password()
+		if pw.ID == "" {
+			return nil, errNotEncryptedExport
+		}
+		passwords[pw.ID] = pw
+	}
+	defer func() {
+		for _, pw := range passwords {
+			pw.wipe()
+		}
+	}()
+
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return nil, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var conflicts []Conflict
+	err = box.withUnsealed(func() error {
+		var err error
+		conflicts, err = box.mergePasswords(passwords, MergeNewest)
+		return err
+	})
+	return conflicts, err
+}
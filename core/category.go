@@ -0,0 +1,70 @@
+package core
+
+import (
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/mkideal/pkg/textutil"
+)
+
+// categoryHeader is header of table written by PrintCategories
+var categoryHeader = []string{"CATEGORY", "COUNT"}
+
+// Categories returns number of passwords of each category. Category is
+// kept in plaintext in memory, so nothing is decrypted.
+func (box *Box) Categories() map[string]int {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	categories := map[string]int{}
+	for _, pw := range box.passwords {
+		categories[pw.Category]++
+	}
+	return categories
+}
+
+// CountByCategory returns number of passwords of category, master password
+// isn't required unless metadata of box is encrypted
+func (box *Box) CountByCategory(category string) int {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	n := 0
+	for _, pw := range box.passwords {
+		if pw.Category == category {
+			n++
+		}
+	}
+	return n
+}
+
+// PrintCategories writes categories and their numbers of passwords sorted
+// by category to specified writer
+func (box *Box) PrintCategories(w io.Writer) {
+	counts := box.Categories()
+	table := make(categorySlice, 0, len(counts))
+	for category, count := range counts {
+		table = append(table, categoryCount{category, count})
+	}
+	sort.Sort(table)
+	textutil.WriteTable(w, textutil.AddTableHeader(table, categoryHeader))
+}
+
+type categoryCount struct {
+	category string
+	count    int
+}
+
+// sort categories by name
+type categorySlice []categoryCount
+
+func (cs categorySlice) Len() int           { return len(cs) }
+func (cs categorySlice) Less(i, j int) bool { return cs[i].category < cs[j].category }
+func (cs categorySlice) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
+func (cs categorySlice) RowCount() int      { return cs.Len() }
+func (cs categorySlice) ColCount() int      { return len(categoryHeader) }
+func (cs categorySlice) Get(i, j int) string {
+	if j == 0 {
+		return cs[i].category
+	}
+	return strconv.Itoa(cs[i].count)
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/atotto/clipboard"
+)
+
+// DefaultClipboardClear is how long a secret copied by CopyToClipboard
+// stays in clipboard by default
+const DefaultClipboardClear = 30 * time.Second
+
+// pendingClears counts clipboards scheduled to clear
+var pendingClears sync.WaitGroup
+
+// CopyToClipboard copies field of password id to clipboard, id may be a
+// prefix of ID as Remove accepts. Clipboard is cleared after clearAfter,
+// unless it holds something else copied meanwhile, it's never cleared if
+// clearAfter isn't positive. Clipboard is cleared by a goroutine, so a
+// program exiting soon after should call WaitClipboard.
+func (box *Box) CopyToClipboard(id string, field Field, clearAfter time.Duration) error {
+	secret, err := box.fieldSecret(id, field)
+	if err != nil {
+		return err
+	}
+	// clipboard takes strings only, copies of them can't be wiped
+	value := secret.String()
+	secret.Wipe()
+	if err := clipboard.WriteAll(value); err != nil {
+		return err
+	}
+	if clearAfter <= 0 {
+		return nil
+	}
+	pendingClears.Add(1)
+	go func() {
+		defer pendingClears.Done()
+		time.Sleep(clearAfter)
+		if current, err := clipboard.ReadAll(); err == nil && current == value {
+			clipboard.WriteAll("")
+		}
+	}()
+	return nil
+}
+
+// WaitClipboard blocks until all clipboards scheduled to clear by
+// CopyToClipboard are cleared
+func WaitClipboard() {
+	pendingClears.Wait()
+}
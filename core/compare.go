@@ -0,0 +1,74 @@
+package core
+
+import (
+	"crypto/subtle"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Secrets and values derived from secrets are compared by the helpers
+// below, their time depends on lengths only, never on the position of
+// the first mismatch.
+
+// secretEqual reports whether a and b are equal in constant time
+func secretEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// secretEqualString is secretEqual of strings
+func secretEqualString(a, b string) bool {
+	return secretEqual([]byte(a), []byte(b))
+}
+
+// secretContains reports whether substr is within s, every position of s is
+// compared even if substr was found
+func secretContains(s, substr []byte) bool {
+	found := 0
+	for i := 0; i+len(substr) <= len(s); i++ {
+		found |= subtle.ConstantTimeCompare(s[i:i+len(substr)], substr)
+	}
+	return found == 1 || len(substr) == 0
+}
+
+// secretContainsFold is secretContains ignoring case by foldCase, folded
+// copies of s and substr are wiped after compared
+func secretContainsFold(s, substr []byte) bool {
+	folded, foldedSubstr := foldCase(s), foldCase(substr)
+	defer Secret(folded).Wipe()
+	defer Secret(foldedSubstr).Wipe()
+	return secretContains(folded, foldedSubstr)
+}
+
+// Case is ignored by a single rule everywhere, plaintext and secrets alike:
+// characters are folded by foldRune.
+
+// foldRune returns the smallest character whose case folds to r, e.g. 'K'
+// for 'k' and Kelvin sign, so characters equal by unicode.SimpleFold
+// fold to the same one
+func foldRune(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+	return folded
+}
+
+// foldCase returns a copy of s with each character folded by foldRune,
+// invalid UTF-8 is copied as it is. A folded character never encodes
+// longer than itself, so the copy is never reallocated and no partial
+// copy of a secret is left.
+func foldCase(s []byte) []byte {
+	folded := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		if r == utf8.RuneError && size == 1 {
+			folded = append(folded, s[0])
+		} else {
+			folded = utf8.AppendRune(folded, foldRune(r))
+		}
+		s = s[size:]
+	}
+	return folded
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestContainsFold(t *testing.T) {
+	for _, tt := range []struct {
+		s, substr string
+		want      bool
+	}{
+		{"GitHub", "git", true},
+		{"GitHub", "HUB", true},
+		{"GitHub", "lab", false},
+		{"Ärger", "äR", true},
+		{"ПАРОЛЬ", "пароль", true},
+		{"Kelvin", "Kelvin", true},
+		{"KELVIN", "kelvin", true},
+		{"ΣΊΣΥΦΟΣ", "σίσυφος", true},
+		{"café", "CAFÉ", true},
+		{"café", "cafe", false},
+		{"anything", "", true},
+		{"", "x", false},
+		{"bad \xff utf8", "\xff UTF8", true},
+	} {
+		if got := containsFold(tt.s, tt.substr); got != tt.want {
+			t.Errorf("containsFold(%q, %q) = %v", tt.s, tt.substr, got)
+		}
+		if got := secretContainsFold([]byte(tt.s), []byte(tt.substr)); got != tt.want {
+			t.Errorf("secretContainsFold(%q, %q) = %v", tt.s, tt.substr, got)
+		}
+	}
+}
+
+func TestFindWithIgnoreCaseNonASCII(t *testing.T) {
+	box := newTestBox(t)
+	id, _, err := box.Add(NewPassword("Почта", "Jürgen", "pw123456", "example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, word := range []string{"почта", "JÜRGEN", "ürg"} {
+		var buf bytes.Buffer
+		if err := box.FindWith(&buf, word, MatchOptions{IgnoreCase: true}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), id[:shortIDLength]) {
+			t.Errorf("FindWith(%q) found %q", word, buf.String())
+		}
+	}
+}
+
+func TestSecretEqual(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"pw123456", "pw123456", true},
+		{"pw123456", "pw123457", false},
+		{"pw123456", "Pw123456", false},
+		{"pw123456", "pw12345", false},
+		{"pw12345", "pw123456", false},
+		{"", "", true},
+		{"", "x", false},
+	} {
+		if got := secretEqual([]byte(tt.a), []byte(tt.b)); got != tt.want {
+			t.Errorf("secretEqual(%q, %q) = %v", tt.a, tt.b, got)
+		}
+		if got := secretEqualString(tt.a, tt.b); got != tt.want {
+			t.Errorf("secretEqualString(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+	// nil and empty secrets are equal
+	if !secretEqual(nil, []byte{}) {
+		t.Error("nil not equal to empty")
+	}
+}
+
+func TestSecretContains(t *testing.T) {
+	for _, tt := range []struct {
+		s, substr string
+	}{
+		{"pw123456", "pw"},
+		{"pw123456", "456"},
+		{"pw123456", "123"},
+		{"pw123456", "pw123456"},
+		{"pw123456", "pw1234567"},
+		{"pw123456", "654"},
+		{"pw123456", "PW"},
+		{"pw123456", ""},
+		{"", ""},
+		{"", "x"},
+		{"aaab", "aab"},
+	} {
+		want := strings.Contains(tt.s, tt.substr)
+		if got := secretContains([]byte(tt.s), []byte(tt.substr)); got != want {
+			t.Errorf("secretContains(%q, %q) = %v", tt.s, tt.substr, got)
+		}
+	}
+}
+
+// BenchmarkSecretEqual compares secrets mismatched at different positions,
+// time per op should be the same for all of them
+func BenchmarkSecretEqual(b *testing.B) {
+	secret := bytes.Repeat([]byte("x"), 64)
+	for _, pos := range []int{0, 32, 63, -1} {
+		other := append([]byte{}, secret...)
+		name := "match"
+		if pos >= 0 {
+			other[pos] = 'y'
+			name = fmt.Sprintf("mismatch-at-%d", pos)
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				secretEqual(secret, other)
+			}
+		})
+	}
+}
+
+// BenchmarkSecretContains searches secrets for a word found at different
+// positions or not at all, time per op should be the same for all of them
+func BenchmarkSecretContains(b *testing.B) {
+	word := []byte("needle")
+	for _, pos := range []int{0, 29, 58, -1} {
+		s := bytes.Repeat([]byte("x"), 64)
+		name := "not-found"
+		if pos >= 0 {
+			copy(s[pos:], word)
+			name = fmt.Sprintf("found-at-%d", pos)
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				secretContains(s, word)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is service name of credentials stored in keychain of OS
+const keyringService = "onepw"
+
+// CredentialCache caches secrets, e.g. master passwords of boxes, so they
+// needn't be typed every time
+type CredentialCache interface {
+	// Put caches secret by key
+	Put(key string, secret []byte) error
+	// Get returns secret cached by key, nil returned if not cached
+	Get(key string) ([]byte, error)
+	// Delete removes secret cached by key, it's not an error if not cached
+	Delete(key string) error
+}
+
+// KeyringCache implements CredentialCache interface by keychain of OS:
+// Keychain on macOS, Secret Service on Linux and Credential Manager on Windows
+type KeyringCache struct {
+	Service string
+}
+
+// NewKeyringCache creates a KeyringCache
+func NewKeyringCache() *KeyringCache {
+	return &KeyringCache{Service: keyringService}
+}
+
+// Put implements CredentialCache.Put method
+func (cache *KeyringCache) Put(key string, secret []byte) error {
+	return keyring.Set(cache.Service, key, string(secret))
+}
+
+// Get implements CredentialCache.Get method
+func (cache *KeyringCache) Get(key string) ([]byte, error) {
+	secret, err := keyring.Get(cache.Service, key)
+	if err == keyring.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(secret), nil
+}
+
+// Delete implements CredentialCache.Delete method
+func (cache *KeyringCache) Delete(key string) error {
+	err := keyring.Delete(cache.Service, key)
+	if err == keyring.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,256 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvHeader is header row of CSV written by ExportCSV
+var csvHeader = []string{"id", "category", "account", "password", "url", "notes"}
+
+// ExportCSV writes all passwords to w as CSV in plaintext, for migrating
+// to another password manager. Anyone who can read the output can read
+// every password, so it should be written to a safe place and removed
+// after use.
+func (box *Box) ExportCSV(w io.Writer) error {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, pw := range passwords {
+		row := []string{
+			pw.ID,
+			pw.Category,
+			pw.PlainAccount.String(),
+			pw.PlainPassword.String(),
+			pw.URL,
+			pw.PlainNotes.String(),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ColumnMapping maps fields of passwords to columns of CSV imported by
+// ImportCSV, columns are named by header row of CSV. Account and Password
+// are required, the other fields are optional and skipped if empty, e.g.
+// ColumnMapping{Category: "name", Account: "username", Password: "password",
+// URL: "url", Notes: "extra"} for exports of LastPass.
+type ColumnMapping struct {
+	Category string
+	Account  string
+	Password string
+	Site     string
+	URL      string
+	Notes    string
+}
+
+// DefaultColumnMapping returns mapping of CSV written by ExportCSV
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		Category: "category",
+		Account:  "account",
+		Password: "password",
+		URL:      "url",
+		Notes:    "notes",
+	}
+}
+
+// CSVRowError is error of a row skipped by ImportCSV, Line is line number
+// of the row in CSV
+type CSVRowError struct {
+	Line int
+	Err  error
+}
+
+// CSVImportError is returned by ImportCSV if some rows were skipped, the
+// other rows are imported
+type CSVImportError struct {
+	Rows []CSVRowError
+}
+
+// Error implements error interface
+func (err *CSVImportError) Error() string {
+	lines := make([]string, 0, len(err.Rows))
+	for _, row := range err.Rows {
+		lines = append(lines, fmt.Sprintf("line %d: %v", row.Line, row.Err))
+	}
+	return fmt.Sprintf("%d rows skipped: %s", len(err.Rows), strings.Join(lines, "; "))
+}
+
+// csvColumns is indexes of mapped columns, -1 for unmapped ones
+type csvColumns struct {
+	category, account, password, site, url, notes int
+}
+
+func (mapping ColumnMapping) columns(header []string) (csvColumns, error) {
+	index := func(name string, required bool) (int, error) {
+		if name == "" && !required {
+			return -1, nil
+		}
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), name) {
+				return i, nil
+			}
+		}
+		if required {
+			return -1, newErrCSVColumnNotFound(name)
+		}
+		return -1, nil
+	}
+	cols := csvColumns{}
+	var err error
+	if cols.account, err = index(mapping.Account, true); err != nil {
+		return cols, err
+	}
+	if cols.password, err = index(mapping.Password, true); err != nil {
+		return cols, err
+	}
+	cols.category, _ = index(mapping.Category, false)
+	cols.site, _ = index(mapping.Site, false)
+	cols.url, _ = index(mapping.URL, false)
+	cols.notes, _ = index(mapping.Notes, false)
+	return cols, nil
+}
+
+// ImportCSV adds a password for each row of CSV read from r, columns are
+// mapped by mapping. Rows without account or password are skipped and
+// reported by a *CSVImportError after the other rows are added. Rows are
+// added by AddBatch, none of them is added if any fails.
+func (box *Box) ImportCSV(r io.Reader, mapping ColumnMapping) (added int, err error) {
+	return box.importCSV(r, mapping, false)
+}
+
+// chromeColumns maps columns of passwords exported by Chrome and Chromium
+var chromeColumns = ColumnMapping{
+	Category: "name",
+	Account:  "username",
+	Password: "password",
+	URL:      "url",
+}
+
+// ImportChromeCSV adds passwords exported by Chrome or Chromium, columns
+// name, url, username and password are mapped to category, URL, account
+// and password. Rows whose category and account already exist in box are
+// skipped as duplicates, so a file can be imported again. Skipped rows are
+// reported by a *CSVImportError, number of skipped rows is its length.
+func (box *Box) ImportChromeCSV(r io.Reader) (int, error) {
+	return box.importCSV(r, chromeColumns, true)
+}
+
+// accountDigests returns digests of category and account of passwords in
+// box, keyed by macKey so accounts aren't kept in plaintext
+func (box *Box) accountDigests(macKey []byte) (map[string]bool, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe()
+	digests := make(map[string]bool, len(passwords))
+	for i := range passwords {
+		digests[accountDigest(macKey, passwords[i].Category, passwords[i].PlainAccount)] = true
+	}
+	return digests, nil
+}
+
+func accountDigest(macKey []byte, category string, account []byte) string {
+	data := append(append([]byte(category), 0), account...)
+	defer Secret(data).Wipe()
+	return hex.EncodeToString(hmacSHA256(macKey, data))
+}
+
+// importCSV adds rows of CSV, rows whose category and account exist are
+// skipped if dedupe
+func (box *Box) importCSV(r io.Reader, mapping ColumnMapping, dedupe bool) (added int, err error) {
+	var (
+		macKey []byte
+		seen   map[string]bool
+	)
+	if dedupe {
+		if macKey, err = randomBytes(keyLength); err != nil {
+			return 0, err
+		}
+		defer Secret(macKey).Wipe()
+		if seen, err = box.accountDigests(macKey); err != nil {
+			return 0, err
+		}
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return 0, err
+	}
+	cols, err := mapping.columns(header)
+	if err != nil {
+		return 0, err
+	}
+	skipped := &CSVImportError{}
+	pws := []*Password{}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return row[i]
+		}
+		account, passwd := field(cols.account), field(cols.password)
+		if account == "" || passwd == "" {
+			skipped.Rows = append(skipped.Rows, CSVRowError{Line: line, Err: errMissingAccountOrPassword})
+			continue
+		}
+		if dedupe {
+			digest := accountDigest(macKey, field(cols.category), []byte(account))
+			if seen[digest] {
+				skipped.Rows = append(skipped.Rows, CSVRowError{Line: line, Err: errDuplicateAccount})
+				continue
+			}
+			seen[digest] = true
+		}
+		pw := NewPassword(field(cols.category), account, passwd, field(cols.site))
+		pw.URL = field(cols.url)
+		if notes := field(cols.notes); notes != "" {
+			pw.PlainNotes = Secret(notes)
+		}
+		pws = append(pws, pw)
+	}
+	if _, err := box.AddBatch(pws); err != nil {
+		return 0, err
+	}
+	added = len(pws)
+	if len(skipped.Rows) > 0 {
+		return added, skipped
+	}
+	return added, nil
+}
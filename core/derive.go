@@ -0,0 +1,123 @@
+package core
+
+import (
+	"crypto/sha256"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// limits of parameters of derived passwords
+const (
+	defaultDeriveLength = 20
+	maxDeriveLength     = 128
+)
+
+// deriveCharset returns characters which derived password of pw is made of
+func (pw *Password) deriveCharset() string {
+	if pw.DeriveCharset != "" {
+		return pw.DeriveCharset
+	}
+	return lowerChars + upperChars + digitChars + symbolChars
+}
+
+func (pw *Password) deriveLength() int {
+	if pw.DeriveLength != 0 {
+		return pw.DeriveLength
+	}
+	return defaultDeriveLength
+}
+
+// checkDeriveParams checks parameters of derived password pw
+func (pw *Password) checkDeriveParams() error {
+	if n := pw.deriveLength(); n < 6 || n > maxDeriveLength {
+		return errInvalidDeriveParams
+	}
+	charset := pw.deriveCharset()
+	if len(charset) < 2 || pw.DeriveCounter < 0 {
+		return errInvalidDeriveParams
+	}
+	for i := 0; i < len(charset); i++ {
+		if charset[i] >= utf8.RuneSelf {
+			return errInvalidDeriveParams
+		}
+	}
+	return nil
+}
+
+// derivePassword derives password of pw from data key of box, site, account
+// and counter. Data key isn't changed with master password, so derived
+// passwords are kept after master password changed, but they can't be
+// derived without the box file. Characters are picked uniformly from charset by rejecting
+// bytes out of the largest multiple of length of charset.
+func (keys *boxKeys) derivePassword(pw *Password) (Secret, error) {
+	if err := pw.checkDeriveParams(); err != nil {
+		return nil, err
+	}
+	key, err := hkdfKey(keys.master, derivedLabel)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(key).Wipe()
+	info := make([]byte, 0, len(pw.Site)+len(pw.PlainAccount)+16)
+	info = append(append(info, pw.Site...), 0)
+	info = append(append(info, pw.PlainAccount...), 0)
+	info = strconv.AppendInt(info, int64(pw.DeriveCounter), 10)
+	defer Secret(info).Wipe()
+
+	charset := pw.deriveCharset()
+	limit := 256 - 256%len(charset)
+	stream := hkdf.Expand(sha256.New, key, info)
+	passwd := make(Secret, pw.deriveLength())
+	b := []byte{0}
+	for i := 0; i < len(passwd); {
+		if _, err := io.ReadFull(stream, b); err != nil {
+			passwd.Wipe()
+			return nil, err
+		}
+		if int(b[0]) >= limit {
+			continue
+		}
+		passwd[i] = charset[int(b[0])%len(charset)]
+		i++
+	}
+	b[0] = 0
+	return passwd, nil
+}
+
+// RotateDerived increases counter of derived password id, so a new
+// password is derived when a site forces a rotation. id may be a prefix of
+// ID as Remove accepts.
+func (box *Box) RotateDerived(id string) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	pw, err := box.lookup(id)
+	if err != nil {
+		return err
+	}
+	if !pw.Derived {
+		return newErrNotDerived(id)
+	}
+	if pw.sealed {
+		if err := box.keys.decrypt(pw); err != nil {
+			return err
+		}
+	}
+	pw.DeriveCounter++
+	pw.LastUpdatedAt = time.Now().Unix()
+	if err := box.encrypt(pw); err != nil {
+		return err
+	}
+	return box.save()
+}
@@ -0,0 +1,85 @@
+package core
+
+import "testing"
+
+func addDerived(t *testing.T, box *Box) (string, string) {
+	t.Helper()
+	pw := NewPassword("forum", "me", "", "forum.example.com")
+	pw.Derived = true
+	pw.DeriveLength = 16
+	id, _, err := box.Add(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := box.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.wipe()
+	if len(got.PlainPassword) != 16 {
+		t.Fatalf("derived password %q", got.PlainPassword)
+	}
+	return id, string(got.PlainPassword)
+}
+
+func TestDerivedPasswordKeptAfterMasterPasswordChanged(t *testing.T) {
+	box := newTestBox(t)
+	id, passwd := addDerived(t, box)
+	if err := box.ChangeMasterPassword(testMasterPassword, "n3w-Master#pw", 0); err != nil {
+		t.Fatal(err)
+	}
+	reopened := NewBox(box.repo)
+	if err := reopened.Init("n3w-Master#pw"); err != nil {
+		t.Fatal(err)
+	}
+	if got := getPassword(t, reopened, id); string(got.PlainPassword) != passwd || !got.Derived {
+		t.Fatalf("derived password changed: %q", got.PlainPassword)
+	}
+}
+
+func TestRotateDerivedByPrefix(t *testing.T) {
+	box := newTestBox(t)
+	id, passwd := addDerived(t, box)
+	if err := box.RotateDerived(id[:shortIDLength]); err != nil {
+		t.Fatal(err)
+	}
+	got := getPassword(t, box, id)
+	if got.DeriveCounter != 1 || string(got.PlainPassword) == passwd {
+		t.Fatalf("not rotated: counter %d, password %q", got.DeriveCounter, got.PlainPassword)
+	}
+	if err := box.RotateDerived("nonexistent"); err == nil {
+		t.Fatal("rotated unknown password")
+	}
+}
+
+func TestUpdateDeriveInputStoresPassword(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		changes func() PasswordUpdate
+		derived bool
+	}{
+		{"account", func() PasswordUpdate { account := "someone"; return PasswordUpdate{Account: &account} }, false},
+		{"site", func() PasswordUpdate { site := "new.example.com"; return PasswordUpdate{Site: &site} }, false},
+		{"same site", func() PasswordUpdate { site := "forum.example.com"; return PasswordUpdate{Site: &site} }, true},
+		{"url", func() PasswordUpdate { url := "https://forum.example.com"; return PasswordUpdate{URL: &url} }, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			box := newTestBox(t)
+			id, passwd := addDerived(t, box)
+			if err := box.Update(id, tt.changes()); err != nil {
+				t.Fatal(err)
+			}
+			reopened := NewBox(box.repo)
+			if err := reopened.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			got := getPassword(t, reopened, id)
+			if got.Derived != tt.derived {
+				t.Fatalf("derived %v", got.Derived)
+			}
+			if string(got.PlainPassword) != passwd {
+				t.Fatalf("password changed from %q to %q", passwd, got.PlainPassword)
+			}
+		})
+	}
+}
@@ -4,33 +4,211 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
 	"sort"
+	"strings"
 
 	"github.com/mkideal/pkg/textutil"
 )
 
 var (
-	errAmbiguous              = errors.New("ambiguous")
-	errAllocateID             = errors.New("allocate id fail")
-	errEmptyMasterPassword    = errors.New("master password is empty")
-	errMasterPasswordTooShort = errors.New("master password too short")
-	errPasswordTooShort       = errors.New("password too short")
-	errNotFullBlock           = errors.New("cipher bytes not full block")
-	errLengthOfIV             = errors.New("IV length not equal to block size")
+	errAmbiguous                    = errors.New("ambiguous")
+	errEmptyMasterPassword          = errors.New("master password is empty")
+	errBoxNotInitialized            = errors.New("box is not initialized with master password")
+	errBoxLocked                    = errors.New("box is locked")
+	errPasswordTooShort             = errors.New("password too short")
+	errNotFullBlock                 = errors.New("cipher bytes not full block")
+	errLengthOfIV                   = errors.New("IV length not equal to block size")
+	errEmptySalt                    = errors.New("salt of key derivation is empty")
+	errInvalidKDFParams             = errors.New("invalid key derivation parameters")
+	errKDFParamsTooLarge            = errors.New("key derivation parameters exceed limits")
+	errWrongMasterPassword          = errors.New("wrong master password")
+	errProbablyWrongMasterPassword  = errors.New("master password is probably wrong, decrypted accounts look garbled")
+	errBoxTampered                  = errors.New("MAC of box mismatch, box file was tampered")
+	errKeyfileRequired              = errors.New("box requires a keyfile")
+	errWrongMasterPasswordOrKeyfile = errors.New("wrong master password or keyfile")
+	errEmptyKeyfile                 = errors.New("keyfile is empty")
+	errAuthFailed                   = errors.New("authentication failed, box is corrupted or master password is wrong")
+	errSQLiteSchemaTooNew           = errors.New("schema of sqlite repository is newer than supported")
+	errHintTooLong                  = errors.New("hint too long")
+	errHintContainsMasterPassword   = errors.New("hint must not contain master password")
+	errTokenRequired                = errors.New("box requires a hardware token, but no challenge-response is configured")
+	errEmptyTokenResponse           = errors.New("response of hardware token is empty")
+	errTokenWithKeySlots            = errors.New("hardware token can't be changed while box has more than one key slot")
+	errRemoveLastKeySlot            = errors.New("the last key slot can't be removed")
+	errRemoveCurrentKeySlot         = errors.New("key slot of current master password can't be removed")
+	errPepperRequired               = errors.New("box requires a pepper, set it by environment variable " + PepperEnv)
+	errInvalidTOTPSecret            = errors.New("TOTP secret is not valid base32")
+	errNotExport                    = errors.New("not an export of onepw")
+	errExportKeyMismatch            = errors.New("export was signed by another key")
+	errExportTampered               = errors.New("signature of export mismatch, export was modified")
+	errInvalidDeriveParams          = errors.New("length of derived password must be 6 to 128, charset at least 2 ASCII characters")
+	errMissingAccountOrPassword     = errors.New("account or password is empty")
+	errRestrictedMD5                = errors.New("box keyed by md5 is not allowed in restricted mode, open it by an unrestricted build to upgrade it first")
+	errAgentFrameTooLarge           = errors.New("frame of agent protocol too large")
+	errInvalidAgentRequest          = errors.New("invalid request to agent")
+	errDuplicateAccount             = errors.New("password of the category and account exists")
+	errDuplicateID                  = errors.New("duplicate id")
+	errEmptyCharset                 = errors.New("no characters included for generating password")
+	errNotEncryptedExport           = errors.New("not an encrypted export of onepw")
+	errWrongPassphrase              = errors.New("wrong passphrase or encrypted export was modified")
+	errEmptyFieldKey                = errors.New("key of field is empty")
+	errInvalidShareKey              = errors.New("invalid X25519 key for sharing")
+	errNotSharedEntry               = errors.New("not an entry shared by onepw")
+	errSharedEntryAuthFailed        = errors.New("shared entry is modified or not shared to this key")
+	errWrongPepper                  = errors.New("wrong pepper")
+	errEmptyPepper                  = errors.New("pepper is empty")
+	errPepperWithKeySlots           = errors.New("pepper can't be changed while box has more than one key slot")
+	errKeyfileWithKeySlots          = errors.New("keyfile can't be changed while box has more than one key slot")
+	errReloadEmptyBox               = errors.New("box in repository is empty")
+	errBackupUnsupported            = errors.New("repository doesn't support backups")
+	errInvalidRevision              = errors.New("invalid revision")
+	errRecoveryChecksum             = errors.New("checksum of recovery phrase mismatch, a word is mistyped or words are out of order")
+	errNoRecoveryPhrase             = errors.New("box has no recovery phrase")
+	errWrongRecoveryPhrase          = errors.New("wrong recovery phrase")
+	errWebDAVUnauthorized           = errors.New("webdav authentication failed, check username and password or token")
+	errWebDAVForbidden              = errors.New("webdav access denied, check permissions of the account")
+	errWebDAVNotFound               = errors.New("webdav collection not found, check URL of box")
+	errInjectedFailure              = errors.New("injected failure of memory repository")
+	errReadOnly                     = errors.New("box is read-only")
+	errNotLocked                    = errors.New("repository isn't locked")
 )
 
 func newErrAmbiguous(passwords []*Password) error {
-	buf := bytes.NewBufferString("ambiguous:")
+	buf := new(bytes.Buffer)
 	table := passwordPtrSlice(passwords)
 	sort.Stable(table)
 	textutil.WriteTable(buf, table)
-	return errors.New(buf.String())
+	return fmt.Errorf("%w:%s", errAmbiguous, buf.String())
 }
 
 func newErrPasswordNotFound(id string) error {
 	return fmt.Errorf("password %s not found", id)
 }
 
+func newErrNoTOTPSecret(id string) error {
+	return fmt.Errorf("password %s has no TOTP secret", id)
+}
+
+func newErrIVLength(field string, length, expected int) error {
+	return fmt.Errorf("IV of %s has %d bytes, %d expected", field, length, expected)
+}
+
+func newErrInvalidUTF8(field string) error {
+	return fmt.Errorf("%s is not valid UTF-8", field)
+}
+
+func newErrCSVColumnNotFound(name string) error {
+	return fmt.Errorf("column %s not found in header of CSV", name)
+}
+
+func newErrAgentSocketExists(path string) error {
+	return fmt.Errorf("socket %s exists, another agent may be running, remove it if not", path)
+}
+
+func newErrRestrictedKDF(kdf string) error {
+	return fmt.Errorf("key derivation %s is not allowed in restricted mode, only %s is", kdf, KDFPBKDF2)
+}
+
+func newErrRestrictedCipher(cipher string) error {
+	return fmt.Errorf("cipher %s is not allowed in restricted mode, only %s is", cipher, CipherAESGCM)
+}
+
+func newErrRestrictedKeySlot(index int, err error) error {
+	return fmt.Errorf("key slot %d: %w", index, err)
+}
+
+func newErrRestrictedPasswords(ids []string) error {
+	return fmt.Errorf("passwords not encrypted by %s are not allowed in restricted mode, re-encrypt them by an unrestricted build first: %s", CipherAESGCM, strings.Join(ids, ", "))
+}
+
+func newErrNotDerived(id string) error {
+	return fmt.Errorf("password %s is not derived", id)
+}
+
+func newErrFieldNotFound(id, key string) error {
+	return fmt.Errorf("field %s of password %s not found", key, id)
+}
+
 func newErrPasswordNotFoundWithAccount(category, account string) error {
 	return fmt.Errorf("password by (category=%s,account=%s) not found", category, account)
 }
+
+func newErrUnsupportedKDF(kdf string) error {
+	return fmt.Errorf("unsupported key derivation function %s", kdf)
+}
+
+// wrapErrPassword adds id of password to err
+func wrapErrPassword(id string, err error) error {
+	return fmt.Errorf("password %s: %w", id, err)
+}
+
+func newErrUnsupportedCipher(cipher string) error {
+	return fmt.Errorf("unsupported cipher %s", cipher)
+}
+
+func newErrUnsupportedKeySize(bits int) error {
+	return fmt.Errorf("unsupported key size %d bits", bits)
+}
+
+func newErrUnsupportedVersion(version int) error {
+	return fmt.Errorf("unsupported box format version %d, box was created by a newer onepw", version)
+}
+
+func newErrKeySlotNotFound(index int) error {
+	return fmt.Errorf("key slot %d not found", index)
+}
+
+func newErrInvalidPattern(pattern string, err error) error {
+	return fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+}
+
+func newErrBackupNotFound(n int) error {
+	return fmt.Errorf("backup %d not found", n)
+}
+
+func newErrTimestampedBackupNotFound(timestamp string) error {
+	return fmt.Errorf("backup %s not found", timestamp)
+}
+
+func newErrInvalidBackup(timestamp string, err error) error {
+	return fmt.Errorf("backup %s is not a valid box: %w", timestamp, err)
+}
+
+func newErrRecoveryWordCount(n int) error {
+	return fmt.Errorf("recovery phrase has %d words, %d expected", n, recoveryWordCount)
+}
+
+func newErrUnknownRecoveryWord(position int, word, suggestion string) error {
+	if suggestion == "" {
+		return fmt.Errorf("word %d of recovery phrase %q is not in word list", position, word)
+	}
+	return fmt.Errorf("word %d of recovery phrase %q is not in word list, did you mean %q?", position, word, suggestion)
+}
+
+func newErrUnknownField(name string) error {
+	return fmt.Errorf("unknown field %s, want password, account, notes or totp", name)
+}
+
+func newErrEmptyField(id string, field Field) error {
+	return fmt.Errorf("%s of password %s is empty", field, id)
+}
+
+func newErrDatabaseLocked(filename string) error {
+	return fmt.Errorf("database %s is locked by another process, e.g. another onepw, try again after it exits", filename)
+}
+
+func newErrBoxLockedBy(filename string, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("box %s is locked by another process, try again after it exits", filename)
+	}
+	return fmt.Errorf("box %s is locked by pid %d, try again after it exits", filename, pid)
+}
+
+func newErrAgentDirNotPrivate(dir string, mode os.FileMode) error {
+	return fmt.Errorf("directory %s of agent socket is %v, it must be a directory accessible by owner only (chmod 700)", dir, mode)
+}
+
+func newErrHTTPNoETag(url string) error {
+	return fmt.Errorf("box was saved to %s but server responded no ETag of it, reload it before saving again", url)
+}
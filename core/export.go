@@ -0,0 +1,210 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// exportMagic prefixes signed exports of box
+var exportMagic = []byte("onepw-signed-export\n")
+
+// exportVersion is version of format of signed exports
+const exportVersion = 1
+
+// signedExport is envelope of an exported box. Data is the box serialized
+// as saved in repo, so passwords are still encrypted. The envelope is
+// signed by an ed25519 key, KDF derives the key from master password
+// unless the key was supplied by Options.ExportKey. Imports are verified
+// by Options.ImportKey if supplied.
+type signedExport struct {
+	Version     int
+	KDF         *KDFConfig `json:",omitempty"`
+	Fingerprint string
+	Data        []byte
+	Signature   []byte
+}
+
+// signedData returns data signed by Signature
+func (se signedExport) signedData() ([]byte, error) {
+	se.Signature = nil
+	data, err := json.Marshal(se)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, exportMagic...), data...), nil
+}
+
+// keyFingerprint returns short hex fingerprint of public key
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// exportKey returns signing key of exports, cfg is nil if the key was
+// supplied by options, or derives the key from master password otherwise
+func (box *Box) exportKey(cfg *KDFConfig) (ed25519.PrivateKey, error) {
+	if box.options.ExportKey != nil {
+		return box.options.ExportKey, nil
+	}
+	return box.derivedExportKey(cfg)
+}
+
+// importKey returns verifying key of imports, Options.ImportKey, public key
+// of Options.ExportKey, or public key derived from master password by cfg
+func (box *Box) importKey(cfg *KDFConfig) (ed25519.PublicKey, error) {
+	if box.options.ImportKey != nil {
+		return box.options.ImportKey, nil
+	}
+	if box.options.ExportKey != nil {
+		return box.options.ExportKey.Public().(ed25519.PublicKey), nil
+	}
+	key, err := box.derivedExportKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(key).Wipe()
+	return append(ed25519.PublicKey{}, key.Public().(ed25519.PublicKey)...), nil
+}
+
+// derivedExportKey derives signing key of exports from master password
+func (box *Box) derivedExportKey(cfg *KDFConfig) (ed25519.PrivateKey, error) {
+	key, err := cfg.deriveKey(box.masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(key).Wipe()
+	seed, err := hkdfKey(key, exportKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(seed).Wipe()
+	return ed25519.NewKeyFromSeed(seed[:ed25519.SeedSize]), nil
+}
+
+// Export writes box signed by Options.ExportKey, or by a key derived from
+// master password if no key supplied. Passwords are exported encrypted.
+func (box *Box) Export(w io.Writer) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	se := signedExport{Version: exportVersion}
+	if box.options.ExportKey == nil {
+		cfg, err := box.kdf.withSalt()
+		if err != nil {
+			return err
+		}
+		se.KDF = &cfg
+	}
+	key, err := box.exportKey(se.KDF)
+	if err != nil {
+		return err
+	}
+	if se.Data, err = box.marshal(); err != nil {
+		return err
+	}
+	se.Fingerprint = keyFingerprint(key.Public().(ed25519.PublicKey))
+	signed, err := se.signedData()
+	if err != nil {
+		return err
+	}
+	se.Signature = ed25519.Sign(key, signed)
+	data, err := json.MarshalIndent(se, "", "    ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(exportMagic); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// VerifyExport checks signature of export data by Options.ImportKey, it
+// fails if data was modified or signed by another key
+func (box *Box) VerifyExport(data []byte) error {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	_, err := box.verifyExport(data)
+	return err
+}
+
+// verifyExport verifies export data and returns exported box data
+func (box *Box) verifyExport(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, exportMagic) {
+		return nil, errNotExport
+	}
+	se := signedExport{}
+	if err := json.Unmarshal(data[len(exportMagic):], &se); err != nil {
+		return nil, errNotExport
+	}
+	if se.Version > exportVersion {
+		return nil, newErrUnsupportedVersion(se.Version)
+	}
+	if box.options.ImportKey == nil && box.options.ExportKey == nil {
+		if se.KDF == nil {
+			return nil, errExportKeyMismatch
+		}
+		if err := se.KDF.checkLimits(); err != nil {
+			return nil, err
+		}
+		if err := box.checkRestrictedKDF(*se.KDF); err != nil {
+			return nil, err
+		}
+	}
+	pub, err := box.importKey(se.KDF)
+	if err != nil {
+		return nil, err
+	}
+	if se.Fingerprint != keyFingerprint(pub) {
+		return nil, errExportKeyMismatch
+	}
+	signed, err := se.signedData()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, signed, se.Signature) {
+		return nil, errExportTampered
+	}
+	return se.Data, nil
+}
+
+// Import verifies export read from r, then merges exported passwords into
+// box by strategy like Merge. Export must be signed by the key verified by
+// Options.ImportKey, and exported box unlocked by master password of box.
+func (box *Box) Import(r io.Reader, strategy MergeStrategy) ([]Conflict, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	box.mu.RLock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		box.mu.RUnlock()
+		return nil, err
+	}
+	boxData, err := box.verifyExport(data)
+	if err != nil {
+		box.mu.RUnlock()
+		return nil, err
+	}
+	other := NewBoxWithOptions(NewMemoryRepository(boxData), box.options)
+	other.masterPassword = box.masterPassword
+	other.keyfile = box.keyfile
+	other.pepper = box.pepper
+	other.response, other.responseChallenge = box.response, box.responseChallenge
+	box.mu.RUnlock()
+	if err := other.Load(); err != nil {
+		return nil, err
+	}
+	return box.Merge(other, strategy)
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestImportKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := NewBoxWithOptions(NewMemoryRepository(nil), Options{ExportKey: priv})
+	if err := exporter.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := exporter.Add(NewPassword("github", "me", "pw123456", "github.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := exporter.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.VerifyExport(buf.Bytes()); err != nil {
+		t.Fatalf("VerifyExport by public key of ExportKey: %v", err)
+	}
+
+	// importer holds the public key only
+	importer := NewBoxWithOptions(NewMemoryRepository(nil), Options{ImportKey: pub})
+	if err := importer.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importer.Import(bytes.NewReader(buf.Bytes()), MergeNewest); err != nil {
+		t.Fatal(err)
+	}
+	if got := getPassword(t, importer, id); string(got.PlainPassword) != "pw123456" {
+		t.Fatalf("password: %q", got.PlainPassword)
+	}
+
+	// signed by another key, or by a key derived from master password
+	for _, opts := range []Options{
+		{ImportKey: other},
+		{ImportKey: other, ExportKey: priv},
+		{},
+	} {
+		box := NewBoxWithOptions(NewMemoryRepository(nil), opts)
+		if err := box.Init(testMasterPassword); err != nil {
+			t.Fatal(err)
+		}
+		if err := box.VerifyExport(buf.Bytes()); err != errExportKeyMismatch {
+			t.Fatalf("VerifyExport by %+v: %v", opts, err)
+		}
+	}
+	buf.Reset()
+	if err := importer.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := importer.VerifyExport(buf.Bytes()); err != errExportKeyMismatch {
+		t.Fatalf("VerifyExport of derived signature: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"io"
+	"strconv"
+	"time"
+)
+
+// SetField sets custom field key of password id to value and saves box,
+// an empty value removes the field. id may be a prefix of ID as Remove
+// accepts.
+func (box *Box) SetField(id, key, value string) error {
+	if key == "" {
+		return errEmptyFieldKey
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	pw, err := box.lookup(id)
+	if err != nil {
+		return err
+	}
+	if pw.sealed {
+		if err := box.keys.decrypt(pw); err != nil {
+			return err
+		}
+	}
+	if value == "" {
+		if _, ok := pw.CustomFields[key]; !ok {
+			return nil
+		}
+		delete(pw.CustomFields, key)
+	} else {
+		if pw.CustomFields == nil {
+			pw.CustomFields = map[string]string{}
+		}
+		pw.CustomFields[key] = value
+	}
+	pw.LastUpdatedAt = time.Now().Unix()
+	if err := box.encrypt(pw); err != nil {
+		return err
+	}
+	return box.saveOne(pw.ID)
+}
+
+// GetField returns value of custom field key of password id, id may be a
+// prefix of ID as Remove accepts
+func (box *Box) GetField(id, key string) (string, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return "", err
+	}
+	pw, err := box.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	fields := pw.CustomFields
+	if pw.sealed && len(pw.CipherCustomFields) > 0 {
+		if fields, err = box.keys.decryptCustomFields(pw); err != nil {
+			return "", wrapErrPassword(pw.ID, err)
+		}
+	}
+	value, ok := fields[key]
+	if !ok {
+		return "", newErrFieldNotFound(pw.ID, key)
+	}
+	return value, nil
+}
+
+// Field selects a secret of password, e.g. copied by CopyToClipboard
+type Field int
+
+const (
+	// FieldPassword is the password
+	FieldPassword Field = iota
+	// FieldAccount is the account
+	FieldAccount
+	// FieldNotes are the notes
+	FieldNotes
+	// FieldTOTP is the current TOTP code, computed from TOTP secret
+	FieldTOTP
+)
+
+var fieldNames = []string{
+	FieldPassword: "password",
+	FieldAccount:  "account",
+	FieldNotes:    "notes",
+	FieldTOTP:     "totp",
+}
+
+// String returns name of field
+func (field Field) String() string {
+	if field < 0 || int(field) >= len(fieldNames) {
+		return strconv.Itoa(int(field))
+	}
+	return fieldNames[field]
+}
+
+// ParseField returns field named name, e.g. "password"
+func ParseField(name string) (Field, error) {
+	for i, s := range fieldNames {
+		if s == name {
+			return Field(i), nil
+		}
+	}
+	return 0, newErrUnknownField(name)
+}
+
+// fieldSecret returns a copy of field of password id decrypted, id may be
+// a prefix of ID as Remove accepts. The copy should be wiped after use.
+func (box *Box) fieldSecret(id string, field Field) (Secret, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	found, err := box.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := box.decryptedCopy(found)
+	if err != nil {
+		return nil, err
+	}
+	defer pw.wipe()
+	var secret Secret
+	switch field {
+	case FieldPassword:
+		secret = pw.PlainPassword
+	case FieldAccount:
+		secret = pw.PlainAccount
+	case FieldNotes:
+		secret = pw.PlainNotes
+	case FieldTOTP:
+		if len(pw.PlainTOTPSecret) == 0 {
+			return nil, newErrNoTOTPSecret(pw.ID)
+		}
+		code, _, err := totpCode(pw.PlainTOTPSecret, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return Secret(code), nil
+	default:
+		return nil, newErrUnknownField(field.String())
+	}
+	if len(secret) == 0 {
+		return nil, newErrEmptyField(pw.ID, field)
+	}
+	return secret.clone(), nil
+}
+
+// Reveal writes field of password id decrypted to w as is, without table
+// or trailing newline, e.g. for scripts piping it. id may be a prefix of
+// ID as Remove accepts.
+func (box *Box) Reveal(id string, field Field, w io.Writer) error {
+	secret, err := box.fieldSecret(id, field)
+	if err != nil {
+		return err
+	}
+	defer secret.Wipe()
+	_, err = w.Write(secret)
+	return err
+}
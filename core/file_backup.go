@@ -0,0 +1,122 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultFileBackups is number of timestamped backups kept by FileRepository
+const DefaultFileBackups = 5
+
+// backupTimeLayout formats time stamps of backups in UTC, so names of
+// backups sort in the order they were made
+const backupTimeLayout = "20060102T150405.000000000Z"
+
+func (repo *FileRepository) timestampedBackupName(timestamp string) string {
+	return repo.Filename + ".bak." + timestamp
+}
+
+func (repo *FileRepository) isTimestampedBackup(name string) bool {
+	prefix := repo.timestampedBackupName("")
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	_, err := time.Parse(backupTimeLayout, name[len(prefix):])
+	return err == nil
+}
+
+func (repo *FileRepository) keepBackups() int {
+	if repo.Backups == 0 {
+		return DefaultFileBackups
+	}
+	return repo.Backups
+}
+
+// backupCurrent copies current file to a timestamped backup before it's
+// replaced by data, then removes the oldest backups beyond keepBackups.
+// Missing or empty files and files same as data aren't backed up.
+func (repo *FileRepository) backupCurrent(data []byte) error {
+	keep := repo.keepBackups()
+	if keep < 0 {
+		return nil
+	}
+	old, err := repo.Load()
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(old)) == 0 || bytes.Equal(old, data) {
+		return nil
+	}
+	backup := &FileRepository{
+		Filename: repo.timestampedBackupName(time.Now().UTC().Format(backupTimeLayout)),
+		Shred:    repo.Shred,
+		Backups:  -1,
+	}
+	if err := backup.Save(old); err != nil {
+		return err
+	}
+	timestamps, err := repo.TimestampedBackups()
+	if err != nil {
+		return err
+	}
+	remove := removeFile
+	if repo.Shred {
+		remove = SecureRemove
+	}
+	for _, timestamp := range timestamps[minInt(keep, len(timestamps)):] {
+		if err := remove(repo.timestampedBackupName(timestamp)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TimestampedBackups returns time stamps of backups made by Save, the most
+// recent first
+func (repo *FileRepository) TimestampedBackups() ([]string, error) {
+	names, err := filepath.Glob(repo.timestampedBackupName("*"))
+	if err != nil {
+		return nil, err
+	}
+	prefix := repo.timestampedBackupName("")
+	timestamps := []string{}
+	for _, name := range names {
+		if repo.isTimestampedBackup(name) {
+			timestamps = append(timestamps, name[len(prefix):])
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(timestamps)))
+	return timestamps, nil
+}
+
+// LoadBackup returns content of backup made at timestamp
+func (repo *FileRepository) LoadBackup(timestamp string) ([]byte, error) {
+	name := repo.timestampedBackupName(timestamp)
+	if !repo.isTimestampedBackup(name) {
+		return nil, newErrTimestampedBackupNotFound(timestamp)
+	}
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, newErrTimestampedBackupNotFound(timestamp)
+	}
+	return data, err
+}
+
+// RestoreBackup replaces box file by backup made at timestamp after
+// checking the backup parses as a box, current box file is backed up
+// first. Box should be reloaded after restored.
+func (repo *FileRepository) RestoreBackup(timestamp string) error {
+	data, err := repo.LoadBackup(timestamp)
+	if err != nil {
+		return err
+	}
+	if err := checkBoxData(data); err != nil {
+		return newErrInvalidBackup(timestamp, err)
+	}
+	return repo.Save(data)
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultLockTimeout is how long FileRepository.Lock waits for the lock
+// held by another process by default
+const DefaultLockTimeout = 5 * time.Second
+
+// lockPollInterval is interval of retrying a lock held by another process
+const lockPollInterval = 50 * time.Millisecond
+
+// Lock implements LockingRepository.Lock method. An advisory lock of
+// Filename.lock is acquired, flock on unix and LockFileEx on windows, since
+// Filename itself is replaced by Save. Pid of the process is written to
+// the lock file, so a process timed out can tell who holds the lock.
+func (repo *FileRepository) Lock() error {
+	timeout := repo.LockTimeout
+	if timeout < 0 {
+		return nil
+	}
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	repo.lockMu.Lock()
+	file, err := os.OpenFile(repo.lockName(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		repo.lockMu.Unlock()
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			repo.lockMu.Unlock()
+			return err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			repo.lockMu.Unlock()
+			return newErrBoxLockedBy(repo.Filename, repo.lockHolder())
+		}
+		time.Sleep(lockPollInterval)
+	}
+	// pid is informative only, lock is held even if it's not written
+	if file.Truncate(0) == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	repo.lockFile = file
+	return nil
+}
+
+// Unlock implements LockingRepository.Unlock method, lock file is kept
+// since removing it races with processes waiting for it
+func (repo *FileRepository) Unlock() error {
+	if repo.LockTimeout < 0 && repo.lockFile == nil {
+		return nil
+	}
+	file := repo.lockFile
+	if file == nil {
+		return errNotLocked
+	}
+	repo.lockFile = nil
+	defer repo.lockMu.Unlock()
+	err := unlockFile(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (repo *FileRepository) lockName() string {
+	return repo.Filename + ".lock"
+}
+
+// lockHolder returns pid written to lock file, 0 if unknown
+func (repo *FileRepository) lockHolder() int {
+	data, err := ioutil.ReadFile(repo.lockName())
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(string(bytes.TrimSpace(data)))
+	return pid
+}
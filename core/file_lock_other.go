@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package core
+
+import "os"
+
+// tryLockFile always succeeds, files can't be locked on this platform
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritersReloadChangesOfOthers(t *testing.T) {
+	const newMasterPassword = "n3w-Master#pw"
+	for _, tc := range []struct {
+		name string
+		// change changes box holding derived password id, master password
+		// of box after changed is returned
+		change func(t *testing.T, box *Box, id string) (string, error)
+		// passwords are number of passwords of box after changed
+		passwords int
+	}{
+		{"SetField", func(t *testing.T, box *Box, id string) (string, error) {
+			return testMasterPassword, box.SetField(id, "pin", "1234")
+		}, 2},
+		{"RotateDerived", func(t *testing.T, box *Box, id string) (string, error) {
+			return testMasterPassword, box.RotateDerived(id)
+		}, 2},
+		{"ChangeMasterPassword", func(t *testing.T, box *Box, id string) (string, error) {
+			return newMasterPassword, box.ChangeMasterPassword(testMasterPassword, newMasterPassword, 0)
+		}, 2},
+		{"ChangeCipher", func(t *testing.T, box *Box, id string) (string, error) {
+			return testMasterPassword, box.ChangeCipher(CipherChaCha20Poly1305)
+		}, 2},
+		{"ChangeKDF", func(t *testing.T, box *Box, id string) (string, error) {
+			return testMasterPassword, box.ChangeKDF(KDFConfig{Type: KDFPBKDF2, Iterations: 1000})
+		}, 2},
+		{"Rekey", func(t *testing.T, box *Box, id string) (string, error) {
+			_, err := box.Rekey()
+			return testMasterPassword, err
+		}, 2},
+		{"ReencryptAll", func(t *testing.T, box *Box, id string) (string, error) {
+			return testMasterPassword, box.ReencryptAll()
+		}, 2},
+		{"SetHint", func(t *testing.T, box *Box, id string) (string, error) {
+			return testMasterPassword, box.SetHint("hint")
+		}, 2},
+		{"SetEncryptMetadata", func(t *testing.T, box *Box, id string) (string, error) {
+			return testMasterPassword, box.SetEncryptMetadata(true)
+		}, 2},
+		{"AddKeySlot", func(t *testing.T, box *Box, id string) (string, error) {
+			_, err := box.AddKeySlot(testMasterPassword, newMasterPassword)
+			return newMasterPassword, err
+		}, 2},
+		{"GenerateRecoveryPhrase", func(t *testing.T, box *Box, id string) (string, error) {
+			_, err := box.GenerateRecoveryPhrase()
+			return testMasterPassword, err
+		}, 2},
+		{"Merge", func(t *testing.T, box *Box, id string) (string, error) {
+			other := newTestBox(t)
+			if _, _, err := other.Add(NewPassword("gitlab", "you", "pw1234567", "gitlab.com")); err != nil {
+				t.Fatal(err)
+			}
+			_, err := box.Merge(other, MergeNewest)
+			return testMasterPassword, err
+		}, 3},
+		{"ImportEncrypted", func(t *testing.T, box *Box, id string) (string, error) {
+			other := newTestBox(t)
+			if _, _, err := other.Add(NewPassword("gitlab", "you", "pw1234567", "gitlab.com")); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := other.ExportEncrypted(&buf, "pAss#phrase77"); err != nil {
+				t.Fatal(err)
+			}
+			_, err := box.ImportEncrypted(&buf, "pAss#phrase77")
+			return testMasterPassword, err
+		}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "password.data")
+			box := NewBox(NewFileRepository(filename))
+			if err := box.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			id, _ := addDerived(t, box)
+
+			// another process adds a password after box loaded
+			other := NewBox(NewFileRepository(filename))
+			if err := other.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := other.Add(NewPassword("github", "me", "pw123456", "github.com")); err != nil {
+				t.Fatal(err)
+			}
+
+			masterPassword, err := tc.change(t, box, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := reopen(t, NewFileRepository(filename), masterPassword).Count(); n != tc.passwords {
+				t.Fatalf("%d passwords saved, want %d", n, tc.passwords)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package core
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffsetHigh is high 32 bits of offset of the byte locked in lock
+// file, i.e. 4GiB. Locks of windows are mandatory, so the byte is far
+// beyond pid written at the beginning.
+const lockOffsetHigh = 1
+
+// tryLockFile locks file exclusively by LockFileEx without blocking, false
+// returned if it's locked by another process
+func tryLockFile(file *os.File) (bool, error) {
+	ol := &windows.Overlapped{OffsetHigh: lockOffsetHigh}
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(file *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: lockOffsetHigh}
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, ol)
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// formatVersion is version of serialized format written by box.
+// Legacy boxes, a bare JSON array of passwords, are version 0.
+const formatVersion = 20
+
+// boxData represents serialized format of box
+type boxData struct {
+	// Version of format
+	Version int
+
+	KDF KDFConfig
+
+	// Cipher of passwords, missing in boxes before ChaCha20-Poly1305 supported
+	Cipher string `json:",omitempty"`
+
+	// KeySize of AES in bits, missing in boxes before AES-128 supported
+	KeySize int `json:",omitempty"`
+
+	// KeyfileRequired reports whether a keyfile is mixed into master key
+	KeyfileRequired bool `json:",omitempty"`
+
+	// PepperRequired reports whether a pepper is appended to master
+	// password, PepperCheck is a short hash of the pepper
+	PepperRequired bool   `json:",omitempty"`
+	PepperCheck    []byte `json:",omitempty"`
+
+	// Challenge sent to hardware token if box requires a token
+	Challenge []byte `json:",omitempty"`
+
+	// Hint of master password, never mixed into key
+	Hint string `json:",omitempty"`
+
+	// EncryptMetadata reports whether metadata of passwords is encrypted
+	EncryptMetadata bool `json:",omitempty"`
+
+	// EncryptTimestamps reports whether time stamps of passwords are
+	// encrypted with metadata
+	EncryptTimestamps bool `json:",omitempty"`
+
+	// Verifier of master password, only in boxes before key slots
+	Verifier []byte `json:",omitempty"`
+
+	// KeySlots wrapping data key of box, missing in boxes before key slots
+	KeySlots []keySlot `json:",omitempty"`
+
+	// SyncID identifies box among boxes synced, SyncMarkers are time of
+	// the last sync with each of them by their SyncID, missing in boxes
+	// never synced
+	SyncID      string           `json:",omitempty"`
+	SyncMarkers map[string]int64 `json:",omitempty"`
+
+	// MAC of serialized box without MAC itself, missing in legacy boxes
+	MAC []byte `json:",omitempty"`
+
+	Passwords []Password
+
+	// Trash holds removed passwords until trash is emptied, missing in
+	// boxes before trash added
+	Trash []Password `json:",omitempty"`
+}
+
+// macData returns canonical serialization of box authenticated by MAC.
+// Boxes before version 2 authenticate passwords only.
+func (bd boxData) macData() ([]byte, error) {
+	if bd.Version < 2 {
+		return json.Marshal(bd.Passwords)
+	}
+	bd.MAC = nil
+	return json.Marshal(bd)
+}
+
+// encryptedBoxMagic prefixes boxes encrypted as a whole
+var encryptedBoxMagic = []byte("onepw-encrypted-box\n")
+
+// encryptedBox represents serialized format of box encrypted as a whole.
+// Only what's needed for deriving key is visible, Data is serialized
+// boxData encrypted by AES-GCM.
+type encryptedBox struct {
+	Version         int
+	KDF             KDFConfig
+	KeySize         int       `json:",omitempty"`
+	KeyfileRequired bool      `json:",omitempty"`
+	PepperRequired  bool      `json:",omitempty"`
+	PepperCheck     []byte    `json:",omitempty"`
+	Challenge       []byte    `json:",omitempty"`
+	Hint            string    `json:",omitempty"`
+	Verifier        []byte    `json:",omitempty"`
+	KeySlots        []keySlot `json:",omitempty"`
+	IV              []byte
+	Data            []byte
+}
+
+// keySlot holds data key of box wrapped by a key derived from one of
+// master passwords of box
+type keySlot struct {
+	KDF KDFConfig
+	IV  []byte
+	Key []byte
+}
+
+// additionalData returns data authenticated with encrypted box data,
+// so header of encrypted box can't be modified
+func (eb encryptedBox) additionalData() ([]byte, error) {
+	eb.IV, eb.Data = nil, nil
+	header, err := json.Marshal(eb)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, encryptedBoxMagic...), header...), nil
+}
+
+func isEncryptedBox(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedBoxMagic)
+}
+
+// parseEncryptedBox parses box encrypted as a whole
+func parseEncryptedBox(data []byte) (*encryptedBox, error) {
+	eb := &encryptedBox{}
+	if err := json.Unmarshal(data[len(encryptedBoxMagic):], eb); err != nil {
+		return nil, err
+	}
+	if eb.Version > formatVersion {
+		return nil, newErrUnsupportedVersion(eb.Version)
+	}
+	return eb, nil
+}
+
+// checkBoxData checks that data parses as a serialized box, nothing is
+// decrypted
+func checkBoxData(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return errReloadEmptyBox
+	}
+	if isEncryptedBox(data) {
+		_, err := parseEncryptedBox(data)
+		return err
+	}
+	_, err := parseBoxData(data)
+	return err
+}
+
+// migration upgrades box data from version N to N+1. It's called after
+// passwords were decrypted, so plaintext can be migrated too, ciphers
+// are written by current format when box saved.
+type migration func(bd *boxData) error
+
+// migrations[N] upgrades box data from version N to N+1
+var migrations = []migration{
+	// 0 -> 1: keys derived by HKDF from master key
+	noMigration,
+	// 1 -> 2: MAC authenticates the whole box rather than passwords only
+	noMigration,
+	// 2 -> 3: metadata of passwords may be encrypted
+	noMigration,
+	// 3 -> 4: key of AES may be 128 bits
+	noMigration,
+	// 4 -> 5: hint of master password added to header
+	noMigration,
+	// 5 -> 6: passwords encrypted by a random data key wrapped in key slots
+	noMigration,
+	// 6 -> 7: response of hardware token may be mixed into master key
+	noMigration,
+	// 7 -> 8: URL of passwords added
+	noMigration,
+	// 8 -> 9: encrypted notes of passwords added
+	noMigration,
+	// 9 -> 10: pepper may be appended to master password
+	noMigration,
+	// 10 -> 11: expiry of passwords added
+	noMigration,
+	// 11 -> 12: each field of passwords encrypted by its own sub key
+	noMigration,
+	// 12 -> 13: history of passwords added
+	noMigration,
+	// 13 -> 14: encrypted TOTP secrets of passwords added
+	noMigration,
+	// 14 -> 15: encrypted custom fields of passwords added
+	noMigration,
+	// 15 -> 16: derived passwords added
+	noMigration,
+	// 16 -> 17: time stamps of passwords may be encrypted
+	noMigration,
+	// 17 -> 18: key slot wrapped by recovery key added
+	noMigration,
+	// 18 -> 19: trash of removed passwords added
+	noMigration,
+	// 19 -> 20: markers of boxes synced added
+	noMigration,
+}
+
+func init() {
+	if len(migrations) != formatVersion {
+		panic("missing migrations of box format")
+	}
+}
+
+func noMigration(bd *boxData) error {
+	return nil
+}
+
+// parseBoxData parses serialized box
+func parseBoxData(data []byte) (*boxData, error) {
+	bd := &boxData{}
+	if data[0] == '[' {
+		// version 0: bare array of passwords keyed by md5(masterPassword)
+		bd.KDF.Type = KDFMD5
+		if err := json.Unmarshal(data, &bd.Passwords); err != nil {
+			return nil, err
+		}
+		return bd, nil
+	}
+	if err := json.Unmarshal(data, bd); err != nil {
+		return nil, err
+	}
+	if bd.Version > formatVersion {
+		return nil, newErrUnsupportedVersion(bd.Version)
+	}
+	return bd, nil
+}
+
+// migrate upgrades bd to formatVersion
+func (bd *boxData) migrate() error {
+	for bd.Version < formatVersion {
+		if err := migrations[bd.Version](bd); err != nil {
+			return err
+		}
+		bd.Version++
+	}
+	return nil
+}
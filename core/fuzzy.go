@@ -0,0 +1,133 @@
+package core
+
+import (
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/mkideal/pkg/textutil"
+)
+
+// fuzzyScore scores how well query matches somewhere in s case-insensitively,
+// 1 for an exact substring, lower for each edit needed, 0 if no resemblance.
+// Edit distance of query to the best matching substring of s is computed by
+// Sellers' algorithm. Both slices are zeroed after use, s may be a secret.
+func fuzzyScore(query, s []rune) float64 {
+	if len(query) == 0 {
+		return 0
+	}
+	// prev[i] is distance of query[:i] to the best substring of s ending here
+	prev := make([]int, len(query)+1)
+	curr := make([]int, len(query)+1)
+	for i := range prev {
+		prev[i] = i
+	}
+	best := prev[len(query)]
+	for _, c := range s {
+		curr[0] = 0
+		for i, q := range query {
+			cost := 1
+			if q == c {
+				cost = 0
+			}
+			curr[i+1] = minInt(prev[i]+cost, minInt(prev[i+1]+1, curr[i]+1))
+		}
+		best = minInt(best, curr[len(query)])
+		prev, curr = curr, prev
+	}
+	if best >= len(query) {
+		return 0
+	}
+	return 1 - float64(best)/float64(len(query))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// foldRunes returns runes of s folded by foldRune, s is decoded in place so
+// no string copy of a secret is left
+func foldRunes(s []byte) []rune {
+	runes := make([]rune, 0, len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		runes = append(runes, foldRune(r))
+		s = s[size:]
+	}
+	return runes
+}
+
+func wipeRunes(runes []rune) {
+	for i := range runes {
+		runes[i] = 0
+	}
+}
+
+// fuzzyMatch returns the best score of query against account, category and URL of pw
+func (pw Password) fuzzyMatch(query []rune) float64 {
+	best := 0.0
+	for _, field := range [][]byte{pw.PlainAccount, []byte(pw.Category), []byte(pw.URL)} {
+		runes := foldRunes(field)
+		if score := fuzzyScore(query, runes); score > best {
+			best = score
+		}
+		wipeRunes(runes)
+	}
+	return best
+}
+
+// scoredSlice is passwordSlice sorted by score descending, then by ID
+type scoredSlice struct {
+	passwordSlice
+	scores []float64
+}
+
+func (ss scoredSlice) Less(i, j int) bool {
+	if ss.scores[i] != ss.scores[j] {
+		return ss.scores[i] > ss.scores[j]
+	}
+	return ss.passwordSlice.Less(i, j)
+}
+
+func (ss scoredSlice) Swap(i, j int) {
+	ss.passwordSlice.Swap(i, j)
+	ss.scores[i], ss.scores[j] = ss.scores[j], ss.scores[i]
+}
+
+// FuzzyFind writes at most limit passwords whose account, category or URL
+// resembles query to specified writer, the best matched first. Typos are
+// tolerated, all resembling passwords are written if limit isn't positive.
+func (box *Box) FuzzyFind(w io.Writer, query string, limit int) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.unsealCached(); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	q := foldRunes([]byte(query))
+	matched := scoredSlice{}
+	for _, pw := range passwords {
+		if score := pw.fuzzyMatch(q); score > 0 {
+			matched.passwordSlice = append(matched.passwordSlice, pw)
+			matched.scores = append(matched.scores, score)
+		}
+	}
+	sort.Sort(matched)
+	table := matched.passwordSlice
+	if limit > 0 && len(table) > limit {
+		table = table[:limit]
+	}
+	textutil.WriteTable(w, table)
+	return nil
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// character sets of generated passwords
+const (
+	lowerChars     = "abcdefghijklmnopqrstuvwxyz"
+	upperChars     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars     = "0123456789"
+	symbolChars    = "!#$%&*+-=?@^_~.,:;()[]{}<>"
+	ambiguousChars = "0Oo1lI|`'\""
+)
+
+// GenOptions represents options of GeneratePassword
+type GenOptions struct {
+	// Length of password in characters
+	Length int
+	// Characters included in password, at least one character of each
+	// included set is used
+	Lower   bool
+	Upper   bool
+	Digits  bool
+	Symbols bool
+	// ExcludeAmbiguous excludes characters easily confused, e.g. 0 and O
+	ExcludeAmbiguous bool
+}
+
+// DefaultGenOptions returns options used by generate command by default
+func DefaultGenOptions() GenOptions {
+	return GenOptions{
+		Length:  20,
+		Lower:   true,
+		Upper:   true,
+		Digits:  true,
+		Symbols: true,
+	}
+}
+
+// charsets returns included character sets of opts
+func (opts GenOptions) charsets() []string {
+	sets := []string{}
+	for _, set := range []struct {
+		included bool
+		chars    string
+	}{
+		{opts.Lower, lowerChars},
+		{opts.Upper, upperChars},
+		{opts.Digits, digitChars},
+		{opts.Symbols, symbolChars},
+	} {
+		if !set.included {
+			continue
+		}
+		chars := set.chars
+		if opts.ExcludeAmbiguous {
+			chars = strings.Map(func(c rune) rune {
+				if strings.ContainsRune(ambiguousChars, c) {
+					return -1
+				}
+				return c
+			}, chars)
+		}
+		sets = append(sets, chars)
+	}
+	return sets
+}
+
+// GeneratePassword generates a random password by crypto/rand
+func GeneratePassword(opts GenOptions) (string, error) {
+	sets := opts.charsets()
+	if len(sets) == 0 {
+		return "", errEmptyCharset
+	}
+	if opts.Length < len(sets) || opts.Length < 6 {
+		return "", errPasswordTooShort
+	}
+	all := strings.Join(sets, "")
+	passwd := make([]byte, opts.Length)
+	for i := range passwd {
+		chars := all
+		if i < len(sets) {
+			// one character of each set at least
+			chars = sets[i]
+		}
+		c, err := randomChar(chars)
+		if err != nil {
+			return "", err
+		}
+		passwd[i] = c
+	}
+	// shuffle so required characters are not always leading
+	for i := len(passwd) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		passwd[i], passwd[j] = passwd[j], passwd[i]
+	}
+	return string(passwd), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+// randomInt returns a uniform random integer in [0, n)
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
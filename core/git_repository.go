@@ -0,0 +1,338 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const (
+	gitBoxFilename = "password.data"
+
+	// identity of commits if git user isn't configured
+	gitUserName  = "onepw"
+	gitUserEmail = "onepw@localhost"
+)
+
+// errNotCommitted is returned by loadRevision if box isn't in the revision
+var errNotCommitted = errors.New("box is not committed")
+
+// GitRepository implements BoxRepository interface, box is stored in a git
+// work tree and every Save commits it, so history of box can be listed and
+// rolled back. Git is driven by go-git, no git command is required.
+type GitRepository struct {
+	Dir      string
+	Filename string
+
+	// Remote, e.g. "origin", is pushed after every Save if not empty
+	Remote string
+
+	// Pull pulls Remote before Load. A *GitConflictError is returned if
+	// box diverged from Remote, work tree is left as is then.
+	Pull bool
+
+	// Auth of Remote, e.g. *http.BasicAuth or *ssh.PublicKeys of go-git,
+	// nil if Remote requires none
+	Auth transport.AuthMethod
+}
+
+// Revision represents a commit of box in GitRepository
+type Revision struct {
+	Hash    string
+	Time    time.Time
+	Message string
+}
+
+// GitConflictError is returned by GitRepository if local commits and commits
+// of Remote diverged, so they can't be fast-forwarded. Nothing is changed
+// locally, commits should be merged by hand.
+type GitConflictError struct {
+	Remote string
+	Err    error
+}
+
+// Error implements error interface
+func (err *GitConflictError) Error() string {
+	return fmt.Sprintf("box diverged from git remote %s, merge it by hand: %v", err.Remote, err.Err)
+}
+
+// Unwrap returns error of go-git
+func (err *GitConflictError) Unwrap() error {
+	return err.Err
+}
+
+// NewGitRepository creates a GitRepository, box is stored as password.data in dir
+func NewGitRepository(dir string) *GitRepository {
+	return &GitRepository{
+		Dir:      dir,
+		Filename: gitBoxFilename,
+	}
+}
+
+// Load implements BoxRepository.Load method, box of HEAD is loaded after
+// Remote is pulled if Pull. Empty data returned if box was never committed.
+func (repo *GitRepository) Load() ([]byte, error) {
+	r, err := git.PlainOpen(repo.Dir)
+	if err == git.ErrRepositoryNotExists {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if repo.Pull && repo.Remote != "" {
+		if err := repo.pull(r); err != nil {
+			return nil, err
+		}
+	}
+	data, err := repo.loadRevision(r, "HEAD")
+	if err == errNotCommitted {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+// Save implements BoxRepository.Save method, data is written to work tree
+// and committed with a message telling how many passwords changed, then
+// Remote is pushed. Other changes staged in work tree are committed too.
+func (repo *GitRepository) Save(data []byte) error {
+	old := []byte{}
+	if r, err := git.PlainOpen(repo.Dir); err == nil {
+		if old, err = repo.loadRevision(r, "HEAD"); err != nil && err != errNotCommitted {
+			return err
+		}
+	}
+	return repo.commit(data, gitCommitMessage(old, data))
+}
+
+// Revisions returns commits of box, newest first
+func (repo *GitRepository) Revisions() ([]Revision, error) {
+	r, err := git.PlainOpen(repo.Dir)
+	if err == git.ErrRepositoryNotExists {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.ToSlash(repo.Filename)
+	commits, err := r.Log(&git.LogOptions{From: head.Hash(), FileName: &path})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+	revisions := []Revision{}
+	err = commits.ForEach(func(c *object.Commit) error {
+		revisions = append(revisions, Revision{
+			Hash:    c.Hash.String(),
+			Time:    c.Committer.When,
+			Message: strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0],
+		})
+		return nil
+	})
+	return revisions, err
+}
+
+// LoadRevision loads box of revision
+func (repo *GitRepository) LoadRevision(revision string) ([]byte, error) {
+	if revision == "" || strings.HasPrefix(revision, "-") {
+		return nil, errInvalidRevision
+	}
+	r, err := git.PlainOpen(repo.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return repo.loadRevision(r, revision)
+}
+
+// Rollback restores box of revision and commits it as a new revision,
+// so history is never rewritten
+func (repo *GitRepository) Rollback(revision string) error {
+	data, err := repo.LoadRevision(revision)
+	if err != nil {
+		return err
+	}
+	return repo.commit(data, "onepw: rollback box to "+revision)
+}
+
+func (repo *GitRepository) loadRevision(r *git.Repository, revision string) ([]byte, error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err == plumbing.ErrReferenceNotFound && revision == "HEAD" {
+		return nil, errNotCommitted
+	}
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	file, err := commit.File(filepath.ToSlash(repo.Filename))
+	if err == object.ErrFileNotFound {
+		return nil, errNotCommitted
+	}
+	if err != nil {
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+func (repo *GitRepository) commit(data []byte, message string) error {
+	if err := os.MkdirAll(repo.Dir, 0700); err != nil {
+		return err
+	}
+	r, err := git.PlainOpen(repo.Dir)
+	if err == git.ErrRepositoryNotExists {
+		r, err = git.PlainInit(repo.Dir, false)
+	}
+	if err != nil {
+		return err
+	}
+	// history of git keeps previous boxes, no backup files needed
+	file := &FileRepository{Filename: filepath.Join(repo.Dir, repo.Filename), Backups: -1}
+	if err := file.Save(data); err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	path := filepath.ToSlash(repo.Filename)
+	if _, err := w.Add(path); err != nil {
+		return err
+	}
+	// nothing to commit if box unchanged
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+	if fs, ok := status[path]; !ok || fs.Staging == git.Unmodified {
+		return nil
+	}
+	if _, err := w.Commit(message, &git.CommitOptions{Author: repo.signature(r)}); err != nil {
+		return err
+	}
+	return repo.push(r)
+}
+
+// signature returns identity of git user, or identity of onepw if git user
+// isn't configured
+func (repo *GitRepository) signature(r *git.Repository) *object.Signature {
+	sig := &object.Signature{Name: gitUserName, Email: gitUserEmail, When: time.Now()}
+	if cfg, err := r.ConfigScoped(config.GlobalScope); err == nil && cfg.User.Email != "" {
+		sig.Email = cfg.User.Email
+		if cfg.User.Name != "" {
+			sig.Name = cfg.User.Name
+		}
+	}
+	return sig
+}
+
+func (repo *GitRepository) pull(r *git.Repository) error {
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	err = w.Pull(&git.PullOptions{RemoteName: repo.Remote, Auth: repo.Auth})
+	switch {
+	case err == nil, err == git.NoErrAlreadyUpToDate, err == transport.ErrEmptyRemoteRepository:
+		return nil
+	case err == git.ErrNonFastForwardUpdate, err == git.ErrUnstagedChanges, err == git.ErrWorktreeNotClean:
+		return &GitConflictError{Remote: repo.Remote, Err: err}
+	}
+	return err
+}
+
+// push pushes Remote, box is committed locally even if pushing fails
+func (repo *GitRepository) push(r *git.Repository) error {
+	if repo.Remote == "" {
+		return nil
+	}
+	err := r.Push(&git.PushOptions{RemoteName: repo.Remote, Auth: repo.Auth})
+	switch {
+	case err == nil, err == git.NoErrAlreadyUpToDate:
+		return nil
+	case errors.Is(err, git.ErrForceNeeded),
+		// go-git rejects non-fast-forward pushes by an unexported error
+		strings.HasPrefix(err.Error(), git.ErrNonFastForwardUpdate.Error()):
+		return &GitConflictError{Remote: repo.Remote, Err: err}
+	}
+	return err
+}
+
+// gitCommitMessage describes change of box from old to data by number of
+// passwords added, updated or removed, which can't be counted for boxes
+// encrypted as a whole
+func gitCommitMessage(old, data []byte) string {
+	before, ok := passwordsByID(old)
+	if !ok {
+		return "onepw: update box"
+	}
+	after, ok := passwordsByID(data)
+	if !ok {
+		return "onepw: update box"
+	}
+	n := 0
+	for id, pw := range after {
+		if prev, ok := before[id]; !ok || !bytes.Equal(prev, pw) {
+			n++
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			n++
+		}
+	}
+	switch n {
+	case 0:
+		return "onepw: update box"
+	case 1:
+		return "onepw: update 1 entry"
+	}
+	return fmt.Sprintf("onepw: update %d entries", n)
+}
+
+// passwordsByID returns serialized passwords of box data by id, they are
+// compared as is since unchanged passwords keep their ciphers
+func passwordsByID(data []byte) (map[string][]byte, bool) {
+	passwords := map[string][]byte{}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return passwords, true
+	}
+	if isEncryptedBox(data) {
+		return nil, false
+	}
+	bd, err := parseBoxData(data)
+	if err != nil {
+		return nil, false
+	}
+	for _, pw := range bd.Passwords {
+		raw, err := json.Marshal(pw)
+		if err != nil {
+			return nil, false
+		}
+		passwords[pw.ID] = raw
+	}
+	return passwords, true
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+)
+
+// gzipMagic is the first bytes of gzip data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// GzipRepository wraps a BoxRepository, box is compressed by gzip before
+// saved. Data not compressed, e.g. saved before wrapped, is loaded as is.
+type GzipRepository struct {
+	inner BoxRepository
+}
+
+// NewGzipRepository creates a GzipRepository
+func NewGzipRepository(inner BoxRepository) *GzipRepository {
+	return &GzipRepository{inner: inner}
+}
+
+// Load implements BoxRepository.Load method
+func (repo *GzipRepository) Load() ([]byte, error) {
+	data, err := repo.inner.Load()
+	if err != nil || !bytes.HasPrefix(data, gzipMagic) {
+		return data, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// Save implements BoxRepository.Save method
+func (repo *GzipRepository) Save(data []byte) error {
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return repo.inner.Save(buf.Bytes())
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGzipRepository(t *testing.T) {
+	mem := NewMemoryRepository(nil)
+	repo := NewGzipRepository(mem)
+	data := bytes.Repeat([]byte(`{"Passwords": []} `), 100)
+	if err := repo.Save(data); err != nil {
+		t.Fatal(err)
+	}
+	if saved := mem.Bytes(); !bytes.HasPrefix(saved, gzipMagic) || len(saved) >= len(data) {
+		t.Fatalf("%d bytes saved of %d not compressed", len(saved), len(data))
+	}
+	if loaded, err := repo.Load(); err != nil || !bytes.Equal(loaded, data) {
+		t.Fatalf("Load: %d bytes, %v", len(loaded), err)
+	}
+
+	// inner data fails to decompress
+	if err := mem.Save(append(append([]byte{}, gzipMagic...), "garbage"...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Load(); err == nil {
+		t.Fatal("loaded corrupt gzip data")
+	}
+}
+
+func TestGzipRepositoryBox(t *testing.T) {
+	// box saved before compressed is loaded as is, then saved compressed
+	box := newTestBox(t)
+	mem := box.repo.(*MemoryRepository)
+	id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := reopen(t, NewGzipRepository(mem), testMasterPassword)
+	if _, _, err := compressed.Add(NewPassword("gitlab", "me", "pw1234567", "gitlab.com")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(mem.Bytes(), gzipMagic) {
+		t.Fatal("box not compressed")
+	}
+	reopened := reopen(t, NewGzipRepository(mem), testMasterPassword)
+	if n := reopened.Count(); n != 2 {
+		t.Fatalf("%d passwords", n)
+	}
+	if got := getPassword(t, reopened, id); string(got.PlainPassword) != "pw123456" {
+		t.Fatalf("password %q", got.PlainPassword)
+	}
+}
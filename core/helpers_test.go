@@ -0,0 +1,26 @@
+package core
+
+import "testing"
+
+// testMasterPassword is master password of boxes in tests
+const testMasterPassword = "mAster#479"
+
+// newTestBox returns a box initialized by testMasterPassword in memory
+func newTestBox(t *testing.T) *Box {
+	t.Helper()
+	box := NewBox(NewMemoryRepository(nil))
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+// getPassword returns password id of box decrypted
+func getPassword(t *testing.T, box *Box, id string) *Password {
+	t.Helper()
+	pw, err := box.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pw
+}
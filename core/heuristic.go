@@ -0,0 +1,71 @@
+package core
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Legacy boxes have neither verifier nor MAC, a wrong master password is
+// told by accounts decrypted into garbage instead
+const (
+	// DefaultWrongPasswordThreshold is fraction of garbled accounts from
+	// which master password of legacy box is regarded wrong
+	DefaultWrongPasswordThreshold = 0.9
+
+	// minScoredAccounts is minimum number of accounts scored, a few
+	// binary accounts may be legitimate
+	minScoredAccounts = 3
+)
+
+// garbled reports whether account doesn't look like a decrypted account,
+// i.e. it's invalid UTF-8 or contains control characters. Any printable
+// text, e.g. CJK, is fine.
+func garbled(account []byte) bool {
+	if !utf8.Valid(account) {
+		return true
+	}
+	for len(account) > 0 {
+		r, size := utf8.DecodeRune(account)
+		if unicode.IsControl(r) {
+			return true
+		}
+		account = account[size:]
+	}
+	return false
+}
+
+// wrongPasswordThreshold returns threshold of garbled accounts, 0 if the
+// check is disabled
+func (box *Box) wrongPasswordThreshold() float64 {
+	threshold := box.options.WrongPasswordThreshold
+	if threshold == 0 {
+		return DefaultWrongPasswordThreshold
+	}
+	if threshold < 0 {
+		return 0
+	}
+	return threshold
+}
+
+// probablyWrongPassword scores decrypted accounts of passwords, it's true
+// if at least threshold of them are garbled
+func (box *Box) probablyWrongPassword(passwords []Password) bool {
+	threshold := box.wrongPasswordThreshold()
+	if threshold == 0 {
+		return false
+	}
+	scored, bad := 0, 0
+	for i := range passwords {
+		if len(passwords[i].PlainAccount) == 0 {
+			continue
+		}
+		scored++
+		if garbled(passwords[i].PlainAccount) {
+			bad++
+		}
+	}
+	if scored < minScoredAccounts {
+		return false
+	}
+	return float64(bad) >= threshold*float64(scored)
+}
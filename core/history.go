@@ -0,0 +1,97 @@
+package core
+
+import (
+	"strconv"
+	"time"
+)
+
+// DefaultHistoryLimit is max number of previous passwords kept per password
+// if Options.HistoryLimit is 0
+const DefaultHistoryLimit = 10
+
+// HistoryEntry is a previous password of a password entity
+type HistoryEntry struct {
+	// Plain previous password
+	PlainPassword Secret `json:"-"`
+
+	IV             []byte
+	CipherPassword []byte
+
+	// Time stamp when password was replaced
+	ReplacedAt int64
+}
+
+// additionalData binds cipher of history entry to password pw
+func (h *HistoryEntry) additionalData(pw *Password) []byte {
+	return pw.additionalData(fieldHistory + ":" + strconv.FormatInt(h.ReplacedAt, 10))
+}
+
+// pushHistory records current password of pw before it's replaced by
+// passwd, history is truncated to the latest limit entries
+func (pw *Password) pushHistory(passwd Secret, limit int) {
+	if len(pw.PlainPassword) > 0 && !secretEqual(pw.PlainPassword, passwd) {
+		pw.History = append(pw.History, HistoryEntry{
+			PlainPassword: pw.PlainPassword.clone(),
+			ReplacedAt:    time.Now().Unix(),
+		})
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	if n := len(pw.History) - limit; n > 0 {
+		for i := 0; i < n; i++ {
+			pw.History[i].PlainPassword.Wipe()
+		}
+		pw.History = append([]HistoryEntry{}, pw.History[n:]...)
+	}
+	if len(pw.History) == 0 {
+		pw.History = nil
+	}
+}
+
+func (pw *Password) cloneHistory() []HistoryEntry {
+	if pw.History == nil {
+		return nil
+	}
+	history := make([]HistoryEntry, len(pw.History))
+	copy(history, pw.History)
+	for i := range history {
+		history[i].PlainPassword = pw.History[i].PlainPassword.clone()
+	}
+	return history
+}
+
+func (box *Box) historyLimit() int {
+	if box.options.HistoryLimit == 0 {
+		return DefaultHistoryLimit
+	}
+	return box.options.HistoryLimit
+}
+
+// History returns previous passwords of password id with plaintext, the
+// oldest first. id may be a prefix of ID as Remove accepts. Plaintext of
+// returned entries should be wiped after use.
+func (box *Box) History(id string) ([]HistoryEntry, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	pw, err := box.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if !pw.sealed {
+		return pw.cloneHistory(), nil
+	}
+	history := pw.cloneHistory()
+	for i := range history {
+		h := &history[i]
+		plaintext, err := box.keys.open(pw.Scheme, fieldHistory, h.IV, h.CipherPassword, h.additionalData(pw))
+		if err != nil {
+			return nil, wrapErrPassword(pw.ID, err)
+		}
+		h.PlainPassword = plaintext
+	}
+	return history, nil
+}
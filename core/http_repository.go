@@ -0,0 +1,223 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultHTTPTimeout is timeout of requests of HTTPRepository by default
+const DefaultHTTPTimeout = 30 * time.Second
+
+// HTTPNetworkError is returned by HTTPRepository if server couldn't be
+// reached or response couldn't be read, retrying may succeed
+type HTTPNetworkError struct {
+	URL string
+	Err error
+}
+
+// Error implements error interface
+func (err *HTTPNetworkError) Error() string {
+	return fmt.Sprintf("request to %s failed: %v", err.URL, err.Err)
+}
+
+// Unwrap returns error of net/http
+func (err *HTTPNetworkError) Unwrap() error {
+	return err.Err
+}
+
+// HTTPRejectedError is returned by HTTPRepository if server responded an
+// unexpected status, e.g. 401 or 500
+type HTTPRejectedError struct {
+	URL        string
+	Method     string
+	StatusCode int
+	Status     string
+}
+
+// Error implements error interface
+func (err *HTTPRejectedError) Error() string {
+	return fmt.Sprintf("%s %s rejected by server: %s", err.Method, err.URL, err.Status)
+}
+
+// HTTPConflictError is returned by HTTPRepository.Save if box was saved by
+// others since loaded. Box of server isn't changed, it should be reloaded
+// and changes made again.
+type HTTPConflictError struct {
+	URL string
+}
+
+// Error implements error interface
+func (err *HTTPConflictError) Error() string {
+	return fmt.Sprintf("box %s was saved by others since loaded, reload it and try again", err.URL)
+}
+
+// HTTPRepository implements BoxRepository interface, box is got from and
+// put to URL. Saving is conditional on ETag of the box loaded, if server
+// responds ETags.
+type HTTPRepository struct {
+	URL string
+
+	// Token of bearer auth, basic auth of Username and Password is used if
+	// Token is empty and Username isn't
+	Token    string
+	Username string
+	Password string
+
+	// Authorize authorizes requests if not nil, e.g. by signing them,
+	// Token, Username and Password are ignored then
+	Authorize func(req *http.Request) error
+
+	// Header is added to every request
+	Header http.Header
+
+	// Timeout of requests, 0 means DefaultHTTPTimeout
+	Timeout time.Duration
+
+	// Client sends requests if not nil, Timeout is ignored then
+	Client *http.Client
+
+	mu     sync.Mutex
+	loaded bool
+	etag   string
+}
+
+// NewHTTPRepository creates a HTTPRepository, box is stored at rawURL
+func NewHTTPRepository(rawURL string) *HTTPRepository {
+	return &HTTPRepository{URL: rawURL}
+}
+
+// Load implements BoxRepository.Load method, empty data returned if server
+// responds 404
+func (repo *HTTPRepository) Load() ([]byte, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	resp, err := repo.do(http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		repo.loaded, repo.etag = true, ""
+		return []byte{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, repo.rejected(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &HTTPNetworkError{URL: repo.URL, Err: err}
+	}
+	// without ETag box saved next is put unconditionally
+	repo.etag = resp.Header.Get("ETag")
+	repo.loaded = repo.etag != ""
+	return data, nil
+}
+
+// Save implements BoxRepository.Save method. A *HTTPConflictError is
+// returned if server responds 412 since box was changed after the last Load
+// or Save. Box is put unconditionally if it was never loaded or server
+// responded no ETag to Load. ETag of the box saved is got by HEAD if server
+// responds no ETag to PUT, saving fails if it's still unknown.
+func (repo *HTTPRepository) Save(data []byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	header := http.Header{}
+	if repo.loaded {
+		if repo.etag == "" {
+			header.Set("If-None-Match", "*")
+		} else {
+			header.Set("If-Match", repo.etag)
+		}
+	}
+	resp, err := repo.do(http.MethodPut, bytes.NewReader(data), header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		return &HTTPConflictError{URL: repo.URL}
+	default:
+		return repo.rejected(resp)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" && repo.loaded {
+		// some servers respond no ETag to PUT
+		if etag, err = repo.headETag(); err != nil {
+			return err
+		}
+		if etag == "" {
+			// box can't be saved conditionally any more, so saving again
+			// fails as a conflict until reloaded
+			return newErrHTTPNoETag(repo.URL)
+		}
+	}
+	repo.etag = etag
+	repo.loaded = repo.etag != ""
+	return nil
+}
+
+func (repo *HTTPRepository) headETag() (string, error) {
+	resp, err := repo.do(http.MethodHead, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", repo.rejected(resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+func (repo *HTTPRepository) do(method string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, repo.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range repo.Header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	switch {
+	case repo.Authorize != nil:
+		if err := repo.Authorize(req); err != nil {
+			return nil, err
+		}
+	case repo.Token != "":
+		req.Header.Set("Authorization", "Bearer "+repo.Token)
+	case repo.Username != "":
+		req.SetBasicAuth(repo.Username, repo.Password)
+	}
+	resp, err := repo.client().Do(req)
+	if err != nil {
+		return nil, &HTTPNetworkError{URL: repo.URL, Err: err}
+	}
+	return resp, nil
+}
+
+func (repo *HTTPRepository) client() *http.Client {
+	if repo.Client != nil {
+		return repo.Client
+	}
+	timeout := repo.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+func (repo *HTTPRepository) rejected(resp *http.Response) error {
+	return &HTTPRejectedError{
+		URL:        repo.URL,
+		Method:     resp.Request.Method,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+}
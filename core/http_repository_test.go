@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// etagServer serves a box by GET, HEAD and conditional PUT, ETags are
+// responded to PUT and HEAD only if putETag and headETag
+type etagServer struct {
+	putETag, headETag bool
+
+	mu      sync.Mutex
+	data    []byte
+	version int
+}
+
+func (s *etagServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	etag := fmt.Sprintf(`"%d"`, s.version)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if s.data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet || s.headETag {
+			w.Header().Set("ETag", etag)
+		}
+		w.Write(s.data)
+	case http.MethodPut:
+		if match := r.Header.Get("If-Match"); (match != "" && match != etag) ||
+			(r.Header.Get("If-None-Match") == "*" && s.data != nil) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		s.data, _ = io.ReadAll(r.Body)
+		s.version++
+		if s.putETag {
+			w.Header().Set("ETag", fmt.Sprintf(`"%d"`, s.version))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestHTTPRepositoryConflict(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		putETag, headETag bool
+	}{
+		{"ETag responded to PUT", true, false},
+		{"ETag got by HEAD", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(&etagServer{putETag: tc.putETag, headETag: tc.headETag})
+			defer server.Close()
+			ours, theirs := NewHTTPRepository(server.URL), NewHTTPRepository(server.URL)
+			for _, repo := range []*HTTPRepository{ours, theirs} {
+				if _, err := repo.Load(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// saved twice by its own ETag
+			for _, data := range []string{"ours", "ours again"} {
+				if err := ours.Save([]byte(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var conflict *HTTPConflictError
+			if err := theirs.Save([]byte("theirs")); !errors.As(err, &conflict) {
+				t.Fatalf("Save: %v", err)
+			}
+			if data, err := theirs.Load(); err != nil || string(data) != "ours again" {
+				t.Fatalf("Load: %q, %v", data, err)
+			}
+			if err := theirs.Save([]byte("theirs")); err != nil {
+				t.Fatal(err)
+			}
+			if err := ours.Save([]byte("ours")); !errors.As(err, &conflict) {
+				t.Fatalf("Save: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPRepositoryNoETagSaved(t *testing.T) {
+	server := httptest.NewServer(&etagServer{})
+	defer server.Close()
+	repo := NewHTTPRepository(server.URL)
+	if err := repo.Save([]byte("box")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Load(); err != nil {
+		t.Fatal(err)
+	}
+	// saved, but it can't be saved conditionally again
+	if err := repo.Save([]byte("changed")); err == nil {
+		t.Fatal("Save succeeded without ETag")
+	}
+	var conflict *HTTPConflictError
+	if err := repo.Save([]byte("changed again")); !errors.As(err, &conflict) {
+		t.Fatalf("Save: %v", err)
+	}
+	if data, err := repo.Load(); err != nil || string(data) != "changed" {
+		t.Fatalf("Load: %q, %v", data, err)
+	}
+}
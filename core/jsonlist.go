@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// PasswordJSON is a decrypted password written by ListJSON. Names of its
+// JSON fields are stable, scripts may depend on them. Time stamps are unix
+// seconds, 0 if unknown.
+type PasswordJSON struct {
+	ID           string            `json:"id"`
+	Category     string            `json:"category"`
+	Account      string            `json:"account"`
+	Password     string            `json:"password"`
+	Site         string            `json:"site"`
+	URL          string            `json:"url"`
+	Tags         []string          `json:"tags"`
+	Notes        string            `json:"notes"`
+	CustomFields map[string]string `json:"fields"`
+	Derived      bool              `json:"derived"`
+	ExpiresAt    int64             `json:"expires_at"`
+	CreatedAt    int64             `json:"created_at"`
+	UpdatedAt    int64             `json:"updated_at"`
+}
+
+func newPasswordJSON(pw *Password) PasswordJSON {
+	p := PasswordJSON{
+		ID:           pw.ID,
+		Category:     pw.Category,
+		Account:      pw.PlainAccount.String(),
+		Password:     pw.PlainPassword.String(),
+		Site:         pw.Site,
+		URL:          pw.URL,
+		Tags:         pw.Tags,
+		Notes:        pw.PlainNotes.String(),
+		CustomFields: pw.CustomFields,
+		Derived:      pw.Derived,
+		ExpiresAt:    pw.ExpiresAt,
+		CreatedAt:    pw.CreatedAt,
+		UpdatedAt:    pw.LastUpdatedAt,
+	}
+	// scripts get arrays and objects rather than null
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	if p.CustomFields == nil {
+		p.CustomFields = map[string]string{}
+	}
+	return p
+}
+
+// ListJSON writes all passwords sorted by id to specified writer as a JSON
+// array of PasswordJSON. Plaintext is written, ciphers and IVs aren't.
+func (box *Box) ListJSON(w io.Writer) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.unsealCached(); err != nil {
+		return err
+	}
+	passwords := box.sortedPasswords()
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	entries := make([]PasswordJSON, len(passwords))
+	for i := range passwords {
+		entries[i] = newPasswordJSON(&passwords[i])
+	}
+	buf := new(bytes.Buffer)
+	defer func() { Secret(buf.Bytes()).Wipe() }()
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entries); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
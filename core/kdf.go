@@ -0,0 +1,228 @@
+package core
+
+import (
+	"crypto/sha256"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key derivation functions
+const (
+	// KDFMD5 is the legacy unsalted md5 derivation, only used for opening old boxes
+	KDFMD5 = "md5"
+	// KDFPBKDF2 is PBKDF2-HMAC-SHA256
+	KDFPBKDF2 = "pbkdf2"
+	// KDFScrypt is memory-hard scrypt
+	KDFScrypt = "scrypt"
+	// KDFArgon2id is memory-hard Argon2id
+	KDFArgon2id = "argon2id"
+
+	// kdfKeySlots in box header means key of box is wrapped in key slots,
+	// each slot has its own key derivation config
+	kdfKeySlots = "keyslots"
+
+	// kdfRecovery marks key slots wrapped by a recovery key, the key is
+	// random so it's expanded by HKDF rather than stretched
+	kdfRecovery = "recovery"
+)
+
+const (
+	// DefaultIterations is default iteration count of PBKDF2
+	DefaultIterations = 100000
+
+	// Default cost parameters of scrypt, about 100ms on a typical laptop
+	DefaultScryptN = 1 << 15
+	DefaultScryptR = 8
+	DefaultScryptP = 1
+
+	// Default parameters of Argon2id, memory in KiB, see DefaultArgon2Params
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+
+	keyLength  = 32
+	saltLength = 16
+)
+
+// Upper limits of key derivation parameters read from repo, so a crafted
+// box header can't make us burn minutes of CPU or gigabytes of memory.
+// Passes of Argon2id are bounded with memory too, as each of them fills
+// memory once, e.g. 4 passes over 1 GiB or 64 passes over 64 MiB.
+const (
+	maxIterations    = 10000000
+	maxKDFMemory     = 1 << 30 // bytes
+	maxScryptP       = 16
+	maxArgon2Time    = 64
+	maxArgon2Threads = 64
+	maxArgon2Work    = 4 << 30 // bytes of memory times passes
+)
+
+// KDFConfig represents key derivation parameters of box
+type KDFConfig struct {
+	// Type of key derivation function
+	Type string
+
+	// Random salt of box
+	Salt []byte `json:",omitempty"`
+
+	// Iteration count of PBKDF2
+	Iterations int `json:",omitempty"`
+
+	// Cost parameters of scrypt
+	N int `json:",omitempty"`
+	R int `json:",omitempty"`
+	P int `json:",omitempty"`
+
+	// Parameters of Argon2id
+	Argon2Params
+}
+
+// Argon2Params represents cost parameters of Argon2id
+type Argon2Params struct {
+	// Number of passes over memory
+	Time uint32 `json:",omitempty"`
+	// Size of memory in KiB
+	Memory uint32 `json:",omitempty"`
+	// Degree of parallelism
+	Threads uint8 `json:",omitempty"`
+}
+
+// DefaultArgon2Params returns default cost parameters of Argon2id
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Time:    DefaultArgon2Time,
+		Memory:  DefaultArgon2Memory,
+		Threads: DefaultArgon2Threads,
+	}
+}
+
+func (p Argon2Params) valid() bool {
+	return p.Time > 0 && p.Memory > 0 && p.Threads > 0
+}
+
+func (p Argon2Params) deriveKey(masterPassword string, salt []byte) []byte {
+	return argon2.IDKey([]byte(masterPassword), salt, p.Time, p.Memory, p.Threads, keyLength)
+}
+
+// DefaultKDFConfig returns the key derivation config used by NewBox
+func DefaultKDFConfig() KDFConfig {
+	return KDFConfig{
+		Type:       KDFPBKDF2,
+		Iterations: DefaultIterations,
+	}
+}
+
+// withDefaults returns a copy of cfg with unset parameters filled by defaults
+func (cfg KDFConfig) withDefaults() KDFConfig {
+	switch cfg.Type {
+	case "":
+		return DefaultKDFConfig()
+
+	case KDFPBKDF2:
+		if cfg.Iterations <= 0 {
+			cfg.Iterations = DefaultIterations
+		}
+
+	case KDFScrypt:
+		if cfg.N <= 0 {
+			cfg.N = DefaultScryptN
+		}
+		if cfg.R <= 0 {
+			cfg.R = DefaultScryptR
+		}
+		if cfg.P <= 0 {
+			cfg.P = DefaultScryptP
+		}
+
+	case KDFArgon2id:
+		if cfg.Time == 0 {
+			cfg.Time = DefaultArgon2Time
+		}
+		if cfg.Memory == 0 {
+			cfg.Memory = DefaultArgon2Memory
+		}
+		if cfg.Threads == 0 {
+			cfg.Threads = DefaultArgon2Threads
+		}
+	}
+	return cfg
+}
+
+// checkLimits validates parameters of cfg against upper limits
+func (cfg KDFConfig) checkLimits() error {
+	switch cfg.Type {
+	case KDFPBKDF2:
+		if cfg.Iterations > maxIterations {
+			return errKDFParamsTooLarge
+		}
+
+	case KDFScrypt:
+		if cfg.R <= 0 || cfg.P <= 0 || cfg.N <= 0 {
+			return errInvalidKDFParams
+		}
+		if cfg.P > maxScryptP || int64(cfg.N)*int64(cfg.R)*128 > maxKDFMemory {
+			return errKDFParamsTooLarge
+		}
+
+	case KDFArgon2id:
+		if cfg.Time > maxArgon2Time || cfg.Threads > maxArgon2Threads || int64(cfg.Memory)*1024 > maxKDFMemory {
+			return errKDFParamsTooLarge
+		}
+		if int64(cfg.Time)*int64(cfg.Memory)*1024 > maxArgon2Work {
+			return errKDFParamsTooLarge
+		}
+	}
+	return nil
+}
+
+func (cfg KDFConfig) isLegacy() bool {
+	return cfg.Type == "" || cfg.Type == KDFMD5
+}
+
+// withSalt returns a copy of cfg with a new random salt
+func (cfg KDFConfig) withSalt() (KDFConfig, error) {
+	salt, err := randomBytes(saltLength)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Salt = salt
+	return cfg, nil
+}
+
+func (cfg KDFConfig) deriveKey(masterPassword string) ([]byte, error) {
+	switch cfg.Type {
+	case "", KDFMD5:
+		return []byte(md5sum(masterPassword)), nil
+
+	case KDFPBKDF2:
+		if len(cfg.Salt) == 0 {
+			return nil, errEmptySalt
+		}
+		if cfg.Iterations <= 0 {
+			return nil, errInvalidKDFParams
+		}
+		return pbkdf2.Key([]byte(masterPassword), cfg.Salt, cfg.Iterations, keyLength, sha256.New), nil
+
+	case KDFScrypt:
+		if len(cfg.Salt) == 0 {
+			return nil, errEmptySalt
+		}
+		key, err := scrypt.Key([]byte(masterPassword), cfg.Salt, cfg.N, cfg.R, cfg.P, keyLength)
+		if err != nil {
+			return nil, errInvalidKDFParams
+		}
+		return key, nil
+
+	case KDFArgon2id:
+		if len(cfg.Salt) == 0 {
+			return nil, errEmptySalt
+		}
+		if !cfg.Argon2Params.valid() {
+			return nil, errInvalidKDFParams
+		}
+		return cfg.Argon2Params.deriveKey(masterPassword, cfg.Salt), nil
+	}
+	return nil, newErrUnsupportedKDF(cfg.Type)
+}
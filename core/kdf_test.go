@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestKDFRoundTrip(t *testing.T) {
+	for _, cfg := range []KDFConfig{
+		{Type: KDFPBKDF2, Iterations: 1000},
+		{Type: KDFScrypt, N: 1024, R: 4, P: 2},
+		{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: 2, Memory: 1024, Threads: 2}},
+	} {
+		t.Run(cfg.Type, func(t *testing.T) {
+			repo := NewMemoryRepository(nil)
+			box := NewBoxWithOptions(repo, Options{KDF: cfg})
+			if err := box.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bd, err := parseBoxData(repo.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved := bd.KeySlots[0].KDF
+			if len(saved.Salt) != saltLength {
+				t.Fatalf("salt %x", saved.Salt)
+			}
+			saved.Salt = nil
+			if !reflect.DeepEqual(saved, cfg) {
+				t.Fatalf("KDF saved as %+v", saved)
+			}
+
+			// parameters are read from the box, not from defaults of opener
+			other := NewBoxWithOptions(repo, Options{KDF: KDFConfig{Type: KDFArgon2id}})
+			if err := other.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			if got := getPassword(t, other, id); string(got.PlainPassword) != "pw123456" {
+				t.Fatalf("password %q", got.PlainPassword)
+			}
+			if err := NewBox(repo).Init("wrong-Master#1"); err != errWrongMasterPassword {
+				t.Fatalf("wrong master password: %v", err)
+			}
+		})
+	}
+}
+
+func TestKDFDefaultsAndLimits(t *testing.T) {
+	cfg := KDFConfig{Type: KDFArgon2id}.withDefaults()
+	if cfg.Argon2Params != DefaultArgon2Params() {
+		t.Fatalf("defaults of Argon2id %+v", cfg.Argon2Params)
+	}
+	if cfg := (KDFConfig{}).withDefaults(); !reflect.DeepEqual(cfg, DefaultKDFConfig()) {
+		t.Fatalf("default KDF %+v", cfg)
+	}
+	for _, cfg := range []KDFConfig{
+		{Type: KDFPBKDF2, Iterations: maxIterations + 1},
+		{Type: KDFScrypt, N: 1 << 30, R: 8, P: 1},
+		{Type: KDFScrypt, N: 1024},
+		{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: 1, Memory: maxKDFMemory, Threads: 1}},
+		// each in limits, but passes over memory are too many
+		{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: maxArgon2Time, Memory: maxKDFMemory / 1024, Threads: 1}},
+		{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: 5, Memory: maxKDFMemory / 1024, Threads: 1}},
+	} {
+		if err := cfg.checkLimits(); err == nil {
+			t.Errorf("%+v passed limits", cfg)
+		}
+	}
+	for _, cfg := range []KDFConfig{
+		{Type: KDFArgon2id, Argon2Params: DefaultArgon2Params()},
+		{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: 4, Memory: maxKDFMemory / 1024, Threads: 1}},
+		{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: maxArgon2Time, Memory: 64 * 1024, Threads: 1}},
+	} {
+		if err := cfg.checkLimits(); err != nil {
+			t.Errorf("%+v exceeded limits: %v", cfg, err)
+		}
+	}
+
+	// header crafted to make opening box take minutes is refused before
+	// deriving any key
+	box := NewBoxWithOptions(NewMemoryRepository(nil), Options{KDF: KDFConfig{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: 1, Memory: 1024, Threads: 1}}})
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	repo := box.repo.(*MemoryRepository)
+	editSaved(t, repo, func(bd map[string]interface{}) {
+		slot := bd["KeySlots"].([]interface{})[0].(map[string]interface{})
+		kdf := slot["KDF"].(map[string]interface{})
+		kdf["Time"], kdf["Memory"] = maxArgon2Time, maxKDFMemory/1024
+	})
+	if err := NewBox(repo).Init(testMasterPassword); err != errKDFParamsTooLarge {
+		t.Fatalf("Init: %v", err)
+	}
+}
+
+// legacyBoxData returns a box of the baseline format: a bare JSON array of
+// passwords encrypted by AES-CFB keyed by md5 of master password
+func legacyBoxData(t *testing.T, masterPassword string) []byte {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(md5sum(masterPassword)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	passwords := []Password{}
+	// a wrong master password is told by at least minScoredAccounts accounts
+	for i, account := range []string{"me@example.com", "you@example.com", "them@example.com"} {
+		pw := NewPassword("email", account, "secret123", "example.com")
+		pw.ID = fmt.Sprintf("abcdef012345678%d", i)
+		pw.AccountIV, pw.PasswordIV = iv, iv
+		pw.CipherAccount = cfbEncrypt(block, iv, pw.PlainAccount)
+		pw.CipherPassword = cfbEncrypt(block, iv, pw.PlainPassword)
+		passwords = append(passwords, *pw)
+	}
+	data, err := json.Marshal(passwords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLegacyBoxMigrated(t *testing.T) {
+	legacy := legacyBoxData(t, testMasterPassword)
+	repo := NewMemoryRepository(legacy)
+	if err := NewBox(repo).Init("wrong-Master#1"); err != errProbablyWrongMasterPassword {
+		t.Fatalf("legacy box opened by wrong master password: %v", err)
+	}
+	if !bytes.Equal(repo.Bytes(), legacy) {
+		t.Fatal("legacy box changed by wrong master password")
+	}
+
+	box := NewBox(repo)
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	bd, err := parseBoxData(repo.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd.Version != formatVersion || len(bd.KeySlots) != 1 || bd.KeySlots[0].KDF.isLegacy() {
+		t.Fatalf("not upgraded: version %d, %d key slots", bd.Version, len(bd.KeySlots))
+	}
+	if scheme := bd.Passwords[0].Scheme; scheme == "" || scheme == schemeCFB {
+		t.Fatalf("password still encrypted by %q", scheme)
+	}
+
+	upgraded := NewBox(repo)
+	if err := upgraded.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	got := getPassword(t, upgraded, "abcdef0123456780")
+	if string(got.PlainAccount) != "me@example.com" || string(got.PlainPassword) != "secret123" {
+		t.Fatalf("migrated password %q / %q", got.PlainAccount, got.PlainPassword)
+	}
+}
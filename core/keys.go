@@ -0,0 +1,447 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Labels for deriving sub keys from master key of box
+const (
+	encryptionKeyLabel = "onepw encryption key"
+	macKeyLabel        = "onepw box mac key"
+	verifierLabel      = "onepw master password verifier"
+	keyfileLabel       = "onepw keyfile"
+	keySlotLabel       = "onepw key slot"
+	tokenLabel         = "onepw hardware token"
+	pepperLabel        = "onepw pepper"
+	fieldKeyLabel      = "onepw field key "
+	exportKeyLabel     = "onepw export signing key"
+	shareLabel         = "onepw shared entry"
+	derivedLabel       = "onepw derived password"
+	recoveryKeyLabel   = "onepw recovery key"
+)
+
+// Encrypted fields of password, each field is encrypted by its own sub key
+// in boxes since version 12
+const (
+	fieldAccount  = "account"
+	fieldPassword = "password"
+	fieldNotes    = "notes"
+	fieldMetadata = "metadata"
+	fieldHistory  = "history"
+	fieldTOTP     = "totp"
+	fieldCustom   = "custom"
+)
+
+var encryptedFields = []string{fieldAccount, fieldPassword, fieldNotes, fieldMetadata, fieldHistory, fieldTOTP, fieldCustom}
+
+// fieldKeysVersion is the first format version encrypting fields by sub keys
+const fieldKeysVersion = 12
+
+// Key sizes of AES in bits
+const (
+	KeySize128 = 128
+	KeySize256 = 256
+
+	// DefaultKeySize is key size of AES used by new boxes
+	DefaultKeySize = KeySize256
+)
+
+func checkKeySize(bits int) error {
+	if bits != KeySize128 && bits != KeySize256 {
+		return newErrUnsupportedKeySize(bits)
+	}
+	return nil
+}
+
+// boxKeys holds keys derived from master key of box. They are derived once
+// when master key changed, rather than on every encryption.
+type boxKeys struct {
+	master []byte
+
+	// block keyed by master key, only for decrypting legacy AES-CFB ciphers
+	legacyBlock cipher.Block
+
+	gcm      cipher.AEAD
+	chacha   cipher.AEAD
+	macKey   []byte
+	verifier []byte
+
+	// AEADs of sub keys of encrypted fields, nil in boxes before field keys
+	fields map[string]*fieldAEADs
+}
+
+// fieldAEADs are AEADs of sub key of a field
+type fieldAEADs struct {
+	gcm    cipher.AEAD
+	chacha cipher.AEAD
+}
+
+// newFieldAEADs creates AEADs of key, key of AES is truncated to keySize bits
+func newFieldAEADs(key []byte, keySize int) (*fieldAEADs, error) {
+	block, err := aes.NewCipher(key[:keySize/8])
+	if err != nil {
+		return nil, err
+	}
+	aeads := &fieldAEADs{}
+	if aeads.gcm, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	if aeads.chacha, err = chacha20poly1305.New(key); err != nil {
+		return nil, err
+	}
+	return aeads, nil
+}
+
+// newBoxKeys derives keys from master key for box format version, key of
+// AES is truncated to keySize bits. Boxes before version 1 use master key
+// for encryption directly.
+func newBoxKeys(master []byte, version int, keySize int) (*boxKeys, error) {
+	keys := &boxKeys{master: master}
+	legacyBlock, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	keys.legacyBlock = legacyBlock
+
+	encKey := master
+	if version >= 1 {
+		if encKey, err = hkdfKey(master, encryptionKeyLabel); err != nil {
+			return nil, err
+		}
+		if keys.macKey, err = hkdfKey(master, macKeyLabel); err != nil {
+			return nil, err
+		}
+		if keys.verifier, err = hkdfKey(master, verifierLabel); err != nil {
+			return nil, err
+		}
+	} else {
+		keys.macKey = hmacSHA256(master, []byte(macKeyLabel))
+		keys.verifier = hmacSHA256(master, []byte(verifierLabel))
+	}
+
+	aesKey := encKey
+	if version >= 1 {
+		aesKey = encKey[:keySize/8]
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	if keys.gcm, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	if keys.chacha, err = chacha20poly1305.New(encKey); err != nil {
+		return nil, err
+	}
+	if version >= fieldKeysVersion {
+		keys.fields = map[string]*fieldAEADs{}
+		for _, field := range encryptedFields {
+			key, err := hkdfKey(master, fieldKeyLabel+field)
+			if err != nil {
+				return nil, err
+			}
+			aeads, err := newFieldAEADs(key, keySize)
+			Secret(key).Wipe()
+			if err != nil {
+				return nil, err
+			}
+			keys.fields[field] = aeads
+		}
+	}
+	return keys, nil
+}
+
+// wipe zeroes master key and keys derived from it. AEADs can't be wiped,
+// keys must not be used after.
+func (keys *boxKeys) wipe() {
+	Secret(keys.master).Wipe()
+	Secret(keys.macKey).Wipe()
+	Secret(keys.verifier).Wipe()
+}
+
+// aead returns AEAD of cipher for field, AEAD of encryption key is
+// returned for the whole box (an empty field) and boxes before field keys
+func (keys *boxKeys) aead(cipherName, field string) (cipher.AEAD, error) {
+	gcm, chacha := keys.gcm, keys.chacha
+	if aeads, ok := keys.fields[field]; ok {
+		gcm, chacha = aeads.gcm, aeads.chacha
+	}
+	switch cipherName {
+	case CipherAESGCM:
+		return gcm, nil
+	case CipherChaCha20Poly1305:
+		return chacha, nil
+	}
+	return nil, newErrUnsupportedCipher(cipherName)
+}
+
+func hkdfKey(secret []byte, label string) ([]byte, error) {
+	key := make([]byte, keyLength)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(label)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// checkVerifier verifies master password without decrypting any password
+func (keys *boxKeys) checkVerifier(verifier []byte) bool {
+	return hmac.Equal(keys.verifier, verifier)
+}
+
+// mac returns MAC of serialized passwords
+func (keys *boxKeys) mac(data []byte) []byte {
+	return hmacSHA256(keys.macKey, data)
+}
+
+func (keys *boxKeys) checkMAC(data, mac []byte) bool {
+	return hmac.Equal(keys.mac(data), mac)
+}
+
+// seal encrypts plaintext by cipher with a new random IV, a nonce must
+// never be reused, so IVs are regenerated on every encryption
+func (keys *boxKeys) seal(cipherName, field string, plaintext, additionalData []byte) (iv, ciphertext []byte, err error) {
+	aead, err := keys.aead(cipherName, field)
+	if err != nil {
+		return nil, nil, err
+	}
+	if iv, err = randomBytes(aead.NonceSize()); err != nil {
+		return nil, nil, err
+	}
+	return iv, aead.Seal(nil, iv, plaintext, additionalData), nil
+}
+
+// open decrypts and authenticates ciphertext encrypted by seal
+func (keys *boxKeys) open(cipherName, field string, iv, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := keys.aead(cipherName, field)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aead.NonceSize() {
+		return nil, errLengthOfIV
+	}
+	plaintext, err := aead.Open(nil, iv, ciphertext, additionalData)
+	if err != nil {
+		return nil, errAuthFailed
+	}
+	return plaintext, nil
+}
+
+// encrypt encrypts pw by cipher
+func (keys *boxKeys) encrypt(pw *Password, cipherName string) error {
+	var err error
+	if pw.AccountIV, pw.CipherAccount, err = keys.seal(cipherName, fieldAccount, pw.PlainAccount, pw.additionalData(fieldAccount)); err != nil {
+		return err
+	}
+	if pw.Derived {
+		// nothing stored, plaintext is replaced by the derived password
+		passwd, err := keys.derivePassword(pw)
+		if err != nil {
+			return err
+		}
+		pw.PlainPassword.Wipe()
+		pw.PlainPassword = passwd
+		pw.PasswordIV, pw.CipherPassword = []byte{}, []byte{}
+	} else if pw.PasswordIV, pw.CipherPassword, err = keys.seal(cipherName, fieldPassword, pw.PlainPassword, pw.additionalData(fieldPassword)); err != nil {
+		return err
+	}
+	if len(pw.PlainNotes) == 0 {
+		pw.NotesIV, pw.CipherNotes = nil, nil
+	} else if pw.NotesIV, pw.CipherNotes, err = keys.seal(cipherName, fieldNotes, pw.PlainNotes, pw.additionalData(fieldNotes)); err != nil {
+		return err
+	}
+	if len(pw.PlainTOTPSecret) == 0 {
+		pw.TOTPIV, pw.CipherTOTPSecret = nil, nil
+	} else if pw.TOTPIV, pw.CipherTOTPSecret, err = keys.seal(cipherName, fieldTOTP, pw.PlainTOTPSecret, pw.additionalData(fieldTOTP)); err != nil {
+		return err
+	}
+	if err := keys.encryptCustomFields(pw, cipherName); err != nil {
+		return err
+	}
+	for i := range pw.History {
+		h := &pw.History[i]
+		if h.IV, h.CipherPassword, err = keys.seal(cipherName, fieldHistory, h.PlainPassword, h.additionalData(pw)); err != nil {
+			return err
+		}
+	}
+	pw.Scheme = cipherName
+	return nil
+}
+
+// decrypt decrypts pw by the scheme it was encrypted
+func (keys *boxKeys) decrypt(pw *Password) error {
+	if pw.Scheme == "" || pw.Scheme == schemeCFB {
+		block := keys.legacyBlock
+		if len(pw.AccountIV) != block.BlockSize() {
+			return errLengthOfIV
+		}
+		if len(pw.PasswordIV) != block.BlockSize() {
+			return errLengthOfIV
+		}
+		pw.PlainAccount = cfbDecrypt(block, pw.AccountIV, pw.CipherAccount)
+		pw.PlainPassword = cfbDecrypt(block, pw.PasswordIV, pw.CipherPassword)
+		pw.sealed = false
+		return nil
+	}
+
+	fail := func(err error) error {
+		pw.wipe()
+		return wrapErrPassword(pw.ID, err)
+	}
+	var err error
+	if pw.PlainAccount, err = keys.open(pw.Scheme, fieldAccount, pw.AccountIV, pw.CipherAccount, pw.additionalData(fieldAccount)); err != nil {
+		return fail(err)
+	}
+	if pw.Derived {
+		if pw.PlainPassword, err = keys.derivePassword(pw); err != nil {
+			return fail(err)
+		}
+	} else if pw.PlainPassword, err = keys.open(pw.Scheme, fieldPassword, pw.PasswordIV, pw.CipherPassword, pw.additionalData(fieldPassword)); err != nil {
+		return fail(err)
+	}
+	pw.PlainNotes, pw.PlainTOTPSecret = nil, nil
+	if len(pw.CipherNotes) > 0 {
+		if pw.PlainNotes, err = keys.open(pw.Scheme, fieldNotes, pw.NotesIV, pw.CipherNotes, pw.additionalData(fieldNotes)); err != nil {
+			return fail(err)
+		}
+	}
+	if len(pw.CipherTOTPSecret) > 0 {
+		if pw.PlainTOTPSecret, err = keys.open(pw.Scheme, fieldTOTP, pw.TOTPIV, pw.CipherTOTPSecret, pw.additionalData(fieldTOTP)); err != nil {
+			return fail(err)
+		}
+	}
+	pw.CustomFields = nil
+	if len(pw.CipherCustomFields) > 0 {
+		if pw.CustomFields, err = keys.decryptCustomFields(pw); err != nil {
+			return fail(err)
+		}
+	}
+	// pw may be a copy sharing history with the original
+	if len(pw.History) > 0 {
+		pw.History = append([]HistoryEntry{}, pw.History...)
+	}
+	for i := range pw.History {
+		h := &pw.History[i]
+		if h.PlainPassword, err = keys.open(pw.Scheme, fieldHistory, h.IV, h.CipherPassword, h.additionalData(pw)); err != nil {
+			return fail(err)
+		}
+	}
+	pw.sealed = false
+	return nil
+}
+
+// encryptCustomFields encrypts custom fields of pw as a whole by cipher
+func (keys *boxKeys) encryptCustomFields(pw *Password, cipherName string) error {
+	if len(pw.CustomFields) == 0 {
+		pw.CustomFieldsIV, pw.CipherCustomFields = nil, nil
+		return nil
+	}
+	data, err := json.Marshal(pw.CustomFields)
+	if err != nil {
+		return err
+	}
+	defer Secret(data).Wipe()
+	pw.CustomFieldsIV, pw.CipherCustomFields, err = keys.seal(cipherName, fieldCustom, data, pw.additionalData(fieldCustom))
+	return err
+}
+
+// decryptCustomFields decrypts custom fields of pw
+func (keys *boxKeys) decryptCustomFields(pw *Password) (map[string]string, error) {
+	data, err := keys.open(pw.Scheme, fieldCustom, pw.CustomFieldsIV, pw.CipherCustomFields, pw.additionalData(fieldCustom))
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(data).Wipe()
+	fields := map[string]string{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// encryptMetadata encrypts metadata of pw by cipher, time stamps of pw are
+// encrypted too if timestamps
+func (keys *boxKeys) encryptMetadata(pw *Password, cipherName string, timestamps bool) error {
+	md := pw.metadata()
+	if timestamps {
+		md.Timestamps = pw.timestamps()
+	}
+	data, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	defer Secret(data).Wipe()
+	pw.MetadataIV, pw.CipherMetadata, err = keys.seal(cipherName, fieldMetadata, data, pw.additionalData(fieldMetadata))
+	return err
+}
+
+// decryptMetadata decrypts metadata of pw if it was encrypted
+func (keys *boxKeys) decryptMetadata(pw *Password) error {
+	if len(pw.CipherMetadata) == 0 {
+		return nil
+	}
+	data, err := keys.open(pw.Scheme, fieldMetadata, pw.MetadataIV, pw.CipherMetadata, pw.additionalData(fieldMetadata))
+	if err != nil {
+		return wrapErrPassword(pw.ID, err)
+	}
+	md := passwordMetadata{}
+	if err := json.Unmarshal(data, &md); err != nil {
+		return wrapErrPassword(pw.ID, err)
+	}
+	pw.setMetadata(md)
+	return nil
+}
+
+func keySlotAEAD(slotKey []byte) (cipher.AEAD, error) {
+	key, err := hkdfKey(slotKey, keySlotLabel)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey encrypts data key of box by key of a key slot
+func wrapKey(slotKey, dataKey []byte) (iv, wrapped []byte, err error) {
+	aead, err := keySlotAEAD(slotKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if iv, err = randomBytes(aead.NonceSize()); err != nil {
+		return nil, nil, err
+	}
+	return iv, aead.Seal(nil, iv, dataKey, []byte(keySlotLabel)), nil
+}
+
+// unwrapKey decrypts data key wrapped by wrapKey, it fails if slotKey
+// is not the key of slot
+func unwrapKey(slotKey, iv, wrapped []byte) ([]byte, error) {
+	aead, err := keySlotAEAD(slotKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aead.NonceSize() {
+		return nil, errLengthOfIV
+	}
+	dataKey, err := aead.Open(nil, iv, wrapped, []byte(keySlotLabel))
+	if err != nil {
+		return nil, errAuthFailed
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+// editSaved modifies box saved in repo as generic JSON by edit
+func editSaved(t *testing.T, repo *MemoryRepository, edit func(bd map[string]interface{})) {
+	t.Helper()
+	bd := map[string]interface{}{}
+	if err := json.Unmarshal(repo.Bytes(), &bd); err != nil {
+		t.Fatal(err)
+	}
+	edit(bd)
+	data, err := json.Marshal(bd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flipBase64 returns base64 of data in s with its first byte flipped
+func flipBase64(t *testing.T, s interface{}) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(s.(string))
+	if err != nil || len(data) == 0 {
+		t.Fatalf("decoding %v: %v", s, err)
+	}
+	data[0] ^= 0xff
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func savedPasswords(bd map[string]interface{}) []interface{} {
+	return bd["Passwords"].([]interface{})
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	for _, cipherName := range []string{CipherAESGCM, CipherChaCha20Poly1305} {
+		t.Run(cipherName, func(t *testing.T) {
+			repo := NewMemoryRepository(nil)
+			box := NewBoxWithOptions(repo, Options{Cipher: cipherName})
+			if err := box.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			pw := NewPassword("github", "me", "pw123456", "github.com")
+			pw.PlainNotes = Secret("recovery codes")
+			id, _, err := box.Add(pw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bd, err := parseBoxData(repo.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bd.Cipher != cipherName || bd.Passwords[0].Scheme != cipherName {
+				t.Fatalf("saved by %q, password by %q", bd.Cipher, bd.Passwords[0].Scheme)
+			}
+			got := getPassword(t, reopen(t, repo, testMasterPassword), id)
+			if string(got.PlainPassword) != "pw123456" || string(got.PlainNotes) != "recovery codes" {
+				t.Fatalf("decrypted %q, notes %q", got.PlainPassword, got.PlainNotes)
+			}
+		})
+	}
+}
+
+func TestChangeCipher(t *testing.T) {
+	box := newTestBox(t)
+	repo := box.repo.(*MemoryRepository)
+	id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := box.ChangeCipher("des"); err == nil {
+		t.Fatal("changed cipher to des")
+	}
+	if err := box.ChangeCipher(CipherChaCha20Poly1305); err != nil {
+		t.Fatal(err)
+	}
+	bd, err := parseBoxData(repo.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd.Cipher != CipherChaCha20Poly1305 || bd.Passwords[0].Scheme != CipherChaCha20Poly1305 {
+		t.Fatalf("saved by %q, password by %q", bd.Cipher, bd.Passwords[0].Scheme)
+	}
+	if got := getPassword(t, reopen(t, repo, testMasterPassword), id); string(got.PlainPassword) != "pw123456" {
+		t.Fatalf("decrypted %q", got.PlainPassword)
+	}
+}
+
+func TestTamperedBoxRejected(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		edit func(t *testing.T, bd map[string]interface{})
+		want error
+	}{
+		{"ciphertext", func(t *testing.T, bd map[string]interface{}) {
+			pw := savedPasswords(bd)[0].(map[string]interface{})
+			pw["CipherPassword"] = flipBase64(t, pw["CipherPassword"])
+		}, errBoxTampered},
+		{"password removed", func(t *testing.T, bd map[string]interface{}) {
+			bd["Passwords"] = savedPasswords(bd)[:1]
+		}, errBoxTampered},
+		{"header", func(t *testing.T, bd map[string]interface{}) {
+			bd["Cipher"] = CipherChaCha20Poly1305
+		}, errBoxTampered},
+		{"mac", func(t *testing.T, bd map[string]interface{}) {
+			bd["MAC"] = flipBase64(t, bd["MAC"])
+		}, errBoxTampered},
+		{"key slot", func(t *testing.T, bd map[string]interface{}) {
+			slot := bd["KeySlots"].([]interface{})[0].(map[string]interface{})
+			slot["Key"] = flipBase64(t, slot["Key"])
+		}, errWrongMasterPassword},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			box := newTestBox(t)
+			for _, account := range []string{"me", "you"} {
+				if _, _, err := box.Add(NewPassword("github", account, "pw123456", "github.com")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			repo := box.repo.(*MemoryRepository)
+			editSaved(t, repo, func(bd map[string]interface{}) { tt.edit(t, bd) })
+			if err := NewBox(repo).Init(testMasterPassword); err != tt.want {
+				t.Fatalf("Init: %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTamperedCiphertextRejectedIgnoringMAC(t *testing.T) {
+	box := newTestBox(t)
+	id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := box.repo.(*MemoryRepository)
+	editSaved(t, repo, func(bd map[string]interface{}) {
+		pw := savedPasswords(bd)[0].(map[string]interface{})
+		pw["CipherPassword"] = flipBase64(t, pw["CipherPassword"])
+	})
+	// each field is authenticated by its cipher even if MAC of box is ignored
+	ignoring := NewBoxWithOptions(repo, Options{IgnoreMAC: true})
+	if err := ignoring.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ignoring.Get(id); err == nil {
+		t.Fatal("tampered password decrypted")
+	}
+}
+
+func benchmarkSeal(b *testing.B, cipherName string) {
+	keys, err := newBoxKeys(make([]byte, keyLength), formatVersion, DefaultKeySize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	plaintext := make([]byte, 1024)
+	b.SetBytes(int64(len(plaintext)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iv, ciphertext, err := keys.seal(cipherName, fieldPassword, plaintext, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := keys.open(cipherName, fieldPassword, iv, ciphertext, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSealAESGCM(b *testing.B)           { benchmarkSeal(b, CipherAESGCM) }
+func BenchmarkSealChaCha20Poly1305(b *testing.B) { benchmarkSeal(b, CipherChaCha20Poly1305) }
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Lock wipes master password, keys and plaintext of passwords from memory,
+// only ciphers are kept. Operations on a locked box return errBoxLocked
+// until it's unlocked by Unlock.
+func (box *Box) Lock() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if box.locked {
+		return nil
+	}
+	if box.masterPassword == "" {
+		return errBoxNotInitialized
+	}
+	return box.lock()
+}
+
+func (box *Box) lock() error {
+	if err := box.seal(); err != nil {
+		return err
+	}
+	if box.keys != nil {
+		box.keys.wipe()
+		box.keys = nil
+	}
+	Secret(box.response).Wipe()
+	box.response, box.responseChallenge = nil, nil
+	box.masterPassword = ""
+	box.locked = true
+	if box.lockTimer != nil {
+		box.lockTimer.Stop()
+	}
+	return nil
+}
+
+// Unlock unlocks a locked box by masterPassword. Ciphers kept in memory are
+// used if repo wasn't changed since box was locked, box is reloaded from
+// repo otherwise. Box stays locked if it fails. Attempts after failures are
+// delayed as UnlockPolicy of box tells, *UnlockDelayError returned then.
+func (box *Box) Unlock(masterPassword string) error {
+	if masterPassword == "" {
+		return errEmptyMasterPassword
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if !box.locked {
+		return nil
+	}
+	return box.attempt(func() error {
+		return box.unlock(masterPassword)
+	})
+}
+
+func (box *Box) unlock(masterPassword string) error {
+	data, err := box.repo.Load()
+	if err != nil {
+		return err
+	}
+	box.masterPassword = masterPassword
+	if box.digestOf(data) != box.digest || len(box.slots) == 0 {
+		err = box.replace(data)
+	} else {
+		err = box.unlockSlots()
+	}
+	if err != nil {
+		box.masterPassword = ""
+		return err
+	}
+	box.locked = false
+	box.touch()
+	box.startAutoLock()
+	return nil
+}
+
+// unlockSlots derives keys of box from data key in key slots
+func (box *Box) unlockSlots() error {
+	dataKey, err := box.unlockKey(box.kdf, box.slots)
+	if err != nil {
+		return err
+	}
+	keys, err := newBoxKeys(dataKey, formatVersion, box.keySize)
+	if err != nil {
+		return err
+	}
+	box.kdf = box.slots[box.slot].KDF
+	box.keys = keys
+	return nil
+}
+
+// Locked reports whether box is locked
+func (box *Box) Locked() bool {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	return box.locked
+}
+
+// SetAutoLock locks box after it's idle for d, i.e. no operation called on
+// it. Auto-lock is disabled if d isn't positive.
+func (box *Box) SetAutoLock(d time.Duration) {
+	box.setAutoLock(d, false)
+}
+
+// EnableAutoLock discards master password, keys and passwords of box from
+// memory after it's idle for d. Unlike SetAutoLock box isn't locked, it
+// returns errEmptyMasterPassword until initialized by Init again. Auto-lock
+// is disabled if d isn't positive.
+func (box *Box) EnableAutoLock(d time.Duration) {
+	box.setAutoLock(d, true)
+}
+
+func (box *Box) setAutoLock(d time.Duration, discard bool) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if box.lockTimer != nil {
+		box.lockTimer.Stop()
+		box.lockTimer = nil
+	}
+	box.autoLock = d
+	box.autoDiscard = discard
+	box.touch()
+	if !box.locked {
+		box.startAutoLock()
+	}
+}
+
+// discard wipes master password, keys and passwords of box, box is like a
+// new one which should be initialized by Init then
+func (box *Box) discard() {
+	if box.keys != nil {
+		box.keys.wipe()
+		box.keys = nil
+	}
+	Secret(box.response).Wipe()
+	box.response, box.responseChallenge = nil, nil
+	for _, pw := range box.allPasswords() {
+		pw.wipe()
+	}
+	box.passwords = map[string]*Password{}
+	box.trash = map[string]*Password{}
+	box.masterPassword = ""
+}
+
+// touch records time of the last operation, it may be called with read lock held
+func (box *Box) touch() {
+	atomic.StoreInt64(&box.lastUsed, time.Now().UnixNano())
+}
+
+func (box *Box) startAutoLock() {
+	if box.autoLock <= 0 {
+		return
+	}
+	if box.lockTimer != nil {
+		box.lockTimer.Reset(box.autoLock)
+		return
+	}
+	// timer is read by autoLockExpired with lock of box held, after it's set
+	var timer *time.Timer
+	timer = time.AfterFunc(box.autoLock, func() {
+		box.autoLockExpired(&timer)
+	})
+	box.lockTimer = timer
+}
+
+// autoLockExpired locks box if it has been idle long enough, timer is
+// restarted for the rest of idle time otherwise
+func (box *Box) autoLockExpired(timerp **time.Timer) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	timer := *timerp
+	if timer != box.lockTimer || box.locked {
+		return
+	}
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&box.lastUsed)))
+	if idle < box.autoLock {
+		timer.Reset(box.autoLock - idle)
+		return
+	}
+	if box.masterPassword == "" {
+		// not initialized yet
+		timer.Reset(box.autoLock)
+		return
+	}
+	if box.autoDiscard {
+		// timer keeps running for box initialized again
+		box.discard()
+		timer.Reset(box.autoLock)
+		return
+	}
+	box.lock()
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDiscardedBoxIsNotSaved(t *testing.T) {
+	box := newTestBox(t)
+	if _, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com")); err != nil {
+		t.Fatal(err)
+	}
+	repo := box.repo.(*MemoryRepository)
+	saved := repo.Bytes()
+
+	box.EnableAutoLock(50 * time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		box.mu.RLock()
+		discarded := box.masterPassword == ""
+		box.mu.RUnlock()
+		if discarded {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := box.Save(); err != errEmptyMasterPassword {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := box.Load(); err != errEmptyMasterPassword {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, err := box.Clear(); err != errEmptyMasterPassword {
+		t.Fatalf("Clear: %v", err)
+	}
+	if !bytes.Equal(repo.Bytes(), saved) {
+		t.Fatal("discarded box overwrote repository")
+	}
+
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if n := box.Count(); n != 1 {
+		t.Fatalf("%d passwords after initialized again", n)
+	}
+}
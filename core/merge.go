@@ -0,0 +1,113 @@
+package core
+
+// MergeStrategy decides which password is kept by Box.Merge if both boxes
+// have the password and they differ
+type MergeStrategy int
+
+const (
+	// MergeNewest keeps the password updated last, passwords updated at
+	// the same time are reported as conflicts and ours are kept
+	MergeNewest MergeStrategy = iota
+	// MergeOurs always keeps passwords of box
+	MergeOurs
+	// MergeTheirs always keeps passwords of the other box
+	MergeTheirs
+	// MergeManual keeps passwords of box and reports all of them as conflicts
+	MergeManual
+)
+
+// Conflict represents a password which differs in two boxes merged,
+// Ours and Theirs are copies without ciphers
+type Conflict struct {
+	ID     string
+	Ours   Password
+	Theirs Password
+}
+
+// Merge merges passwords of other into box by ID and saves box. Passwords
+// only in one box are kept, passwords in both boxes are resolved by
+// strategy, and the unresolved ones are returned as conflicts.
+func (box *Box) Merge(other *Box, strategy MergeStrategy) ([]Conflict, error) {
+	if other == box {
+		return nil, nil
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return nil, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := other.checkUnlocked(errBoxNotInitialized); err != nil {
+		return nil, err
+	}
+
+	var conflicts []Conflict
+	err = box.withUnsealed(func() error {
+		return other.withUnsealed(func() error {
+			var err error
+			conflicts, err = box.mergePasswords(other.passwords, strategy)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// mergePasswords merges decrypted passwords into box and saves box if any
+// password changed, box must be locked and unsealed
+func (box *Box) mergePasswords(passwords map[string]*Password, strategy MergeStrategy) ([]Conflict, error) {
+	var conflicts []Conflict
+	changed := false
+	for id, theirs := range passwords {
+		ours, ok := box.passwords[id]
+		if !ok {
+			pw := &Password{}
+			pw.ID = id
+			pw.mergeFrom(theirs, box.historyLimit())
+			pw.dirty = true
+			box.passwords[id] = pw
+			changed = true
+			continue
+		}
+		if ours.sameContent(theirs) {
+			continue
+		}
+		takeTheirs := false
+		switch strategy {
+		case MergeNewest:
+			if ours.LastUpdatedAt == theirs.LastUpdatedAt {
+				conflicts = append(conflicts, newConflict(ours, theirs))
+			}
+			takeTheirs = theirs.LastUpdatedAt > ours.LastUpdatedAt
+		case MergeTheirs:
+			takeTheirs = true
+		case MergeManual:
+			conflicts = append(conflicts, newConflict(ours, theirs))
+		}
+		if takeTheirs {
+			ours.mergeFrom(theirs, box.historyLimit())
+			ours.dirty = true
+			changed = true
+		}
+	}
+	if !changed {
+		return conflicts, nil
+	}
+	return conflicts, box.save()
+}
+
+func newConflict(ours, theirs *Password) Conflict {
+	c := Conflict{ID: ours.ID}
+	c.Ours.ID, c.Theirs.ID = ours.ID, theirs.ID
+	c.Ours.mergeFrom(ours, len(ours.History))
+	c.Theirs.mergeFrom(theirs, len(theirs.History))
+	return c
+}
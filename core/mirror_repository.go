@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MirrorError is returned by MirrorRepository.Save if saving to some of
+// mirrors failed
+type MirrorError struct {
+	// Errs are errors of mirrors failed keyed by index of mirror
+	Errs map[int]error
+}
+
+// Error implements error interface
+func (err *MirrorError) Error() string {
+	indexes := make([]int, 0, len(err.Errs))
+	for i := range err.Errs {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	msgs := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		msgs = append(msgs, fmt.Sprintf("mirror %d: %v", i, err.Errs[i]))
+	}
+	return fmt.Sprintf("saving to %d mirror(s) failed: %s", len(err.Errs), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns errors of mirrors failed
+func (err *MirrorError) Unwrap() []error {
+	errs := make([]error, 0, len(err.Errs))
+	for _, e := range err.Errs {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// MirrorStatus is status of a mirror of MirrorRepository
+type MirrorStatus struct {
+	Index int
+
+	// Lagging is true if the last Save to mirror failed, so it doesn't have
+	// box saved to primary
+	Lagging bool
+
+	// LastSaved is time of the last successful Save to mirror, zero if never
+	LastSaved time.Time
+
+	// Err is error of the last failed Save to mirror, nil if not lagging
+	Err error
+}
+
+// MirrorRepository implements BoxRepository interface, box is loaded from
+// primary and saved to primary and all mirrors, e.g. a local file mirrored
+// to S3 or WebDAV. Mirrors are saved only if primary is saved.
+type MirrorRepository struct {
+	primary BoxRepository
+	mirrors []BoxRepository
+
+	// Strict makes Save fail if saving to any mirror fails, Save succeeds
+	// as long as primary is saved otherwise. Mirrors failed are reported
+	// by Status either way.
+	Strict bool
+
+	mu     sync.Mutex
+	status []MirrorStatus
+}
+
+// NewMirrorRepository creates a MirrorRepository
+func NewMirrorRepository(primary BoxRepository, mirrors ...BoxRepository) *MirrorRepository {
+	status := make([]MirrorStatus, len(mirrors))
+	for i := range status {
+		status[i].Index = i
+	}
+	return &MirrorRepository{
+		primary: primary,
+		mirrors: append([]BoxRepository{}, mirrors...),
+		status:  status,
+	}
+}
+
+// Load implements BoxRepository.Load method, box is loaded from primary
+func (repo *MirrorRepository) Load() ([]byte, error) {
+	return repo.primary.Load()
+}
+
+// Save implements BoxRepository.Save method. Box is saved to primary, then
+// to mirrors concurrently. A *MirrorError is returned if saving to some of
+// mirrors failed and Strict is set.
+func (repo *MirrorRepository) Save(data []byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if err := repo.primary.Save(data); err != nil {
+		return err
+	}
+	errs := make([]error, len(repo.mirrors))
+	var wg sync.WaitGroup
+	for i, mirror := range repo.mirrors {
+		wg.Add(1)
+		go func(i int, mirror BoxRepository) {
+			defer wg.Done()
+			errs[i] = mirror.Save(data)
+		}(i, mirror)
+	}
+	wg.Wait()
+	now := time.Now()
+	failed := map[int]error{}
+	for i, err := range errs {
+		status := &repo.status[i]
+		status.Lagging, status.Err = err != nil, err
+		if err != nil {
+			failed[i] = err
+		} else {
+			status.LastSaved = now
+		}
+	}
+	if len(failed) > 0 && repo.Strict {
+		return &MirrorError{Errs: failed}
+	}
+	return nil
+}
+
+// Status returns status of mirrors in order of them passed to NewMirrorRepository
+func (repo *MirrorRepository) Status() []MirrorStatus {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return append([]MirrorStatus{}, repo.status...)
+}
+
+// Lagging returns indexes of mirrors whose last Save failed
+func (repo *MirrorRepository) Lagging() []int {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	lagging := []int{}
+	for _, status := range repo.status {
+		if status.Lagging {
+			lagging = append(lagging, status.Index)
+		}
+	}
+	return lagging
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"crypto/cipher"
 	"strings"
 	"time"
@@ -8,23 +9,63 @@ import (
 
 const shortIDLength = 7
 
+// legacy unauthenticated AES-CFB scheme of password fields, an empty scheme also means CFB
+const schemeCFB = "aes-cfb"
+
+// Ciphers for encrypting password fields
+const (
+	// CipherAESGCM is authenticated AES-GCM
+	CipherAESGCM = "aes-gcm"
+	// CipherChaCha20Poly1305 is authenticated ChaCha20-Poly1305, faster than AES without hardware acceleration
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+func checkCipher(cipherName string) error {
+	if cipherName != CipherAESGCM && cipherName != CipherChaCha20Poly1305 {
+		return newErrUnsupportedCipher(cipherName)
+	}
+	return nil
+}
+
 // PasswordBasic is basic of Password
 type PasswordBasic struct {
 	// Category of password
 	Category string `cli:"c,category" usage:"category of password"`
 
 	// Plain account and password
-	PlainAccount  string `json:"-" cli:"u,account" usage:"account of password"`
-	PlainPassword string `json:"-" cli:"-"`
+	PlainAccount  Secret `json:"-" cli:"u,account" usage:"account of password"`
+	PlainPassword Secret `json:"-" cli:"-"`
+
+	// Plain notes, e.g. answers of security questions or recovery codes
+	PlainNotes Secret `json:"-" cli:"notes" usage:"notes of password, stored encrypted"`
+
+	// Plain base32 secret of TOTP for generating 2FA codes
+	PlainTOTPSecret Secret `json:"-" cli:"totp" usage:"base32 TOTP secret of password, stored encrypted"`
+
+	// Plain custom fields, e.g. PINs and API keys, managed by Box.SetField
+	CustomFields map[string]string `json:"-" cli:"-"`
 
 	// Website address for web password
 	Site string `cli:"site" usage:"website of password"`
 
+	// URL of login page, missing in boxes before URL supported
+	URL string `json:",omitempty" cli:"url" usage:"url of password"`
+
 	// Password tags
 	Tags []string `cli:"tag" usage:"tags of password"`
 
 	// Extension information: JSON base64 string
 	Ext string `cli:"-"`
+
+	// Expiry time stamp of password, 0 if it never expires
+	ExpiresAt int64 `json:",omitempty" cli:"-"`
+
+	// Derived passwords are not stored, they are derived from master key
+	// of box, site, account and counter whenever decrypted
+	Derived       bool   `json:",omitempty" cli:"derived" usage:"derive password from master key, site and account instead of storing it"`
+	DeriveLength  int    `json:",omitempty" cli:"-"`
+	DeriveCharset string `json:",omitempty" cli:"-"`
+	DeriveCounter int    `json:",omitempty" cli:"-"`
 }
 
 // Password represents entity of password
@@ -42,14 +83,74 @@ type Password struct {
 	CipherAccount  []byte `cli:"-"`
 	CipherPassword []byte `cli:"-"`
 
+	// Encrypted notes, missing if password has no notes
+	NotesIV     []byte `json:",omitempty" cli:"-"`
+	CipherNotes []byte `json:",omitempty" cli:"-"`
+
+	// Encrypted TOTP secret, missing if password has no TOTP secret
+	TOTPIV           []byte `json:",omitempty" cli:"-"`
+	CipherTOTPSecret []byte `json:",omitempty" cli:"-"`
+
+	// Encrypted custom fields as a whole, missing if password has no custom field
+	CustomFieldsIV     []byte `json:",omitempty" cli:"-"`
+	CipherCustomFields []byte `json:",omitempty" cli:"-"`
+
+	// Previous passwords, the oldest first
+	History []HistoryEntry `json:",omitempty" cli:"-"`
+
+	// Encryption scheme of ciphers, empty for legacy AES-CFB
+	Scheme string `json:",omitempty" cli:"-"`
+
+	// Encrypted metadata (category, site, URL, tags and ext) if box encrypts metadata
+	MetadataIV     []byte `json:",omitempty" cli:"-"`
+	CipherMetadata []byte `json:",omitempty" cli:"-"`
+
 	// Created time stamp
 	CreatedAt int64 `cli:"-"`
 
 	// Last updated time stamp
 	LastUpdatedAt int64 `cli:"-"`
+
+	// Removed time stamp of password in trash, 0 otherwise
+	DeletedAt int64 `json:",omitempty" cli:"-"`
+
+	// sealed reports whether plaintext was wiped, only ciphers are valid
+	sealed bool
+
+	// dirty reports whether plaintext changed since ciphers were written,
+	// only dirty passwords are encrypted again when box is saved
+	dirty bool
 }
 
-var passwordHeader = []string{"ID", "CATEGORY", "ACCOUNT", "PASSWORD", "UPDATED_AT"}
+// Secret holds plaintext bytes which can be wiped from memory
+type Secret []byte
+
+// String returns plaintext as string, the returned copy can't be wiped
+func (s Secret) String() string {
+	return string(s)
+}
+
+// Decode implements cli.Decoder interface
+func (s *Secret) Decode(str string) error {
+	*s = Secret(str)
+	return nil
+}
+
+// Wipe overwrites plaintext with zeros
+func (s Secret) Wipe() {
+	for i := range s {
+		s[i] = 0
+	}
+}
+
+func (s Secret) clone() Secret {
+	if s == nil {
+		return nil
+	}
+	return append(Secret{}, s...)
+}
+
+var passwordHeader = []string{"ID", "CATEGORY", "ACCOUNT", "PASSWORD", "URL", "TAGS", "UPDATED_AT"}
 
 func (pw Password) get(i int) string {
 	switch i {
@@ -58,38 +159,60 @@ func (pw Password) get(i int) string {
 	case 1:
 		return pw.Category
 	case 2:
-		return pw.PlainAccount
+		return pw.PlainAccount.String()
 	case 3:
-		return pw.PlainPassword
+		return pw.PlainPassword.String()
 	case 4:
+		return pw.URL
+	case 5:
+		return strings.Join(pw.Tags, ",")
+	case 6:
 		return time.Unix(pw.LastUpdatedAt, 0).Format(time.RFC3339)
 	}
 	panic("unreachable")
 }
 
 func (pw Password) colCount() int {
-	return 5
+	return 7
 }
 
 func (pw Password) match(word string) bool {
-	if strings.Contains(pw.ID, word) {
+	return pw.matchWith(word, MatchOptions{})
+}
+
+// MatchOptions tells how FindWith matches passwords by word
+type MatchOptions struct {
+	// IgnoreCase matches word case-insensitively, e.g. "git" matches "GitHub"
+	IgnoreCase bool
+}
+
+func (pw Password) matchWith(word string, opts MatchOptions) bool {
+	// account and password are secrets, compared in constant time
+	contains, containsBytes := strings.Contains, secretContains
+	if opts.IgnoreCase {
+		contains, containsBytes = containsFold, secretContainsFold
+	}
+	if contains(pw.ID, word) {
+		return true
+	}
+	if contains(pw.Category, word) {
 		return true
 	}
-	if strings.Contains(pw.Category, word) {
+	if containsBytes(pw.PlainAccount, []byte(word)) {
 		return true
 	}
-	if strings.Contains(pw.PlainAccount, word) {
+	if containsBytes(pw.PlainPassword, []byte(word)) {
 		return true
 	}
-	if strings.Contains(pw.PlainPassword, word) {
+	if contains(pw.Site, word) {
 		return true
 	}
-	if strings.Contains(pw.Site, word) {
+	if contains(pw.URL, word) {
 		return true
 	}
 	if pw.Tags != nil {
 		for _, tag := range pw.Tags {
-			if strings.Contains(tag, word) {
+			if contains(tag, word) {
 				return true
 			}
 		}
@@ -97,6 +220,26 @@ func (pw Password) match(word string) bool {
 	return false
 }
 
+// containsFold reports whether substr is within s ignoring case by foldCase
+func containsFold(s, substr string) bool {
+	return bytes.Contains(foldCase([]byte(s)), foldCase([]byte(substr)))
+}
+
+// hasTag reports whether pw is tagged by tag
+func (pw Password) hasTag(tag string) bool {
+	for _, t := range pw.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// expiresBefore reports whether pw expires before t
+func (pw Password) expiresBefore(t time.Time) bool {
+	return pw.ExpiresAt != 0 && pw.ExpiresAt <= t.Unix()
+}
+
 // NewEmptyPassword creates a empty Password entity
 func NewEmptyPassword() *Password {
 	return NewPassword("", "", "", "")
@@ -108,8 +251,8 @@ func NewPassword(category, account, passwd, site string) *Password {
 	pw := &Password{
 		PasswordBasic: PasswordBasic{
 			Category:      category,
-			PlainAccount:  account,
-			PlainPassword: passwd,
+			PlainAccount:  Secret(account),
+			PlainPassword: Secret(passwd),
 			Site:          site,
 			Tags:          []string{},
 		},
@@ -131,10 +274,179 @@ func (pw *Password) ShortID() string {
 	return pw.ID
 }
 
-func (pw *Password) migrate(from *Password) {
+// sameContent reports whether pw and other have the same plaintext content
+func (pw *Password) sameContent(other *Password) bool {
+	if pw.Category != other.Category || pw.Site != other.Site || pw.URL != other.URL || pw.Ext != other.Ext || pw.ExpiresAt != other.ExpiresAt {
+		return false
+	}
+	if pw.Derived != other.Derived || pw.DeriveLength != other.DeriveLength || pw.DeriveCharset != other.DeriveCharset || pw.DeriveCounter != other.DeriveCounter {
+		return false
+	}
+	if !secretEqual(pw.PlainAccount, other.PlainAccount) || !secretEqual(pw.PlainPassword, other.PlainPassword) {
+		return false
+	}
+	if !secretEqual(pw.PlainNotes, other.PlainNotes) || !secretEqual(pw.PlainTOTPSecret, other.PlainTOTPSecret) {
+		return false
+	}
+	if len(pw.CustomFields) != len(other.CustomFields) {
+		return false
+	}
+	for k, v := range pw.CustomFields {
+		if ov, ok := other.CustomFields[k]; !ok || ov != v {
+			return false
+		}
+	}
+	if len(pw.Tags) != len(other.Tags) {
+		return false
+	}
+	for i := range pw.Tags {
+		if pw.Tags[i] != other.Tags[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeFrom copies content and time stamps of from, ciphers are left to
+// be encrypted by keys of box. A new pw takes history of from.
+func (pw *Password) mergeFrom(from *Password, historyLimit int) {
+	if pw.History == nil && len(pw.PlainPassword) == 0 {
+		pw.History = from.cloneHistory()
+	}
+	pw.migrate(from, historyLimit)
+	pw.CreatedAt = from.CreatedAt
+	pw.LastUpdatedAt = from.LastUpdatedAt
+}
+
+// migrate replaces content of pw by from, the replaced password is pushed
+// onto history of pw which keeps at most historyLimit entries
+func (pw *Password) migrate(from *Password, historyLimit int) {
+	if !pw.Derived {
+		// derived passwords are reproducible by counter, not kept
+		pw.pushHistory(from.PlainPassword, historyLimit)
+	}
+	pw.wipeFields()
 	pw.PasswordBasic = from.PasswordBasic
+	pw.PasswordBasic.PlainAccount = from.PlainAccount.clone()
+	pw.PasswordBasic.PlainPassword = from.PlainPassword.clone()
+	pw.PasswordBasic.PlainNotes = from.PlainNotes.clone()
+	pw.PasswordBasic.PlainTOTPSecret = from.PlainTOTPSecret.clone()
+	pw.PasswordBasic.CustomFields = nil
+	if len(from.CustomFields) > 0 {
+		pw.PasswordBasic.CustomFields = make(map[string]string, len(from.CustomFields))
+		for k, v := range from.CustomFields {
+			pw.PasswordBasic.CustomFields[k] = v
+		}
+	}
 	pw.PasswordBasic.Tags = make([]string, len(from.PasswordBasic.Tags))
 	copy(pw.PasswordBasic.Tags, from.PasswordBasic.Tags)
+	pw.sealed = false
+}
+
+// wipe zeroes plaintext of pw and its history, and marks it sealed
+func (pw *Password) wipe() {
+	pw.wipeFields()
+	for i := range pw.History {
+		pw.History[i].PlainPassword.Wipe()
+		pw.History[i].PlainPassword = nil
+	}
+	pw.sealed = true
+}
+
+// wipeFields zeroes plaintext of account, password, notes and TOTP secret
+// of pw, and drops its custom fields
+func (pw *Password) wipeFields() {
+	pw.PlainAccount.Wipe()
+	pw.PlainPassword.Wipe()
+	pw.PlainNotes.Wipe()
+	pw.PlainTOTPSecret.Wipe()
+	pw.PlainAccount = nil
+	pw.PlainPassword = nil
+	pw.PlainNotes = nil
+	pw.PlainTOTPSecret = nil
+	pw.CustomFields = nil
+}
+
+// passwordMetadata is metadata of password encrypted as a whole
+type passwordMetadata struct {
+	Category string
+	Site     string
+	URL      string `json:",omitempty"`
+	Tags     []string
+	Ext      string
+
+	// Timestamps of password, only if box encrypts time stamps
+	Timestamps *passwordTimestamps `json:",omitempty"`
+}
+
+// passwordTimestamps are time stamps of password encrypted with metadata
+type passwordTimestamps struct {
+	CreatedAt     int64
+	LastUpdatedAt int64
+	ExpiresAt     int64   `json:",omitempty"`
+	ReplacedAt    []int64 `json:",omitempty"`
+}
+
+func (pw *Password) metadata() passwordMetadata {
+	return passwordMetadata{
+		Category: pw.Category,
+		Site:     pw.Site,
+		URL:      pw.URL,
+		Tags:     pw.Tags,
+		Ext:      pw.Ext,
+	}
+}
+
+func (pw *Password) setMetadata(md passwordMetadata) {
+	pw.Category = md.Category
+	pw.Site = md.Site
+	pw.URL = md.URL
+	pw.Tags = md.Tags
+	pw.Ext = md.Ext
+	if ts := md.Timestamps; ts != nil {
+		pw.CreatedAt = ts.CreatedAt
+		pw.LastUpdatedAt = ts.LastUpdatedAt
+		pw.ExpiresAt = ts.ExpiresAt
+		for i := range pw.History {
+			if i < len(ts.ReplacedAt) {
+				pw.History[i].ReplacedAt = ts.ReplacedAt[i]
+			}
+		}
+	}
+}
+
+func (pw *Password) timestamps() *passwordTimestamps {
+	ts := &passwordTimestamps{
+		CreatedAt:     pw.CreatedAt,
+		LastUpdatedAt: pw.LastUpdatedAt,
+		ExpiresAt:     pw.ExpiresAt,
+	}
+	for _, h := range pw.History {
+		ts.ReplacedAt = append(ts.ReplacedAt, h.ReplacedAt)
+	}
+	return ts
+}
+
+// hideMetadata clears plaintext metadata of a serialized copy of password
+func (pw *Password) hideMetadata() {
+	pw.setMetadata(passwordMetadata{})
+}
+
+// hideTimestamps clears plaintext time stamps of a serialized copy of
+// password, history of the copy is shared with password so it's copied
+func (pw *Password) hideTimestamps() {
+	pw.CreatedAt, pw.LastUpdatedAt, pw.ExpiresAt = 0, 0, 0
+	if len(pw.History) > 0 {
+		pw.History = append([]HistoryEntry{}, pw.History...)
+	}
+	for i := range pw.History {
+		pw.History[i].ReplacedAt = 0
+	}
+}
+
+// additionalData binds cipher of field to the password so ciphers can't be swapped
+func (pw *Password) additionalData(field string) []byte {
+	return []byte(pw.ID + ":" + field)
 }
 
 // CheckPassword validate password string
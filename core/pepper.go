@@ -0,0 +1,81 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// PepperEnv is environment variable of pepper read by NewBoxWithOptions
+const PepperEnv = "ONEPW_PEPPER"
+
+// pepperCheckLength is length of hash of pepper stored in box, it's short
+// so the pepper can't be confirmed by the hash alone
+const pepperCheckLength = 4
+
+func pepperChecksum(pepper []byte) []byte {
+	sum := sha256.Sum256(append([]byte(pepperLabel), pepper...))
+	return sum[:pepperCheckLength]
+}
+
+// checkPepper checks pepper of box against hash of pepper in header
+func (box *Box) checkPepper() error {
+	if len(box.pepper) == 0 {
+		return errPepperRequired
+	}
+	if !hmac.Equal(pepperChecksum(box.pepper), box.pepperCheck) {
+		return errWrongPepper
+	}
+	return nil
+}
+
+// SetPepper makes pepper required for unlocking box, or replaces the
+// current pepper, and saves box re-keyed by it. Pepper is never saved,
+// it must be passed by Options.Pepper or ONEPW_PEPPER later.
+func (box *Box) SetPepper(pepper []byte) error {
+	if len(pepper) == 0 {
+		return errEmptyPepper
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// pepper is mixed into keys of all slots
+	if box.passwordSlotCount() > 1 {
+		return errPepperWithKeySlots
+	}
+	return box.rekeyAndSave(box.kdf, func() {
+		box.pepper = append([]byte{}, pepper...)
+		box.pepperRequired = true
+		box.pepperCheck = pepperChecksum(pepper)
+	})
+}
+
+// RemovePepper removes pepper requirement of box and saves box
+func (box *Box) RemovePepper() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !box.pepperRequired {
+		return nil
+	}
+	if box.passwordSlotCount() > 1 {
+		return errPepperWithKeySlots
+	}
+	return box.rekeyAndSave(box.kdf, func() {
+		box.pepperRequired = false
+		box.pepperCheck = nil
+	})
+}
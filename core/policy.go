@@ -0,0 +1,163 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are well known passwords rejected by MasterPasswordPolicy
+var commonPasswords = map[string]bool{
+	"123456": true, "1234567": true, "12345678": true, "123456789": true,
+	"1234567890": true, "111111": true, "123123": true, "654321": true,
+	"666666": true, "888888": true, "000000": true, "121212": true,
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"qwerty": true, "qwerty123": true, "qwertyuiop": true, "asdfghjkl": true,
+	"1q2w3e4r": true, "1qaz2wsx": true, "zxcvbnm": true, "abc123": true,
+	"iloveyou": true, "letmein": true, "welcome": true, "monkey": true,
+	"dragon": true, "football": true, "baseball": true, "sunshine": true,
+	"princess": true, "master": true, "admin": true, "secret": true,
+	"trustno1": true, "superman": true, "shadow": true, "michael": true,
+}
+
+// MasterPasswordPolicy represents strength requirements of master password,
+// it's checked when a box is created or master password is changed
+type MasterPasswordPolicy struct {
+	// Minimum number of characters
+	MinLength int
+	// Minimum number of character classes: lower, upper, digit and symbol
+	MinClasses int
+	// Minimum estimated entropy in bits, repeated and sequential
+	// characters don't add entropy
+	MinEntropy float64
+	// RejectCommon rejects well known passwords
+	RejectCommon bool
+	// AllowWeak accepts master passwords which don't satisfy the policy,
+	// empty and single character passwords are still rejected
+	AllowWeak bool
+}
+
+// DefaultMasterPasswordPolicy returns the policy used if no policy specified
+func DefaultMasterPasswordPolicy() MasterPasswordPolicy {
+	return MasterPasswordPolicy{
+		MinLength:    8,
+		MinClasses:   2,
+		MinEntropy:   40,
+		RejectCommon: true,
+	}
+}
+
+// WeakMasterPasswordError is returned if master password is rejected by
+// MasterPasswordPolicy, Reasons tells why
+type WeakMasterPasswordError struct {
+	Reasons []string
+}
+
+// Error implements error interface
+func (err *WeakMasterPasswordError) Error() string {
+	return "master password is too weak: " + strings.Join(err.Reasons, "; ")
+}
+
+// Check checks strength of masterPassword, a *WeakMasterPasswordError is
+// returned if it's rejected
+func (policy MasterPasswordPolicy) Check(masterPassword string) error {
+	if masterPassword == "" {
+		return errEmptyMasterPassword
+	}
+	if distinctChars(masterPassword) < 2 {
+		return &WeakMasterPasswordError{Reasons: []string{"consists of a single character"}}
+	}
+	if policy.AllowWeak {
+		return nil
+	}
+	reasons := []string{}
+	if n := len([]rune(masterPassword)); n < policy.MinLength {
+		reasons = append(reasons, fmt.Sprintf("%d characters, at least %d required", n, policy.MinLength))
+	}
+	if n := charClasses(masterPassword); n < policy.MinClasses {
+		reasons = append(reasons, fmt.Sprintf("%d kinds of characters, at least %d of lower, upper, digit and symbol required", n, policy.MinClasses))
+	}
+	if policy.RejectCommon && commonPasswords[strings.ToLower(masterPassword)] {
+		reasons = append(reasons, "a well known password")
+	}
+	if bits := passwordEntropy(masterPassword); bits < policy.MinEntropy {
+		reasons = append(reasons, fmt.Sprintf("estimated entropy %.0f bits, at least %.0f bits required, avoid repeated and sequential characters", bits, policy.MinEntropy))
+	}
+	if len(reasons) > 0 {
+		return &WeakMasterPasswordError{Reasons: reasons}
+	}
+	return nil
+}
+
+func distinctChars(s string) int {
+	chars := map[rune]bool{}
+	for _, c := range s {
+		chars[c] = true
+	}
+	return len(chars)
+}
+
+// character classes of master password
+const (
+	classLower = 1 << iota
+	classUpper
+	classDigit
+	classSymbol
+)
+
+func charClass(c rune) int {
+	switch {
+	case unicode.IsLower(c):
+		return classLower
+	case unicode.IsUpper(c):
+		return classUpper
+	case unicode.IsDigit(c):
+		return classDigit
+	}
+	return classSymbol
+}
+
+func charClasses(s string) int {
+	classes := 0
+	for _, c := range s {
+		classes |= charClass(c)
+	}
+	n := 0
+	for ; classes != 0; classes &= classes - 1 {
+		n++
+	}
+	return n
+}
+
+// passwordEntropy estimates entropy of s in bits as if it's chosen randomly
+// from alphabets of its character classes. Characters repeating or
+// following the previous one (e.g. "aaa", "abc", "321") are not counted.
+func passwordEntropy(s string) float64 {
+	classes := 0
+	effective := 0
+	var prev rune
+	for i, c := range []rune(s) {
+		classes |= charClass(c)
+		if i > 0 && (c == prev || c == prev+1 || c == prev-1) {
+			prev = c
+			continue
+		}
+		effective++
+		prev = c
+	}
+	alphabet := 0
+	if classes&classLower != 0 {
+		alphabet += 26
+	}
+	if classes&classUpper != 0 {
+		alphabet += 26
+	}
+	if classes&classDigit != 0 {
+		alphabet += 10
+	}
+	if classes&classSymbol != 0 {
+		alphabet += 33
+	}
+	return float64(effective) * math.Log2(float64(alphabet))
+}
@@ -0,0 +1,60 @@
+package core
+
+import "time"
+
+// Filter selects passwords returned by Query, zero fields match all passwords
+type Filter struct {
+	// Category of passwords
+	Category string
+	// Tag passwords are tagged by
+	Tag string
+	// Account is a substring of account of passwords
+	Account string
+	// UpdatedSince selects passwords updated at or after it
+	UpdatedSince time.Time
+}
+
+// matchMetadata reports whether plaintext metadata of pw matches filter
+func (filter Filter) matchMetadata(pw *Password) bool {
+	if filter.Category != "" && pw.Category != filter.Category {
+		return false
+	}
+	if filter.Tag != "" && !pw.hasTag(filter.Tag) {
+		return false
+	}
+	if !filter.UpdatedSince.IsZero() && pw.LastUpdatedAt < filter.UpdatedSince.Unix() {
+		return false
+	}
+	return true
+}
+
+// Query returns decrypted copies of passwords matched by filter sorted by
+// ID. Secrets of returned passwords should be wiped after use.
+func (box *Box) Query(filter Filter) ([]Password, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	passwords := []Password{}
+	sorted := box.sortedPasswords()
+	for i := range sorted {
+		if !filter.matchMetadata(&sorted[i]) {
+			continue
+		}
+		// only passwords matched by metadata are decrypted
+		pw, err := box.decryptedCopy(box.passwords[sorted[i].ID])
+		if err != nil {
+			for j := range passwords {
+				passwords[j].wipe()
+			}
+			return nil, err
+		}
+		if filter.Account != "" && !secretContains(pw.PlainAccount, []byte(filter.Account)) {
+			pw.wipe()
+			continue
+		}
+		passwords = append(passwords, *pw)
+	}
+	return passwords, nil
+}
@@ -0,0 +1,53 @@
+package core
+
+// readOnlyRepository wraps a BoxRepository whose box is never saved
+type readOnlyRepository struct {
+	inner BoxRepository
+}
+
+// ReadOnly wraps repo so box is loaded from it but never saved, Save
+// returns errReadOnly. Box of a read-only repository is read-only as
+// SetReadOnly(true) makes it.
+func ReadOnly(repo BoxRepository) BoxRepository {
+	return readOnlyRepository{inner: repo}
+}
+
+// Load implements BoxRepository.Load method
+func (repo readOnlyRepository) Load() ([]byte, error) {
+	return repo.inner.Load()
+}
+
+// Save implements BoxRepository.Save method, it always fails
+func (repo readOnlyRepository) Save([]byte) error {
+	return errReadOnly
+}
+
+// SetReadOnly makes box read-only or not. Operations modifying a read-only
+// box, e.g. Add, Remove and Clear, fail with errReadOnly before touching
+// it, Init loads box without saving it.
+func (box *Box) SetReadOnly(readOnly bool) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	box.readOnly = readOnly
+}
+
+// IsReadOnly reports whether box is read-only, by SetReadOnly or ReadOnly
+// repository
+func (box *Box) IsReadOnly() bool {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	return box.isReadOnly()
+}
+
+func (box *Box) isReadOnly() bool {
+	_, ok := box.repo.(readOnlyRepository)
+	return box.readOnly || ok
+}
+
+// checkWritable returns errReadOnly if box is read-only
+func (box *Box) checkWritable() error {
+	if box.isReadOnly() {
+		return errReadOnly
+	}
+	return nil
+}
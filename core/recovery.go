@@ -0,0 +1,269 @@
+package core
+
+import (
+	"crypto/sha256"
+	"sort"
+	"strings"
+)
+
+const (
+	// recoveryKeyLength is length of recovery key in bytes
+	recoveryKeyLength = 32
+
+	// recoveryWordCount is number of words of recovery phrase, which
+	// encodes recovery key and a checksum of 8 bits as BIP39 does
+	recoveryWordCount = 24
+
+	// recoveryWordBits is number of bits encoded by each word
+	recoveryWordBits = 11
+
+	// maxRecoverySuggestionDistance is max edit distance of a mistyped
+	// word to the word suggested
+	maxRecoverySuggestionDistance = 2
+)
+
+func (slot keySlot) isRecovery() bool {
+	return slot.KDF.Type == kdfRecovery
+}
+
+// passwordSlotCount returns number of key slots unlocked by master passwords
+func (box *Box) passwordSlotCount() int {
+	n := 0
+	for _, slot := range box.slots {
+		if !slot.isRecovery() {
+			n++
+		}
+	}
+	return n
+}
+
+// newRecoverySlot creates a key slot wrapping dataKey by recoveryKey
+func newRecoverySlot(recoveryKey, dataKey []byte) (keySlot, error) {
+	key, err := hkdfKey(recoveryKey, recoveryKeyLabel)
+	if err != nil {
+		return keySlot{}, err
+	}
+	slot := keySlot{KDF: KDFConfig{Type: kdfRecovery}}
+	if slot.IV, slot.Key, err = wrapKey(key, dataKey); err != nil {
+		return keySlot{}, err
+	}
+	return slot, nil
+}
+
+// openRecoverySlot returns data key wrapped in recovery slot by recovery
+// key of box. Slot of box is set to the first slot of master passwords.
+func (box *Box) openRecoverySlot(slots []keySlot) ([]byte, error) {
+	for _, slot := range slots {
+		if !slot.isRecovery() {
+			continue
+		}
+		key, err := hkdfKey(box.recoveryKey, recoveryKeyLabel)
+		if err != nil {
+			return nil, err
+		}
+		dataKey, err := unwrapKey(key, slot.IV, slot.Key)
+		if err != nil {
+			return nil, errWrongRecoveryPhrase
+		}
+		for i := range slots {
+			if !slots[i].isRecovery() {
+				box.slot = i
+				break
+			}
+		}
+		return dataKey, nil
+	}
+	return nil, errNoRecoveryPhrase
+}
+
+// GenerateRecoveryPhrase generates a random recovery key, wraps data key of
+// box by it in a key slot and saves box. The recovery key is returned as a
+// phrase of 24 words, which unlocks box by RecoverWithPhrase if master
+// password is forgotten, so it should be written down and kept offline.
+// Box has one recovery slot at most, phrase generated before stops working.
+func (box *Box) GenerateRecoveryPhrase() (string, error) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return "", err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	recoveryKey, err := randomBytes(recoveryKeyLength)
+	if err != nil {
+		return "", err
+	}
+	defer Secret(recoveryKey).Wipe()
+	slot, err := newRecoverySlot(recoveryKey, box.keys.master)
+	if err != nil {
+		return "", err
+	}
+	old := box.slots
+	slots := append([]keySlot{}, old...)
+	replaced := false
+	for i := range slots {
+		if slots[i].isRecovery() {
+			slots[i] = slot
+			replaced = true
+		}
+	}
+	if !replaced {
+		slots = append(slots, slot)
+	}
+	box.slots = slots
+	if err := box.save(); err != nil {
+		box.slots = old
+		return "", err
+	}
+	return encodeRecoveryPhrase(recoveryKey), nil
+}
+
+// RecoverWithPhrase unlocks box by recovery phrase generated by
+// GenerateRecoveryPhrase, sets newMasterPassword as master password and
+// saves box. Key slot of the forgotten master password is replaced, or a
+// new slot is added if box has more master passwords, so others keep their
+// access. Keyfile, pepper and hardware token aren't needed for unlocking,
+// but they are still mixed into key of newMasterPassword if box requires.
+// The recovery phrase remains valid.
+func (box *Box) RecoverWithPhrase(phrase, newMasterPassword string) error {
+	recoveryKey, err := decodeRecoveryPhrase(phrase)
+	if err != nil {
+		return err
+	}
+	defer Secret(recoveryKey).Wipe()
+	if err := box.masterPasswordPolicy().Check(newMasterPassword); err != nil {
+		return err
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if box.locked {
+		return errBoxLocked
+	}
+	box.touch()
+	if err := box.checkRestrictedOptions(); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	box.masterPassword = newMasterPassword
+	box.recoveryKey = recoveryKey
+	err = box.load()
+	box.recoveryKey = nil
+	if err != nil {
+		box.masterPassword = ""
+		return err
+	}
+	state := box.keyState()
+	if box.passwordSlotCount() > 1 {
+		box.slots = append(append([]keySlot{}, box.slots...), box.slots[box.slot])
+		box.slot = len(box.slots) - 1
+	}
+	err = box.rekey(box.kdf)
+	if err == nil {
+		err = box.save()
+	}
+	if err != nil {
+		box.restoreKeyState(state)
+		box.masterPassword = ""
+	}
+	return err
+}
+
+// encodeRecoveryPhrase encodes recoveryKey followed by first byte of its
+// SHA-256 as words, each word encodes 11 bits
+func encodeRecoveryPhrase(recoveryKey []byte) string {
+	sum := sha256.Sum256(recoveryKey)
+	data := append(append(make([]byte, 0, len(recoveryKey)+1), recoveryKey...), sum[0])
+	defer Secret(data).Wipe()
+	words := make([]string, recoveryWordCount)
+	for i := range words {
+		index := 0
+		for bit := i * recoveryWordBits; bit < (i+1)*recoveryWordBits; bit++ {
+			index = index<<1 | int(data[bit/8]>>(7-uint(bit%8))&1)
+		}
+		words[i] = recoveryWords[index]
+	}
+	return strings.Join(words, " ")
+}
+
+// decodeRecoveryPhrase decodes recovery key from phrase and validates its
+// checksum. Words are case-insensitive and may be abbreviated to their
+// first 4 letters.
+func decodeRecoveryPhrase(phrase string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words) != recoveryWordCount {
+		return nil, newErrRecoveryWordCount(len(words))
+	}
+	data := make([]byte, recoveryKeyLength+1)
+	for i, word := range words {
+		index, ok := recoveryWordIndex(word)
+		if !ok {
+			return nil, newErrUnknownRecoveryWord(i+1, word, suggestRecoveryWord(word))
+		}
+		for j := 0; j < recoveryWordBits; j++ {
+			if index>>(recoveryWordBits-1-uint(j))&1 != 0 {
+				bit := i*recoveryWordBits + j
+				data[bit/8] |= 1 << (7 - uint(bit%8))
+			}
+		}
+	}
+	recoveryKey := data[:recoveryKeyLength]
+	sum := sha256.Sum256(recoveryKey)
+	if sum[0] != data[recoveryKeyLength] {
+		Secret(data).Wipe()
+		return nil, errRecoveryChecksum
+	}
+	return recoveryKey, nil
+}
+
+// recoveryWordIndex returns index of word in recoveryWords, word may be
+// abbreviated to 4 letters at least
+func recoveryWordIndex(word string) (int, bool) {
+	i := sort.SearchStrings(recoveryWords[:], word)
+	if i < len(recoveryWords) && recoveryWords[i] == word {
+		return i, true
+	}
+	if len(word) >= 4 && i < len(recoveryWords) && strings.HasPrefix(recoveryWords[i], word) {
+		return i, true
+	}
+	return 0, false
+}
+
+// suggestRecoveryWord returns the word in recoveryWords closest to word,
+// or empty string if none is close enough
+func suggestRecoveryWord(word string) string {
+	suggestion, best := "", maxRecoverySuggestionDistance+1
+	for _, candidate := range recoveryWords {
+		if d := editDistance(word, candidate); d < best {
+			suggestion, best = candidate, d
+		}
+	}
+	return suggestion
+}
+
+// editDistance returns Levenshtein distance of a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j-1]+cost, minInt(prev[j]+1, curr[j-1]+1))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -2,11 +2,78 @@ package core
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// BackupRepository is a repository which can store numbered backups
+type BackupRepository interface {
+	BoxRepository
+	// Backup returns repository of n-th backup, n starts from 1
+	Backup(n int) BoxRepository
+}
+
+// EntryRepository is a repository storing passwords one by one, Box writes
+// a single added, updated or removed password by SaveOne or DeleteOne
+// rather than saving the whole box. Header is box serialized without
+// passwords, it holds MAC of all passwords so must be written atomically
+// with the password.
+type EntryRepository interface {
+	BoxRepository
+	// SaveOne saves header and serialized password id
+	SaveOne(header []byte, id string, password []byte) error
+	// DeleteOne saves header and deletes password id
+	DeleteOne(header []byte, id string) error
+}
+
+// LockingRepository is a repository which can be locked across processes,
+// Box holds the lock while it reloads, modifies and saves box, so changes
+// made by processes at once aren't lost
+type LockingRepository interface {
+	BoxRepository
+	// Lock locks repository, it blocks until the lock is acquired or fails
+	Lock() error
+	// Unlock releases the lock acquired by Lock
+	Unlock() error
+}
+
+// ConvertRepository copies box of from to to, e.g. from a FileRepository
+// to a BoltRepository. Box is checked to parse, nothing is decrypted.
+func ConvertRepository(from, to BoxRepository) error {
+	data, err := from.Load()
+	if err != nil {
+		return err
+	}
+	if err := checkBoxData(data); err != nil {
+		return err
+	}
+	return to.Save(data)
+}
+
 // FileRepository implements BoxRepository interface
 type FileRepository struct {
 	Filename string
+
+	// Shred overwrites the file replaced by Save and failed temp files
+	// before they're unlinked, see SecureRemove
+	Shred bool
+
+	// Backups is number of timestamped backups kept, Save copies the file
+	// to Filename.bak.<timestamp> before replacing it.
+	// DefaultFileBackups if 0, no backup if negative.
+	Backups int
+
+	// LockTimeout is how long Lock waits for lock of Filename.lock held by
+	// another process, DefaultLockTimeout if 0, not locked if negative
+	LockTimeout time.Duration
+
+	// lockMu is held from Lock to Unlock, lockFile is the locked file
+	lockMu   sync.Mutex
+	lockFile *os.File
 }
 
 // NewFileRepository creates a FileRepository
@@ -14,12 +81,254 @@ func NewFileRepository(filename string) *FileRepository {
 	return &FileRepository{Filename: filename}
 }
 
-// Load implements BoxRepository.Load method
+// Load implements BoxRepository.Load method, empty data returned if file not exist
 func (repo *FileRepository) Load() ([]byte, error) {
-	return ioutil.ReadFile(repo.Filename)
+	data, err := ioutil.ReadFile(repo.Filename)
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return data, err
 }
 
-// Save implements BoxRepository.Save method
+// Backup implements BackupRepository.Backup method, backups are stored
+// beside Filename as Filename.n
+func (repo *FileRepository) Backup(n int) BoxRepository {
+	backup := NewFileRepository(repo.backupName(n))
+	backup.Shred = repo.Shred
+	backup.Backups = -1
+	return backup
+}
+
+func (repo *FileRepository) backupName(n int) string {
+	return repo.Filename + "." + strconv.Itoa(n)
+}
+
+func (repo *FileRepository) isNumberedBackup(name string) bool {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, repo.Filename+"."))
+	return err == nil && n >= 1 && name == repo.backupName(n)
+}
+
+// PruneBackups removes numbered and timestamped backups of Filename last
+// modified before olderThan ago, they are overwritten by SecureRemove if
+// shred. Names of removed backups are returned.
+func (repo *FileRepository) PruneBackups(olderThan time.Duration, shred bool) ([]string, error) {
+	names, err := filepath.Glob(repo.Filename + ".*")
+	if err != nil {
+		return nil, err
+	}
+	remove := removeFile
+	if shred {
+		remove = SecureRemove
+	}
+	deadline := time.Now().Add(-olderThan)
+	pruned := []string{}
+	for _, name := range names {
+		if !repo.isNumberedBackup(name) && !repo.isTimestampedBackup(name) {
+			continue
+		}
+		info, err := os.Lstat(name)
+		if err != nil {
+			return pruned, err
+		}
+		if !info.ModTime().Before(deadline) {
+			continue
+		}
+		if err := remove(name); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, name)
+	}
+	return pruned, nil
+}
+
+// Operations on files by FileRepository, replaced by tests to inject
+// failures
+var (
+	writeFile   = (*os.File).Write
+	writeFileAt = (*os.File).WriteAt
+	renameFile  = os.Rename
+	removeFile  = os.Remove
+)
+
+// Save implements BoxRepository.Save method. Data is written to a temp file
+// in the same directory, synced to disk and then renamed to Filename, so a
+// crash while writing never leaves a half written box. Permissions of the
+// replaced file are kept.
 func (repo *FileRepository) Save(data []byte) error {
-	return ioutil.WriteFile(repo.Filename, data, 0666)
+	if err := repo.backupCurrent(data); err != nil {
+		return err
+	}
+	dir, name := filepath.Split(repo.Filename)
+	if dir == "" {
+		dir = "."
+	}
+	// temp file is created with mode 0600
+	file, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpname := file.Name()
+	remove := removeFile
+	if repo.Shred {
+		remove = SecureRemove
+	}
+	if err = writeSynced(file, repo.Filename, data); err != nil {
+		file.Close()
+		remove(tmpname)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		remove(tmpname)
+		return err
+	}
+	var old *os.File
+	if repo.Shred {
+		// replaced file is overwritten through its descriptor after rename
+		if old, err = openShred(repo.Filename); err != nil {
+			remove(tmpname)
+			return err
+		}
+	}
+	if err = renameFile(tmpname, repo.Filename); err != nil {
+		if old != nil {
+			old.Close()
+		}
+		remove(tmpname)
+		return err
+	}
+	if old != nil {
+		// data is saved already, shredding is best effort
+		shredFile(old)
+		old.Close()
+	}
+	// rename is durable once directory is synced, not all platforms
+	// support syncing a directory so it's best effort
+	syncDir(dir)
+	return nil
+}
+
+// writeSynced writes data to temp file and syncs it to disk, mode of file
+// is set to mode of filename if it exists
+func writeSynced(file *os.File, filename string, data []byte) error {
+	if info, err := os.Stat(filename); err == nil {
+		if err := file.Chmod(info.Mode().Perm()); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := writeFile(file, data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// MemoryRepository implements BoxRepository interface, box is kept in
+// memory. It's safe for concurrent use. Failures of Load and Save can be
+// injected by FailLoads and FailSaves for testing error paths of Box.
+type MemoryRepository struct {
+	mu      sync.Mutex
+	data    []byte
+	backups map[int]*MemoryRepository
+
+	failLoads int
+	failSaves int
+	loadErr   error
+	saveErr   error
+	saves     int
+}
+
+// NewMemoryRepository creates a MemoryRepository with initial data
+func NewMemoryRepository(data []byte) *MemoryRepository {
+	return &MemoryRepository{data: append([]byte{}, data...)}
+}
+
+// NewMemRepository creates a MemoryRepository with initial data, it's
+// short for NewMemoryRepository
+func NewMemRepository(initial []byte) *MemoryRepository {
+	return NewMemoryRepository(initial)
+}
+
+// Load implements BoxRepository.Load method
+func (repo *MemoryRepository) Load() ([]byte, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.failLoads > 0 {
+		repo.failLoads--
+		return nil, repo.loadErr
+	}
+	return append([]byte{}, repo.data...), nil
+}
+
+// Save implements BoxRepository.Save method, data kept is unchanged if
+// saving fails
+func (repo *MemoryRepository) Save(data []byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.failSaves > 0 {
+		repo.failSaves--
+		return repo.saveErr
+	}
+	repo.data = append([]byte{}, data...)
+	repo.saves++
+	return nil
+}
+
+// FailLoads makes next n calls of Load fail with err, errInjectedFailure
+// is returned if err is nil. It replaces failures injected before, n
+// isn't positive means no more failures.
+func (repo *MemoryRepository) FailLoads(n int, err error) {
+	if err == nil {
+		err = errInjectedFailure
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.failLoads, repo.loadErr = n, err
+}
+
+// FailSaves makes next n calls of Save fail with err as FailLoads does
+func (repo *MemoryRepository) FailSaves(n int, err error) {
+	if err == nil {
+		err = errInjectedFailure
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.failSaves, repo.saveErr = n, err
+}
+
+// Saves returns count of successful calls of Save
+func (repo *MemoryRepository) Saves() int {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.saves
+}
+
+// Bytes returns a copy of data saved last, injected failures of Load
+// don't apply
+func (repo *MemoryRepository) Bytes() []byte {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return append([]byte{}, repo.data...)
+}
+
+// Backup implements BackupRepository.Backup method
+func (repo *MemoryRepository) Backup(n int) BoxRepository {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.backups == nil {
+		repo.backups = map[int]*MemoryRepository{}
+	}
+	backup, ok := repo.backups[n]
+	if !ok {
+		backup = NewMemoryRepository(nil)
+		repo.backups[n] = backup
+	}
+	return backup
 }
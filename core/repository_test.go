@@ -0,0 +1,226 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// newSavedFileRepo returns a FileRepository in a new directory whose box
+// file holds data
+func newSavedFileRepo(t *testing.T, data []byte) *FileRepository {
+	t.Helper()
+	repo := NewFileRepository(filepath.Join(t.TempDir(), "password.data"))
+	if err := repo.Save(data); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// checkSaved checks that box file of repo holds data and no temp file is
+// left in its directory
+func checkSaved(t *testing.T, repo *FileRepository, data []byte) {
+	t.Helper()
+	got, err := repo.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("box file holds %q, want %q", got, data)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(repo.Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.Contains(file.Name(), ".tmp") {
+			t.Fatalf("temp file %s left", file.Name())
+		}
+	}
+}
+
+func TestFileRepositorySaveWriteFails(t *testing.T) {
+	repo := newSavedFileRepo(t, []byte("old"))
+	repo.Backups = -1
+	defer func(write func(*os.File, []byte) (int, error)) { writeFile = write }(writeFile)
+	writeFile = func(*os.File, []byte) (int, error) { return 0, errInjectedFailure }
+	if err := repo.Save([]byte("new")); err != errInjectedFailure {
+		t.Fatalf("Save: %v", err)
+	}
+	checkSaved(t, repo, []byte("old"))
+}
+
+func TestFileRepositorySaveRenameFails(t *testing.T) {
+	repo := newSavedFileRepo(t, []byte("old"))
+	repo.Backups = -1
+	defer func(rename func(string, string) error) { renameFile = rename }(renameFile)
+	renameFile = func(string, string) error { return errInjectedFailure }
+	if err := repo.Save([]byte("new")); err != errInjectedFailure {
+		t.Fatalf("Save: %v", err)
+	}
+	checkSaved(t, repo, []byte("old"))
+}
+
+func TestFileRepositorySaveBackupFails(t *testing.T) {
+	repo := newSavedFileRepo(t, []byte("old"))
+	defer func(write func(*os.File, []byte) (int, error)) { writeFile = write }(writeFile)
+	// the first write is the backup of the current file
+	writes := 0
+	writeFile = func(file *os.File, data []byte) (int, error) {
+		if writes++; writes == 1 {
+			return 0, errInjectedFailure
+		}
+		return file.Write(data)
+	}
+	if err := repo.Save([]byte("new")); err != errInjectedFailure {
+		t.Fatalf("Save: %v", err)
+	}
+	checkSaved(t, repo, []byte("old"))
+	if timestamps, err := repo.TimestampedBackups(); err != nil || len(timestamps) != 0 {
+		t.Fatalf("backups %v, %v", timestamps, err)
+	}
+
+	if err := repo.Save([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	checkSaved(t, repo, []byte("new"))
+	timestamps, err := repo.TimestampedBackups()
+	if err != nil || len(timestamps) != 1 {
+		t.Fatalf("backups %v, %v", timestamps, err)
+	}
+	if backup, err := repo.LoadBackup(timestamps[0]); err != nil || string(backup) != "old" {
+		t.Fatalf("backup %q, %v", backup, err)
+	}
+}
+
+func TestFileRepositorySaveShredFails(t *testing.T) {
+	if !shredEffective {
+		t.Skip("files aren't overwritten on this platform")
+	}
+	defer func(writeAt func(*os.File, []byte, int64) (int, error)) { writeFileAt = writeAt }(writeFileAt)
+	writeFileAt = func(*os.File, []byte, int64) (int, error) { return 0, errInjectedFailure }
+
+	// shredding the replaced file is best effort
+	repo := newSavedFileRepo(t, []byte("old"))
+	repo.Shred, repo.Backups = true, -1
+	if err := repo.Save([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	checkSaved(t, repo, []byte("new"))
+
+	// shredding a backup beyond those kept fails saving
+	repo.Backups = 1
+	if err := repo.Save([]byte("newer")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save([]byte("newest")); err != errInjectedFailure {
+		t.Fatalf("Save: %v", err)
+	}
+	checkSaved(t, repo, []byte("newer"))
+}
+
+func TestFileRepositorySaveReadOnlyDir(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions of directory don't apply")
+	}
+	repo := newSavedFileRepo(t, []byte("old"))
+	dir := filepath.Dir(repo.Filename)
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+	if err := repo.Save([]byte("new")); err == nil {
+		t.Fatal("saved to a read-only directory")
+	}
+	checkSaved(t, repo, []byte("old"))
+}
+
+func TestMemoryRepositoryFailures(t *testing.T) {
+	repo := NewMemoryRepository([]byte("initial"))
+	if data, err := repo.Load(); err != nil || string(data) != "initial" {
+		t.Fatalf("Load: %q, %v", data, err)
+	}
+
+	repo.FailLoads(2, nil)
+	for i := 0; i < 2; i++ {
+		if _, err := repo.Load(); err != errInjectedFailure {
+			t.Fatalf("Load %d: %v", i, err)
+		}
+	}
+	if data, err := repo.Load(); err != nil || string(data) != "initial" {
+		t.Fatalf("Load after failures: %q, %v", data, err)
+	}
+
+	custom := os.ErrPermission
+	repo.FailSaves(1, custom)
+	if err := repo.Save([]byte("lost")); err != custom {
+		t.Fatalf("Save: %v", err)
+	}
+	if n := repo.Saves(); n != 0 {
+		t.Fatalf("%d saves counted after a failed one", n)
+	}
+	if data := repo.Bytes(); string(data) != "initial" {
+		t.Fatalf("data changed by a failed save: %q", data)
+	}
+	if err := repo.Save([]byte("saved")); err != nil {
+		t.Fatal(err)
+	}
+	if n := repo.Saves(); n != 1 {
+		t.Fatalf("%d saves counted", n)
+	}
+
+	// injected failures of Load don't apply to Bytes
+	repo.FailLoads(1, nil)
+	if data := repo.Bytes(); string(data) != "saved" {
+		t.Fatalf("Bytes: %q", data)
+	}
+	// data returned is a copy
+	data := repo.Bytes()
+	data[0] = 'X'
+	if data := repo.Bytes(); string(data) != "saved" {
+		t.Fatalf("data modified through a copy: %q", data)
+	}
+}
+
+func TestMemoryRepositoryBackup(t *testing.T) {
+	repo := NewMemoryRepository(nil)
+	backup := repo.Backup(1)
+	if backup != repo.Backup(1) {
+		t.Fatal("backup 1 isn't the same repository each time")
+	}
+	if backup == repo.Backup(2) {
+		t.Fatal("backups 1 and 2 are the same repository")
+	}
+	if err := backup.Save([]byte("backup")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := repo.Backup(1).Load(); err != nil || string(data) != "backup" {
+		t.Fatalf("backup 1: %q, %v", data, err)
+	}
+	if data := repo.Bytes(); len(data) != 0 {
+		t.Fatalf("repository changed by its backup: %q", data)
+	}
+
+	// box saved through VersionedRepository is rotated into backups
+	versioned := NewVersionedRepository(repo, 2)
+	for _, data := range []string{"v1", "v2", "v3"} {
+		if err := versioned.Save([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n, want := range map[int]string{1: "v2", 2: "v1"} {
+		if data, err := repo.Backup(n).Load(); err != nil || string(data) != want {
+			t.Fatalf("backup %d: %q, %v", n, data, err)
+		}
+	}
+	if err := versioned.Restore(2); err != nil {
+		t.Fatal(err)
+	}
+	if data := repo.Bytes(); string(data) != "v1" {
+		t.Fatalf("restored %q", data)
+	}
+}
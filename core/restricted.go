@@ -0,0 +1,82 @@
+package core
+
+// Restricted mode allows approved algorithms only: keys are derived by
+// PBKDF2 and passwords are encrypted by AES-GCM. Boxes keyed by md5 or
+// holding passwords encrypted by unauthenticated AES-CFB or ChaCha20-Poly1305
+// are refused, they must be migrated by an unrestricted build first. It's
+// enabled by Options.Restricted, or for all boxes by building with tag
+// onepw_restricted.
+
+// restricted reports whether box runs in restricted mode
+func (box *Box) restricted() bool {
+	return restrictedBuild || box.options.Restricted
+}
+
+// checkRestrictedKDF checks that cfg is allowed in restricted mode
+func (box *Box) checkRestrictedKDF(cfg KDFConfig) error {
+	if !box.restricted() || cfg.Type == KDFPBKDF2 {
+		return nil
+	}
+	if cfg.isLegacy() {
+		return errRestrictedMD5
+	}
+	return newErrRestrictedKDF(cfg.Type)
+}
+
+// checkRestrictedCipher checks that cipherName is allowed in restricted mode
+func (box *Box) checkRestrictedCipher(cipherName string) error {
+	if !box.restricted() || cipherName == CipherAESGCM {
+		return nil
+	}
+	return newErrRestrictedCipher(cipherName)
+}
+
+// checkRestrictedOptions checks options for new boxes
+func (box *Box) checkRestrictedOptions() error {
+	if err := box.checkRestrictedKDF(box.options.KDF); err != nil {
+		return err
+	}
+	return box.checkRestrictedCipher(box.options.Cipher)
+}
+
+// checkRestrictedHeader checks key derivation of a box read from repo,
+// before any key is derived. kdf is used only if box has no key slot.
+func (box *Box) checkRestrictedHeader(kdf KDFConfig, slots []keySlot) error {
+	if !box.restricted() {
+		return nil
+	}
+	if len(slots) == 0 {
+		return box.checkRestrictedKDF(kdf)
+	}
+	for i, slot := range slots {
+		// recovery slots are keyed by HKDF, which is approved
+		if slot.isRecovery() {
+			continue
+		}
+		if err := box.checkRestrictedKDF(slot.KDF); err != nil {
+			return newErrRestrictedKeySlot(i, err)
+		}
+	}
+	return nil
+}
+
+// checkRestrictedPasswords checks schemes of passwords, ids of passwords
+// encrypted by disallowed schemes are reported
+func (box *Box) checkRestrictedPasswords(cipherName string, passwords []Password) error {
+	if !box.restricted() {
+		return nil
+	}
+	if err := box.checkRestrictedCipher(cipherName); err != nil {
+		return err
+	}
+	ids := []string{}
+	for i := range passwords {
+		if passwords[i].Scheme != CipherAESGCM {
+			ids = append(ids, passwords[i].ID)
+		}
+	}
+	if len(ids) > 0 {
+		return newErrRestrictedPasswords(ids)
+	}
+	return nil
+}
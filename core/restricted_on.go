@@ -0,0 +1,6 @@
+//go:build onepw_restricted
+
+package core
+
+// restrictedBuild enables restricted mode for all boxes
+const restrictedBuild = true
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+var restricted = Options{Restricted: true}
+
+// newRestrictedTestRepo returns repo of a box created by opts unrestricted,
+// holding passwords added, ids of them returned
+func newRestrictedTestRepo(t *testing.T, opts Options) (*MemoryRepository, []string) {
+	t.Helper()
+	repo := NewMemoryRepository(nil)
+	box := NewBoxWithOptions(repo, opts)
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := box.AddBatch([]*Password{
+		NewPassword("github", "me", "pw123456", "github.com"),
+		NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+		NewPassword("bitbucket", "them", "pw12345678", "bitbucket.org"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := box.Remove([]string{ids[2]}, false); err != nil {
+		t.Fatal(err)
+	}
+	return repo, ids
+}
+
+// setScheme sets scheme of saved password of id in repo, either in
+// passwords or trash
+func setScheme(t *testing.T, repo *MemoryRepository, id string, scheme string) {
+	t.Helper()
+	editSaved(t, repo, func(bd map[string]interface{}) {
+		for _, key := range []string{"Passwords", "Trash"} {
+			passwords, _ := bd[key].([]interface{})
+			for _, pw := range passwords {
+				if pw := pw.(map[string]interface{}); pw["ID"] == id {
+					pw["Scheme"] = scheme
+					return
+				}
+			}
+		}
+		t.Fatalf("password %s not saved", id)
+	})
+}
+
+func TestRestrictedRefusesBox(t *testing.T) {
+	argon2 := KDFConfig{Type: KDFArgon2id, Argon2Params: Argon2Params{Time: 1, Memory: 1024, Threads: 1}}
+	for _, tc := range []struct {
+		name string
+		repo func(t *testing.T) (*MemoryRepository, []string)
+		// want are substrings of error, named entries must be in it
+		want []string
+		// unwanted are entries which must not be named
+		unwanted []string
+	}{
+		{
+			name: "md5",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				return NewMemoryRepository(legacyBoxData(t, testMasterPassword)), nil
+			},
+			want: []string{errRestrictedMD5.Error()},
+		},
+		{
+			name: "scrypt",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				return newRestrictedTestRepo(t, Options{KDF: KDFConfig{Type: KDFScrypt, N: 1024, R: 8, P: 1}})
+			},
+			want: []string{"key slot 0", "key derivation scrypt"},
+		},
+		{
+			name: "argon2id",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				return newRestrictedTestRepo(t, Options{KDF: argon2})
+			},
+			want: []string{"key slot 0", "key derivation argon2id"},
+		},
+		{
+			name: "encrypted file by argon2id",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				return newRestrictedTestRepo(t, Options{EncryptFile: true, KDF: argon2})
+			},
+			want: []string{"key derivation argon2id"},
+		},
+		{
+			name: "chacha20-poly1305",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				return newRestrictedTestRepo(t, Options{Cipher: CipherChaCha20Poly1305})
+			},
+			want: []string{"cipher " + CipherChaCha20Poly1305},
+		},
+		{
+			name: "cfb password",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				repo, ids := newRestrictedTestRepo(t, Options{})
+				setScheme(t, repo, ids[1], schemeCFB)
+				return repo, ids
+			},
+			want:     []string{"$1"},
+			unwanted: []string{"$0", "$2"},
+		},
+		{
+			name: "chacha20-poly1305 password migrated partially",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				repo, ids := newRestrictedTestRepo(t, Options{})
+				setScheme(t, repo, ids[0], CipherChaCha20Poly1305)
+				return repo, ids
+			},
+			want:     []string{"$0"},
+			unwanted: []string{"$1", "$2"},
+		},
+		{
+			name: "cfb password in trash",
+			repo: func(t *testing.T) (*MemoryRepository, []string) {
+				repo, ids := newRestrictedTestRepo(t, Options{})
+				setScheme(t, repo, ids[2], schemeCFB)
+				return repo, ids
+			},
+			want:     []string{"$2"},
+			unwanted: []string{"$0", "$1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, ids := tc.repo(t)
+			// $i stands for id of the i-th password added
+			expand := func(s string) string {
+				for i, id := range ids {
+					s = strings.ReplaceAll(s, "$"+string(rune('0'+i)), id)
+				}
+				return s
+			}
+			err := NewBoxWithOptions(repo, restricted).Init(testMasterPassword)
+			if err == nil {
+				t.Fatal("box opened in restricted mode")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), expand(want)) {
+					t.Fatalf("error %q doesn't contain %q", err, expand(want))
+				}
+			}
+			for _, unwanted := range tc.unwanted {
+				if strings.Contains(err.Error(), expand(unwanted)) {
+					t.Fatalf("error %q contains %q", err, expand(unwanted))
+				}
+			}
+		})
+	}
+}
+
+func TestRestrictedRefusesOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Restricted: true, KDF: KDFConfig{Type: KDFScrypt}},
+		{Restricted: true, Cipher: CipherChaCha20Poly1305},
+	} {
+		if err := NewBoxWithOptions(NewMemoryRepository(nil), opts).Init(testMasterPassword); err == nil {
+			t.Fatalf("box created by %+v", opts)
+		}
+	}
+
+	repo := NewMemoryRepository(nil)
+	box := NewBoxWithOptions(repo, restricted)
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := box.ChangeCipher(CipherChaCha20Poly1305); err == nil || !strings.Contains(err.Error(), "restricted mode") {
+		t.Fatalf("ChangeCipher: %v", err)
+	}
+	if err := box.ChangeKDF(KDFConfig{Type: KDFScrypt}); err == nil || !strings.Contains(err.Error(), "restricted mode") {
+		t.Fatalf("ChangeKDF: %v", err)
+	}
+	if err := box.SetKDFParams(KDFConfig{Type: KDFArgon2id}); err == nil || !strings.Contains(err.Error(), "restricted mode") {
+		t.Fatalf("SetKDFParams: %v", err)
+	}
+	// box is opened restricted as it's compliant
+	if err := NewBoxWithOptions(repo, restricted).Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+}
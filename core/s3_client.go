@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Config configures client of S3 created by NewS3Client
+type S3Config struct {
+	Region string
+
+	// Endpoint of a S3-compatible service, e.g. "http://localhost:9000" of
+	// MinIO, endpoint of AWS is used if empty
+	Endpoint string
+
+	// PathStyle addresses bucket by path rather than host name, which
+	// MinIO usually requires
+	PathStyle bool
+
+	// Static credentials, read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_SESSION_TOKEN if AccessKeyID is empty. Requests are anonymous
+	// if no credentials found.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3Client implements ConditionalS3Client by aws-sdk-go-v2
+type s3Client struct {
+	client *s3.Client
+}
+
+// NewS3Client creates a ConditionalS3Client for S3Repository. Requests
+// aren't retried by the SDK, S3Repository retries transient errors itself.
+func NewS3Client(cfg S3Config) ConditionalS3Client {
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	options := s3.Options{
+		Region:       cfg.Region,
+		UsePathStyle: cfg.PathStyle,
+		Retryer:      aws.NopRetryer{},
+		Credentials:  aws.AnonymousCredentials{},
+	}
+	if cfg.Endpoint != "" {
+		options.BaseEndpoint = aws.String(cfg.Endpoint)
+	}
+	if cfg.AccessKeyID != "" {
+		credentials := aws.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+			Source:          "onepw",
+		}
+		options.Credentials = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return credentials, nil
+		})
+	}
+	return &s3Client{client: s3.New(options)}
+}
+
+// GetObject implements S3Client.GetObject method
+func (c *s3Client) GetObject(bucket, key string) ([]byte, error) {
+	data, _, err := c.GetObjectETag(bucket, key)
+	return data, err
+}
+
+// PutObject implements S3Client.PutObject method
+func (c *s3Client) PutObject(bucket, key string, data []byte) error {
+	_, err := c.put(&s3.PutObjectInput{}, bucket, key, data)
+	return err
+}
+
+// GetObjectETag implements ConditionalS3Client.GetObjectETag method
+func (c *s3Client) GetObjectETag(bucket, key string) ([]byte, string, error) {
+	out, err := c.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, aws.ToString(out.ETag), nil
+}
+
+// PutObjectIfMatch implements ConditionalS3Client.PutObjectIfMatch method
+func (c *s3Client) PutObjectIfMatch(bucket, key string, data []byte, etag string) (string, error) {
+	input := &s3.PutObjectInput{}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+	return c.put(input, bucket, key, data)
+}
+
+func (c *s3Client) put(input *s3.PutObjectInput, bucket, key string, data []byte) (string, error) {
+	input.Bucket = aws.String(bucket)
+	input.Key = aws.String(key)
+	input.Body = bytes.NewReader(data)
+	input.ContentLength = aws.Int64(int64(len(data)))
+	out, err := c.client.PutObject(context.Background(), input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
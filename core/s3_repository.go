@@ -0,0 +1,257 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// s3NoSuchKey is error code of S3 returned when object doesn't exist
+	s3NoSuchKey = "NoSuchKey"
+
+	// DefaultS3Retries is number of retries of a transient S3 error
+	DefaultS3Retries = 3
+
+	// DefaultS3RetryDelay is delay before the first retry, it doubles on
+	// every retry
+	DefaultS3RetryDelay = 200 * time.Millisecond
+)
+
+// s3ConflictCodes are error codes of S3 returned when a conditional write
+// fails since object was changed by others
+var s3ConflictCodes = map[string]bool{
+	"PreconditionFailed":         true,
+	"ConditionalRequestConflict": true,
+}
+
+// s3TransientCodes are error codes of S3 which may succeed if retried
+var s3TransientCodes = map[string]bool{
+	"RequestTimeout":          true,
+	"RequestTimeoutException": true,
+	"InternalError":           true,
+	"ServiceUnavailable":      true,
+	"SlowDown":                true,
+	"Throttling":              true,
+	"ThrottlingException":     true,
+	"RequestLimitExceeded":    true,
+}
+
+// S3Client is the subset of S3 API used by S3Repository. A client wrapping
+// aws-sdk-go should return the SDK error as is, errors carrying code
+// NoSuchKey (e.g. awserr.Error) are treated as a missing box.
+type S3Client interface {
+	GetObject(bucket, key string) ([]byte, error)
+	PutObject(bucket, key string, data []byte) error
+}
+
+// ConditionalS3Client is a S3Client supporting conditional writes, so
+// S3Repository detects boxes saved by others since loaded rather than
+// overwriting them. NewS3Client returns one.
+type ConditionalS3Client interface {
+	S3Client
+
+	// GetObjectETag returns object and its ETag
+	GetObjectETag(bucket, key string) ([]byte, string, error)
+
+	// PutObjectIfMatch saves object only if its ETag is etag, or only if
+	// it doesn't exist if etag is empty, and returns ETag of the new object.
+	// Error carrying code PreconditionFailed is returned otherwise.
+	PutObjectIfMatch(bucket, key string, data []byte, etag string) (string, error)
+}
+
+// S3ConflictError is returned by S3Repository.Save if box was saved by
+// others since loaded. Box of S3 isn't changed, it should be reloaded and
+// changes made again.
+type S3ConflictError struct {
+	Bucket string
+	Key    string
+	Err    error
+}
+
+// Error implements error interface
+func (err *S3ConflictError) Error() string {
+	return fmt.Sprintf("box s3://%s/%s was saved by others since loaded, reload it and try again", err.Bucket, err.Key)
+}
+
+// Unwrap returns error of S3
+func (err *S3ConflictError) Unwrap() error {
+	return err.Err
+}
+
+// S3Repository implements BoxRepository interface, box is stored as
+// object key in bucket. Saving is conditional on ETag of the object loaded
+// if client is a ConditionalS3Client.
+type S3Repository struct {
+	Bucket string
+	Key    string
+
+	// Retries is number of retries of transient errors, 0 means
+	// DefaultS3Retries and negative means no retry
+	Retries int
+
+	// RetryDelay is delay before the first retry, 0 means
+	// DefaultS3RetryDelay. Delay doubles on every retry.
+	RetryDelay time.Duration
+
+	client S3Client
+
+	mu     sync.Mutex
+	loaded bool
+	etag   string
+}
+
+// NewS3Repository creates a S3Repository
+func NewS3Repository(bucket, key string, client S3Client) *S3Repository {
+	return &S3Repository{
+		Bucket: bucket,
+		Key:    key,
+		client: client,
+	}
+}
+
+// Load implements BoxRepository.Load method, empty data returned if object not exist
+func (repo *S3Repository) Load() ([]byte, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var data []byte
+	etag := ""
+	err := repo.retry(func() (err error) {
+		if client, ok := repo.client.(ConditionalS3Client); ok {
+			data, etag, err = client.GetObjectETag(repo.Bucket, repo.Key)
+		} else {
+			data, err = repo.client.GetObject(repo.Bucket, repo.Key)
+		}
+		return err
+	})
+	if isS3NoSuchKey(err) {
+		repo.loaded, repo.etag = true, ""
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	repo.loaded, repo.etag = true, etag
+	return data, nil
+}
+
+// Save implements BoxRepository.Save method. A *S3ConflictError is returned
+// if object was changed since the last Load or Save, object is overwritten
+// unconditionally if it was never loaded. A retried write conflicting with
+// an object same as data succeeds, since it was saved by a former attempt.
+func (repo *S3Repository) Save(data []byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	client, ok := repo.client.(ConditionalS3Client)
+	if !ok || !repo.loaded {
+		return repo.retry(func() error {
+			return repo.client.PutObject(repo.Bucket, repo.Key, data)
+		})
+	}
+	etag := ""
+	attempts := 0
+	err := repo.retry(func() (err error) {
+		attempts++
+		etag, err = client.PutObjectIfMatch(repo.Bucket, repo.Key, data, repo.etag)
+		return err
+	})
+	if isS3Conflict(err) && attempts > 1 {
+		// a retried attempt may conflict with the one before, which was
+		// saved but whose response was lost
+		var saved []byte
+		loadErr := repo.retry(func() (err error) {
+			saved, etag, err = client.GetObjectETag(repo.Bucket, repo.Key)
+			return err
+		})
+		if loadErr == nil && bytes.Equal(saved, data) {
+			err = nil
+		}
+	}
+	if isS3Conflict(err) {
+		return &S3ConflictError{Bucket: repo.Bucket, Key: repo.Key, Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	repo.etag = etag
+	return nil
+}
+
+// retry calls fn until it succeeds, fails for a non-transient error or
+// retries run out, with exponential backoff
+func (repo *S3Repository) retry(fn func() error) error {
+	retries := repo.Retries
+	if retries == 0 {
+		retries = DefaultS3Retries
+	}
+	delay := repo.RetryDelay
+	if delay <= 0 {
+		delay = DefaultS3RetryDelay
+	}
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= retries || !isS3Transient(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// s3ErrorCode returns error code of S3 carried by err, both Code of
+// aws-sdk-go and ErrorCode of aws-sdk-go-v2 are supported
+func s3ErrorCode(err error) string {
+	var coder interface {
+		Code() string
+	}
+	if errors.As(err, &coder) {
+		return coder.Code()
+	}
+	var v2 interface {
+		ErrorCode() string
+	}
+	if errors.As(err, &v2) {
+		return v2.ErrorCode()
+	}
+	return ""
+}
+
+// s3StatusCode returns HTTP status code carried by err, 0 if unknown
+func s3StatusCode(err error) int {
+	var status interface {
+		StatusCode() int
+	}
+	if errors.As(err, &status) {
+		return status.StatusCode()
+	}
+	var v2 interface {
+		HTTPStatusCode() int
+	}
+	if errors.As(err, &v2) {
+		return v2.HTTPStatusCode()
+	}
+	return 0
+}
+
+func isS3NoSuchKey(err error) bool {
+	return err != nil && s3ErrorCode(err) == s3NoSuchKey
+}
+
+func isS3Conflict(err error) bool {
+	return err != nil && (s3ConflictCodes[s3ErrorCode(err)] || s3StatusCode(err) == http.StatusPreconditionFailed)
+}
+
+func isS3Transient(err error) bool {
+	if isS3Conflict(err) {
+		return false
+	}
+	if s3TransientCodes[s3ErrorCode(err)] || s3StatusCode(err) >= http.StatusInternalServerError {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
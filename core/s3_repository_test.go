@@ -0,0 +1,324 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Error is an error of S3 carrying code and HTTP status code as errors
+// of aws-sdk-go do
+type s3Error struct {
+	code   string
+	status int
+}
+
+func (err *s3Error) Error() string   { return fmt.Sprintf("%s (%d)", err.code, err.status) }
+func (err *s3Error) Code() string    { return err.code }
+func (err *s3Error) StatusCode() int { return err.status }
+
+// timeoutError is a net.Error timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// fakeS3 implements ConditionalS3Client in memory. Errors in fails are
+// returned by the next requests, one for each.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	etags    map[string]string
+	versions int
+	fails    []error
+	requests int
+
+	// lostPuts are number of conditional writes saved whose responses are
+	// lost by timeout, then lost is called if not nil
+	lostPuts int
+	lost     func()
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+}
+
+// request counts a request and returns error injected for it
+func (s3 *fakeS3) request() error {
+	s3.requests++
+	if len(s3.fails) == 0 {
+		return nil
+	}
+	err := s3.fails[0]
+	s3.fails = s3.fails[1:]
+	return err
+}
+
+func (s3 *fakeS3) GetObject(bucket, key string) ([]byte, error) {
+	data, _, err := s3.GetObjectETag(bucket, key)
+	return data, err
+}
+
+func (s3 *fakeS3) PutObject(bucket, key string, data []byte) error {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	if err := s3.request(); err != nil {
+		return err
+	}
+	s3.put(bucket+"/"+key, data)
+	return nil
+}
+
+func (s3 *fakeS3) GetObjectETag(bucket, key string) ([]byte, string, error) {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	if err := s3.request(); err != nil {
+		return nil, "", err
+	}
+	data, ok := s3.objects[bucket+"/"+key]
+	if !ok {
+		return nil, "", &s3Error{code: s3NoSuchKey, status: http.StatusNotFound}
+	}
+	return append([]byte{}, data...), s3.etags[bucket+"/"+key], nil
+}
+
+func (s3 *fakeS3) PutObjectIfMatch(bucket, key string, data []byte, etag string) (string, error) {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	if err := s3.request(); err != nil {
+		return "", err
+	}
+	if s3.etags[bucket+"/"+key] != etag {
+		return "", &s3Error{code: "PreconditionFailed", status: http.StatusPreconditionFailed}
+	}
+	etag = s3.put(bucket+"/"+key, data)
+	if s3.lostPuts > 0 {
+		s3.lostPuts--
+		if s3.lost != nil {
+			s3.lost()
+		}
+		return "", timeoutError{}
+	}
+	return etag, nil
+}
+
+func (s3 *fakeS3) put(path string, data []byte) string {
+	s3.versions++
+	s3.objects[path] = append([]byte{}, data...)
+	s3.etags[path] = fmt.Sprintf(`"%d"`, s3.versions)
+	return s3.etags[path]
+}
+
+// unconditionalS3 hides conditional writes of a fakeS3
+type unconditionalS3 struct {
+	S3Client
+}
+
+func newTestS3Repository(client S3Client) *S3Repository {
+	repo := NewS3Repository("bucket", "password.data", client)
+	repo.RetryDelay = time.Millisecond
+	return repo
+}
+
+func TestS3RepositoryRoundTrip(t *testing.T) {
+	repo := newTestS3Repository(newFakeS3())
+	if data, err := repo.Load(); err != nil || len(data) != 0 {
+		t.Fatalf("Load of missing box: %q, %v", data, err)
+	}
+	box := NewBox(repo)
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewBox(newTestS3Repository(repo.client))
+	if err := loaded.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got := getPassword(t, loaded, id); string(got.PlainPassword) != "pw123456" {
+		t.Fatalf("password: %q", got.PlainPassword)
+	}
+}
+
+func TestS3RepositoryConflict(t *testing.T) {
+	client := newFakeS3()
+	ours, theirs := newTestS3Repository(client), newTestS3Repository(client)
+	// both find no box, the first one saved wins
+	for _, repo := range []*S3Repository{ours, theirs} {
+		if _, err := repo.Load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := theirs.Save([]byte("theirs")); err != nil {
+		t.Fatal(err)
+	}
+	var conflict *S3ConflictError
+	if err := ours.Save([]byte("ours")); !errors.As(err, &conflict) {
+		t.Fatalf("Save of new box: %v", err)
+	}
+
+	// saved by others since loaded
+	if _, err := ours.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.Save([]byte("theirs again")); err != nil {
+		t.Fatal(err)
+	}
+	err := ours.Save([]byte("ours"))
+	if !errors.As(err, &conflict) || conflict.Bucket != "bucket" || conflict.Key != "password.data" {
+		t.Fatalf("Save: %v", err)
+	}
+	if data, _ := client.GetObject("bucket", "password.data"); string(data) != "theirs again" {
+		t.Fatalf("box overwritten: %q", data)
+	}
+
+	// saved after reloaded, and saved again by its own ETag
+	if data, err := ours.Load(); err != nil || string(data) != "theirs again" {
+		t.Fatalf("Load: %q, %v", data, err)
+	}
+	for _, data := range []string{"ours", "ours again"} {
+		if err := ours.Save([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// box never loaded, or client unable to write conditionally, is
+	// overwritten
+	if err := newTestS3Repository(client).Save([]byte("blind")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := theirs.Load(); err != nil {
+		t.Fatal(err)
+	}
+	unconditional := newTestS3Repository(unconditionalS3{client})
+	if _, err := unconditional.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ours.Save([]byte("ours")); !errors.As(err, &conflict) {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := unconditional.Save([]byte("unconditional")); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.Save([]byte("theirs")); !errors.As(err, &conflict) {
+		t.Fatalf("Save: %v", err)
+	}
+}
+
+func TestS3RepositoryRetry(t *testing.T) {
+	transient := []error{
+		&s3Error{code: "SlowDown", status: http.StatusServiceUnavailable},
+		&s3Error{code: "", status: http.StatusInternalServerError},
+		timeoutError{},
+	}
+	for _, tt := range []struct {
+		name     string
+		retries  int
+		fails    []error
+		requests int
+		failed   bool
+	}{
+		{"transient errors retried", 0, transient, 4, false},
+		{"retries run out", 2, transient, 3, true},
+		{"no retry", -1, transient[:1], 1, true},
+		{"not transient", 0, []error{&s3Error{code: "AccessDenied", status: http.StatusForbidden}}, 1, true},
+		{"conflict not retried", 0, []error{&s3Error{code: "PreconditionFailed", status: http.StatusPreconditionFailed}}, 1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeS3()
+			repo := newTestS3Repository(client)
+			repo.Retries = tt.retries
+			if _, err := repo.Load(); err != nil {
+				t.Fatal(err)
+			}
+			client.requests, client.fails = 0, append([]error{}, tt.fails...)
+			start := time.Now()
+			err := repo.Save([]byte("box"))
+			if failed := err != nil; failed != tt.failed {
+				t.Fatalf("Save: %v", err)
+			}
+			if client.requests != tt.requests {
+				t.Fatalf("%d requests, want %d", client.requests, tt.requests)
+			}
+			// delay doubles on every retry
+			if want := time.Duration(1<<uint(tt.requests-1)-1) * repo.RetryDelay; time.Since(start) < want {
+				t.Fatalf("retried in %v, want at least %v", time.Since(start), want)
+			}
+		})
+	}
+
+	// a retried write conflicts with the former one whose response was lost
+	client := newFakeS3()
+	repo := newTestS3Repository(client)
+	if _, err := repo.Load(); err != nil {
+		t.Fatal(err)
+	}
+	client.requests, client.lostPuts = 0, 1
+	if err := repo.Save([]byte("box")); err != nil {
+		t.Fatalf("Save of lost response: %v", err)
+	}
+	// put lost, put conflicting and get
+	if client.requests != 3 {
+		t.Fatalf("%d requests", client.requests)
+	}
+	// saved again by ETag of the object saved
+	if err := repo.Save([]byte("box changed")); err != nil {
+		t.Fatal(err)
+	}
+
+	// object was changed by others after the lost write
+	client.lostPuts = 1
+	client.lost = func() { client.put("bucket/password.data", []byte("theirs")) }
+	var conflict *S3ConflictError
+	if err := repo.Save([]byte("ours")); !errors.As(err, &conflict) {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// Load is retried too
+	client = newFakeS3()
+	client.fails = transient[:2]
+	if data, err := newTestS3Repository(client).Load(); err != nil || len(data) != 0 {
+		t.Fatalf("Load: %q, %v", data, err)
+	}
+}
+
+// TestS3RepositoryMinIO runs against a S3-compatible service, e.g. MinIO
+// started by
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//
+// with ONEPW_TEST_S3_ENDPOINT=http://localhost:9000, ONEPW_TEST_S3_BUCKET of
+// an existing bucket and credentials in AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY. It's skipped if no endpoint set.
+func TestS3RepositoryMinIO(t *testing.T) {
+	endpoint, bucket := os.Getenv("ONEPW_TEST_S3_ENDPOINT"), os.Getenv("ONEPW_TEST_S3_BUCKET")
+	if endpoint == "" || bucket == "" {
+		t.Skip("ONEPW_TEST_S3_ENDPOINT or ONEPW_TEST_S3_BUCKET not set")
+	}
+	client := NewS3Client(S3Config{Region: "us-east-1", Endpoint: endpoint, PathStyle: true})
+	key := fmt.Sprintf("onepw-test-%d", time.Now().UnixNano())
+	ours, theirs := NewS3Repository(bucket, key, client), NewS3Repository(bucket, key, client)
+	for _, repo := range []*S3Repository{ours, theirs} {
+		if data, err := repo.Load(); err != nil || len(data) != 0 {
+			t.Fatalf("Load of missing box: %q, %v", data, err)
+		}
+	}
+	if err := ours.Save([]byte("ours")); err != nil {
+		t.Fatal(err)
+	}
+	var conflict *S3ConflictError
+	if err := theirs.Save([]byte("theirs")); !errors.As(err, &conflict) {
+		t.Fatalf("Save: %v", err)
+	}
+	if data, err := theirs.Load(); err != nil || !bytes.Equal(data, []byte("ours")) {
+		t.Fatalf("Load: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// shareMagic prefixes entries shared by ShareEntry
+var shareMagic = []byte("onepw-shared-entry\n")
+
+// shareVersion is version of format of shared entries
+const shareVersion = 1
+
+// sharedBlob is envelope of a shared entry. Entry is encrypted by AES-GCM
+// with a key agreed by X25519 between an ephemeral key and recipient's
+// key, so nothing of sender's box keys is in it.
+type sharedBlob struct {
+	Version      int
+	EphemeralKey []byte
+	IV           []byte
+	Data         []byte
+}
+
+// sharedEntry is content of a shared password, ids and time stamps of
+// sender's box are not shared
+type sharedEntry struct {
+	Category     string
+	Account      []byte
+	Password     []byte
+	Site         string
+	URL          string            `json:",omitempty"`
+	Tags         []string          `json:",omitempty"`
+	Notes        []byte            `json:",omitempty"`
+	TOTPSecret   []byte            `json:",omitempty"`
+	CustomFields map[string]string `json:",omitempty"`
+	ExpiresAt    int64             `json:",omitempty"`
+}
+
+func (e *sharedEntry) wipe() {
+	Secret(e.Account).Wipe()
+	Secret(e.Password).Wipe()
+	Secret(e.Notes).Wipe()
+	Secret(e.TOTPSecret).Wipe()
+}
+
+// GenerateShareKeypair generates a X25519 key pair for receiving shared
+// entries, public key is given to senders of ShareEntry
+func GenerateShareKeypair() (publicKey, privateKey []byte, err error) {
+	if privateKey, err = randomBytes(curve25519.ScalarSize); err != nil {
+		return nil, nil, err
+	}
+	if publicKey, err = curve25519.X25519(privateKey, curve25519.Basepoint); err != nil {
+		return nil, nil, err
+	}
+	return publicKey, privateKey, nil
+}
+
+// shareAEAD returns AEAD keyed by X25519 agreement of privateKey and
+// publicKey, both public keys are bound to the key
+func shareAEAD(privateKey, publicKey, ephemeralKey, recipientKey []byte) (cipher.AEAD, error) {
+	shared, err := curve25519.X25519(privateKey, publicKey)
+	if err != nil {
+		return nil, errInvalidShareKey
+	}
+	secret := append(append(shared, ephemeralKey...), recipientKey...)
+	defer Secret(secret).Wipe()
+	key, err := hkdfKey(secret, shareLabel)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(key).Wipe()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ShareEntry returns password id encrypted to X25519 public key of
+// recipient, it's added to recipient's box by ImportShared. id may be a
+// prefix of ID as Remove accepts.
+func (box *Box) ShareEntry(id string, recipientPubKey []byte) ([]byte, error) {
+	if len(recipientPubKey) != curve25519.PointSize {
+		return nil, errInvalidShareKey
+	}
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return nil, err
+	}
+	found, err := box.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	passwords := []Password{*found}
+	wipe, err := box.reveal(passwords)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe()
+	pw := &passwords[0]
+	entry := sharedEntry{
+		Category:     pw.Category,
+		Account:      pw.PlainAccount,
+		Password:     pw.PlainPassword,
+		Site:         pw.Site,
+		URL:          pw.URL,
+		Tags:         pw.Tags,
+		Notes:        pw.PlainNotes,
+		TOTPSecret:   pw.PlainTOTPSecret,
+		CustomFields: pw.CustomFields,
+		ExpiresAt:    pw.ExpiresAt,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(data).Wipe()
+
+	ephemeralPub, ephemeralPriv, err := GenerateShareKeypair()
+	if err != nil {
+		return nil, err
+	}
+	defer Secret(ephemeralPriv).Wipe()
+	aead, err := shareAEAD(ephemeralPriv, recipientPubKey, ephemeralPub, recipientPubKey)
+	if err != nil {
+		return nil, err
+	}
+	blob := sharedBlob{Version: shareVersion, EphemeralKey: ephemeralPub}
+	if blob.IV, err = randomBytes(aead.NonceSize()); err != nil {
+		return nil, err
+	}
+	blob.Data = aead.Seal(nil, blob.IV, data, shareMagic)
+	out, err := json.MarshalIndent(blob, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, shareMagic...), out...), nil
+}
+
+// ImportShared decrypts entry shared by ShareEntry with X25519 private key
+// of recipient, and adds it to box as a new password. Id of the new
+// password is returned.
+func (box *Box) ImportShared(blob, privateKey []byte) (string, error) {
+	if len(privateKey) != curve25519.ScalarSize {
+		return "", errInvalidShareKey
+	}
+	blob = bytes.TrimSpace(blob)
+	if !bytes.HasPrefix(blob, shareMagic) {
+		return "", errNotSharedEntry
+	}
+	sb := sharedBlob{}
+	if err := json.Unmarshal(blob[len(shareMagic):], &sb); err != nil {
+		return "", errNotSharedEntry
+	}
+	if sb.Version > shareVersion {
+		return "", newErrUnsupportedVersion(sb.Version)
+	}
+	recipientPub, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return "", errInvalidShareKey
+	}
+	aead, err := shareAEAD(privateKey, sb.EphemeralKey, sb.EphemeralKey, recipientPub)
+	if err != nil {
+		return "", err
+	}
+	if len(sb.IV) != aead.NonceSize() {
+		return "", errLengthOfIV
+	}
+	data, err := aead.Open(nil, sb.IV, sb.Data, shareMagic)
+	if err != nil {
+		return "", errSharedEntryAuthFailed
+	}
+	defer Secret(data).Wipe()
+	entry := sharedEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", err
+	}
+	defer entry.wipe()
+
+	pw := NewPassword(entry.Category, "", "", entry.Site)
+	pw.PlainAccount = Secret(entry.Account).clone()
+	pw.PlainPassword = Secret(entry.Password).clone()
+	pw.PlainNotes = Secret(entry.Notes).clone()
+	pw.PlainTOTPSecret = Secret(entry.TOTPSecret).clone()
+	pw.URL = entry.URL
+	if entry.Tags != nil {
+		pw.Tags = entry.Tags
+	}
+	pw.CustomFields = entry.CustomFields
+	pw.ExpiresAt = entry.ExpiresAt
+	id, _, err := box.Add(pw)
+	return id, err
+}
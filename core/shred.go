@@ -0,0 +1,72 @@
+package core
+
+import (
+	"os"
+	"runtime"
+)
+
+// shredEffective reports whether overwriting a file in place reaches blocks
+// holding its content. APFS, the filesystem of macOS, is copy-on-write, so
+// files are only removed there.
+const shredEffective = runtime.GOOS != "darwin"
+
+// shredChunk is size of zeros written at a time by shredFile
+const shredChunk = 32 * 1024
+
+// SecureRemove overwrites content of file path with zeros, syncs it to disk
+// and removes the file. It's best effort: copy-on-write filesystems (e.g.
+// btrfs, ZFS), snapshots and wear leveling of SSD may keep old blocks
+// elsewhere, content of other hard links of the file is zeroed too.
+func SecureRemove(path string) error {
+	file, err := openShred(path)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		err = shredFile(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return removeFile(path)
+}
+
+// openShred opens path for overwriting, nil returned if path doesn't exist,
+// isn't a regular file or overwriting is meaningless
+func openShred(path string) (*os.File, error) {
+	if !shredEffective {
+		return nil, nil
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// symlinks are removed without overwriting their targets
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY, 0)
+}
+
+// shredFile overwrites content of file with zeros and syncs it to disk
+func shredFile(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, shredChunk)
+	for off := int64(0); off < info.Size(); off += shredChunk {
+		n := info.Size() - off
+		if n > shredChunk {
+			n = shredChunk
+		}
+		if _, err := writeFileAt(file, zeros[:n], off); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
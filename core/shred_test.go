@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// linkWitness hard links path to a new file, which keeps the inode of path
+// alive after path removed, so content left on disk can be read
+func linkWitness(t *testing.T, path string) string {
+	t.Helper()
+	witness := filepath.Join(filepath.Dir(path), "witness-"+filepath.Base(path))
+	if err := os.Link(path, witness); err != nil {
+		t.Skipf("hard links unsupported: %v", err)
+	}
+	return witness
+}
+
+// checkShredded checks that path is removed, and content of its inode
+// read by witness is zeroed if overwriting is effective
+func checkShredded(t *testing.T, path, witness string, size int) {
+	t.Helper()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("%s not removed: %v", path, err)
+	}
+	data, err := ioutil.ReadFile(witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !shredEffective {
+		return
+	}
+	if !bytes.Equal(data, make([]byte, size)) {
+		t.Fatalf("content of %s not zeroed", path)
+	}
+}
+
+func TestSecureRemove(t *testing.T) {
+	dir := t.TempDir()
+	// larger than a chunk, so it's overwritten by several writes
+	secret := bytes.Repeat([]byte("pw123456"), shredChunk/4+3)
+	path := filepath.Join(dir, "password.data")
+	if err := ioutil.WriteFile(path, secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	witness := linkWitness(t, path)
+	if err := SecureRemove(path); err != nil {
+		t.Fatal(err)
+	}
+	checkShredded(t, path, witness, len(secret))
+
+	if err := SecureRemove(path); !os.IsNotExist(err) {
+		t.Fatalf("SecureRemove of missing file: %v", err)
+	}
+
+	// symlink is removed, its target is kept as it was
+	if err := ioutil.WriteFile(path, secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if err := SecureRemove(link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Fatalf("symlink not removed: %v", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(data, secret) {
+		t.Fatalf("target of symlink changed: %v", err)
+	}
+}
+
+func TestPruneBackupsShred(t *testing.T) {
+	repo := newSavedFileRepo(t, []byte("v1"))
+	repo.Backups = 3
+	for _, data := range []string{"v2", "v3", "v4"} {
+		if err := repo.Save([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	timestamps, err := repo.TimestampedBackups()
+	if err != nil || len(timestamps) != 3 {
+		t.Fatalf("backups %v, %v", timestamps, err)
+	}
+	// numbered backup made by old versions
+	if err := ioutil.WriteFile(repo.backupName(1), []byte("v0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// backups but the most recent one are old enough to be pruned
+	old := time.Now().Add(-48 * time.Hour)
+	witnesses := map[string]string{}
+	for _, name := range []string{
+		repo.timestampedBackupName(timestamps[1]),
+		repo.timestampedBackupName(timestamps[2]),
+		repo.backupName(1),
+	} {
+		if err := os.Chtimes(name, old, old); err != nil {
+			t.Fatal(err)
+		}
+		witnesses[name] = linkWitness(t, name)
+	}
+
+	pruned, err := repo.PruneBackups(24*time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != len(witnesses) {
+		t.Fatalf("pruned %v", pruned)
+	}
+	for _, name := range pruned {
+		witness, ok := witnesses[name]
+		if !ok {
+			t.Fatalf("%s pruned", name)
+		}
+		checkShredded(t, name, witness, len("v1"))
+	}
+	recent := repo.timestampedBackupName(timestamps[0])
+	if data, err := ioutil.ReadFile(recent); err != nil || string(data) != "v3" {
+		t.Fatalf("recent backup: %q, %v", data, err)
+	}
+	checkSaved(t, repo, []byte("v4"))
+}
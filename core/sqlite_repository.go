@@ -0,0 +1,328 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"sort"
+)
+
+// sqliteSchema holds statements migrating schema of SQLiteRepository,
+// the i-th statement migrates schema from version i to version i+1
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS onepw_meta (
+		key   TEXT PRIMARY KEY,
+		value BLOB
+	);
+	CREATE TABLE IF NOT EXISTS onepw_passwords (
+		id   TEXT PRIMARY KEY,
+		data BLOB NOT NULL
+	);`,
+	// columns of plaintext metadata, empty if box encrypts metadata
+	`ALTER TABLE onepw_passwords ADD COLUMN category TEXT NOT NULL DEFAULT '';
+	ALTER TABLE onepw_passwords ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE onepw_passwords ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE onepw_passwords SET
+		category = COALESCE(json_extract(data, '$.Category'), ''),
+		created_at = COALESCE(json_extract(data, '$.CreatedAt'), 0),
+		updated_at = COALESCE(json_extract(data, '$.LastUpdatedAt'), 0);
+	CREATE INDEX IF NOT EXISTS onepw_passwords_category ON onepw_passwords (category);`,
+}
+
+const (
+	sqliteSchemaVersionKey = "schema_version"
+	sqliteHeaderKey        = "header"
+)
+
+// SQLiteRepository implements EntryRepository interface, it stores each
+// encrypted password as a row with its id, category and time stamps, so
+// saving a box only writes rows of passwords which changed.
+//
+// The database driver is not imported by this package, open db with a
+// sqlite driver such as github.com/mattn/go-sqlite3 and pass it to
+// NewSQLiteRepository.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository creates a SQLiteRepository, schema of db is created
+// or migrated to current version
+func NewSQLiteRepository(db *sql.DB) (*SQLiteRepository, error) {
+	repo := &SQLiteRepository{db: db}
+	if err := repo.migrate(); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+func (repo *SQLiteRepository) migrate() error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	version := 0
+	// onepw_meta doesn't exist before first migration
+	var tables int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'onepw_meta'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		err := tx.QueryRow(`SELECT value FROM onepw_meta WHERE key = ?`, sqliteSchemaVersionKey).Scan(&version)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	if version > len(sqliteSchema) {
+		return errSQLiteSchemaTooNew
+	}
+	if version == len(sqliteSchema) {
+		return nil
+	}
+	for ; version < len(sqliteSchema); version++ {
+		if _, err := tx.Exec(sqliteSchema[version]); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO onepw_meta (key, value) VALUES (?, ?)`, sqliteSchemaVersionKey, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Load implements BoxRepository.Load method, empty data returned if no box saved
+func (repo *SQLiteRepository) Load() ([]byte, error) {
+	var header []byte
+	err := repo.db.QueryRow(`SELECT value FROM onepw_meta WHERE key = ?`, sqliteHeaderKey).Scan(&header)
+	if err == sql.ErrNoRows {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := repo.db.Query(`SELECT data FROM onepw_passwords ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	passwords := []json.RawMessage{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, json.RawMessage(data))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return joinBoxData(header, passwords)
+}
+
+// Save implements BoxRepository.Save method. Header of box and passwords
+// are written in one transaction, unchanged passwords are not rewritten.
+func (repo *SQLiteRepository) Save(data []byte) error {
+	header, passwords, err := splitBoxData(data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := sqliteSaveHeader(tx, header); err != nil {
+		return err
+	}
+
+	stored, err := sqliteStoredPasswords(tx)
+	if err != nil {
+		return err
+	}
+	for id, pw := range passwords {
+		if old, ok := stored[id]; ok && bytes.Equal(old, pw) {
+			continue
+		}
+		if err := sqliteSavePassword(tx, id, pw); err != nil {
+			return err
+		}
+	}
+	for id := range stored {
+		if _, ok := passwords[id]; ok {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM onepw_passwords WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveOne implements EntryRepository.SaveOne method, header and the row
+// of password are written in one transaction
+func (repo *SQLiteRepository) SaveOne(header []byte, id string, password []byte) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := sqliteSaveHeader(tx, header); err != nil {
+		return err
+	}
+	if err := sqliteSavePassword(tx, id, password); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteOne implements EntryRepository.DeleteOne method, header is written
+// and the row of password deleted in one transaction
+func (repo *SQLiteRepository) DeleteOne(header []byte, id string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := sqliteSaveHeader(tx, header); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM onepw_passwords WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func sqliteSaveHeader(tx *sql.Tx, header []byte) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO onepw_meta (key, value) VALUES (?, ?)`, sqliteHeaderKey, header)
+	return err
+}
+
+// sqliteSavePassword writes row of serialized password, category and time
+// stamps are copied to their columns
+func sqliteSavePassword(tx *sql.Tx, id string, password []byte) error {
+	md := struct {
+		Category      string
+		CreatedAt     int64
+		LastUpdatedAt int64
+	}{}
+	if err := json.Unmarshal(password, &md); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO onepw_passwords (id, data, category, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		id, password, md.Category, md.CreatedAt, md.LastUpdatedAt)
+	return err
+}
+
+func sqliteStoredPasswords(tx *sql.Tx) (map[string][]byte, error) {
+	rows, err := tx.Query(`SELECT id, data FROM onepw_passwords`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stored := map[string][]byte{}
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+		)
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		stored[id] = data
+	}
+	return stored, rows.Err()
+}
+
+// joinBoxData serializes box of header and serialized passwords split by
+// splitBoxData
+func joinBoxData(header []byte, passwords []json.RawMessage) ([]byte, error) {
+	// boxes encrypted as a whole are stored as header only
+	if isEncryptedBox(header) {
+		return header, nil
+	}
+	// header is empty for legacy boxes which are a bare array of passwords
+	if len(header) == 0 {
+		return json.Marshal(passwords)
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(header, &fields); err != nil {
+		return nil, err
+	}
+	var err error
+	if fields["Passwords"], err = json.Marshal(passwords); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// splitBoxData splits serialized box into header without passwords and
+// serialized passwords indexed by ID
+func splitBoxData(data []byte) ([]byte, map[string]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	var (
+		header []byte
+		list   []json.RawMessage
+	)
+	if isEncryptedBox(data) {
+		return data, map[string]json.RawMessage{}, nil
+	}
+	if len(data) > 0 && data[0] == '[' {
+		header = []byte{}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, nil, err
+		}
+		if raw, ok := fields["Passwords"]; ok {
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, nil, err
+			}
+			delete(fields, "Passwords")
+		}
+		var err error
+		if header, err = json.Marshal(fields); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	passwords := make(map[string]json.RawMessage, len(list))
+	for _, raw := range list {
+		pw := struct{ ID string }{}
+		if err := json.Unmarshal(raw, &pw); err != nil {
+			return nil, nil, err
+		}
+		compact := bytes.Buffer{}
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, nil, err
+		}
+		passwords[pw.ID] = json.RawMessage(compact.Bytes())
+	}
+	return header, passwords, nil
+}
+
+// canonicalBoxData returns serialized box as it's loaded from an
+// EntryRepository after saved, joined by joinBoxData in order of ID
+func canonicalBoxData(data []byte) ([]byte, error) {
+	header, passwords, err := splitBoxData(data)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(passwords))
+	for id := range passwords {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	list := make([]json.RawMessage, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, passwords[id])
+	}
+	return joinBoxData(header, list)
+}
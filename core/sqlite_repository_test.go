@@ -0,0 +1,184 @@
+package core
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "box.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestEntryRepositoryDigest(t *testing.T) {
+	db := openTestSQLite(t)
+	sqliteRepo, err := NewSQLiteRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		repo BoxRepository
+	}{
+		{"sqlite", sqliteRepo},
+		{"bolt", NewBoltRepository(filepath.Join(t.TempDir(), "box.bolt"))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			box := NewBox(tt.repo)
+			if err := box.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			id, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := box.Add(NewPassword("gitlab", "me", "pw1234567", "gitlab.com")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := box.Remove([]string{id}, false); err != nil {
+				t.Fatal(err)
+			}
+			data, err := tt.repo.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if box.digestOf(data) != box.digest {
+				t.Fatal("digest of loaded box mismatches digest of saved box")
+			}
+
+			other := NewBox(tt.repo)
+			if err := other.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			if other.digest != box.digest {
+				t.Fatal("digest of box loaded mismatches digest of box saved")
+			}
+			if n := other.Count(); n != 1 {
+				t.Fatalf("%d passwords loaded", n)
+			}
+		})
+	}
+}
+
+func TestSQLiteRepositoryMigrate(t *testing.T) {
+	db := openTestSQLite(t)
+	repo, err := NewSQLiteRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// migrating again is a no-op
+	if _, err := NewSQLiteRepository(db); err != nil {
+		t.Fatal(err)
+	}
+	box := NewBox(repo)
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com")); err != nil {
+		t.Fatal(err)
+	}
+	var category string
+	if err := db.QueryRow(`SELECT category FROM onepw_passwords`).Scan(&category); err != nil || category != "github" {
+		t.Fatalf("category column %q, %v", category, err)
+	}
+
+	if _, err := db.Exec(`UPDATE onepw_meta SET value = ? WHERE key = ?`, len(sqliteSchema)+1, sqliteSchemaVersionKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSQLiteRepository(db); err != errSQLiteSchemaTooNew {
+		t.Fatalf("schema too new: %v", err)
+	}
+	// a version which can't be read isn't taken as an empty database
+	if _, err := db.Exec(`UPDATE onepw_meta SET value = 'garbage' WHERE key = ?`, sqliteSchemaVersionKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSQLiteRepository(db); err == nil {
+		t.Fatal("migrated database of unreadable schema version")
+	}
+}
+
+func TestSQLiteRepositoryInMemory(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// each connection has its own in-memory database
+	db.SetMaxOpenConns(1)
+	repo, err := NewSQLiteRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := repo.Load(); err != nil || len(data) != 0 {
+		t.Fatalf("Load of empty database: %q, %v", data, err)
+	}
+	rows := func() map[string]string {
+		t.Helper()
+		stored := map[string]string{}
+		rs, err := db.Query(`SELECT id, data FROM onepw_passwords`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Close()
+		for rs.Next() {
+			var id, data string
+			if err := rs.Scan(&id, &data); err != nil {
+				t.Fatal(err)
+			}
+			stored[id] = data
+		}
+		return stored
+	}
+
+	box := NewBox(repo)
+	if err := box.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	first, _, err := box.Add(NewPassword("github", "me", "pw123456", "github.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := rows()
+	second, _, err := box.Add(NewPassword("gitlab", "me", "pw1234567", "gitlab.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := rows()
+	if len(after) != 2 || after[first] != before[first] {
+		t.Fatalf("rows %d, first row rewritten %v", len(after), after[first] != before[first])
+	}
+
+	if _, err := box.Remove([]string{first}, false); err != nil {
+		t.Fatal(err)
+	}
+	if after := rows(); len(after) != 1 || after[second] == "" {
+		t.Fatalf("rows after removed: %v", after)
+	}
+	reopened := reopen(t, repo, testMasterPassword)
+	if got := getPassword(t, reopened, second); string(got.PlainPassword) != "pw1234567" {
+		t.Fatalf("password %q", got.PlainPassword)
+	}
+	if n := reopened.Count(); n != 1 {
+		t.Fatalf("%d passwords", n)
+	}
+
+	// box encrypted as a whole is stored as header only
+	if err := reopened.SetEncryptFile(true); err != nil {
+		t.Fatal(err)
+	}
+	if after := rows(); len(after) != 0 {
+		t.Fatalf("%d rows of box encrypted as a whole", len(after))
+	}
+	if got := getPassword(t, reopen(t, repo, testMasterPassword), second); string(got.PlainPassword) != "pw1234567" {
+		t.Fatalf("password %q of box encrypted as a whole", got.PlainPassword)
+	}
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SyncChanges lists IDs of passwords changed in one of boxes synced by
+// Box.SyncWith, each sorted
+type SyncChanges struct {
+	// Added are passwords only in the other box, or removed here but
+	// updated there since
+	Added []string
+	// Updated are passwords replaced by newer ones of the other box
+	Updated []string
+	// Deleted are passwords moved to trash, since they were removed in the
+	// other box after updated here
+	Deleted []string
+}
+
+// SyncReport is result of Box.SyncWith, Local is changes of box, Remote is
+// changes of the other box, Conflicts are passwords kept as they were on
+// both sides
+type SyncReport struct {
+	Local     SyncChanges
+	Remote    SyncChanges
+	Conflicts []Conflict
+}
+
+// SyncWith syncs box with box of other both ways, and saves both. Box of
+// other is unlocked by master password of box, it's saved as a copy of box
+// if other is empty. Passwords are matched by ID and resolved by strategy
+// as Merge does. Passwords in trash are tombstones: a removal propagates
+// unless the other side updated the password after it, the later one wins.
+// Trash emptied by EmptyTrash propagates nothing.
+//
+// Both boxes record time of the sync, so a password changed on both sides
+// since the last sync is a conflict by MergeNewest, a password changed on
+// one side only wins whatever the time stamps are. Boxes never synced
+// before are resolved by time stamps only. Other is restored if box can't
+// be saved after other saved, so boxes never diverge by a failed sync.
+func (box *Box) SyncWith(other BoxRepository, strategy MergeStrategy) (SyncReport, error) {
+	var report SyncReport
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return report, err
+	}
+	if err := box.checkWritable(); err != nil {
+		return report, err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	remote := NewBoxWithOptions(other, box.options)
+	remote.masterPassword = box.masterPassword
+	remote.keyfile = box.keyfile
+	remote.pepper = box.pepper
+	remote.response, remote.responseChallenge = box.response, box.responseChallenge
+	unlockRemote, err := remote.lockRepo()
+	if err != nil {
+		return report, err
+	}
+	defer unlockRemote()
+	original, err := other.Load()
+	if err != nil {
+		return report, err
+	}
+	if err := remote.unmarshal(original); err != nil {
+		return report, err
+	}
+	marker := box.syncMarker(remote)
+	if remote.keys == nil {
+		// remote is empty, ciphers of box are copied as they are
+		data, err := box.marshal()
+		if err != nil {
+			return report, err
+		}
+		if err := remote.unmarshal(data); err != nil {
+			return report, err
+		}
+		remote.syncID, remote.syncMarkers = "", nil
+		for _, pw := range box.sortedPasswords() {
+			report.Remote.Added = append(report.Remote.Added, pw.ID)
+		}
+	}
+
+	err = box.withUnsealed(func() error {
+		return remote.withUnsealed(func() error {
+			box.syncPasswords(remote, strategy, marker, &report)
+			if err := box.markSynced(remote); err != nil {
+				return err
+			}
+			if err := remote.save(); err != nil {
+				return err
+			}
+			if err := box.save(); err != nil {
+				if restoreErr := other.Save(original); restoreErr != nil {
+					return fmt.Errorf("%w, synced box not restored: %v", err, restoreErr)
+				}
+				return err
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		// passwords of box are restored as saved
+		if data, loadErr := box.repo.Load(); loadErr == nil {
+			box.replace(data)
+		}
+		return SyncReport{}, err
+	}
+	return report, nil
+}
+
+// syncMarker returns time of the last sync of box and remote, or 0 if they
+// were never synced. The earlier one is returned if they don't agree.
+func (box *Box) syncMarker(remote *Box) int64 {
+	if box.syncID == "" || remote.syncID == "" || box.syncID == remote.syncID {
+		return 0
+	}
+	ours, theirs := box.syncMarkers[remote.syncID], remote.syncMarkers[box.syncID]
+	if theirs < ours {
+		return theirs
+	}
+	return ours
+}
+
+// markSynced records time of sync in box and remote, sync IDs are allocated
+// if they are missing, or same if box file was copied
+func (box *Box) markSynced(remote *Box) error {
+	if box.syncID == "" {
+		id, err := box.allocID()
+		if err != nil {
+			return err
+		}
+		box.syncID = id
+	}
+	if remote.syncID == "" || remote.syncID == box.syncID {
+		id, err := remote.allocID()
+		if err != nil {
+			return err
+		}
+		remote.syncID = id
+	}
+	now := time.Now().Unix()
+	if box.syncMarkers == nil {
+		box.syncMarkers = map[string]int64{}
+	}
+	if remote.syncMarkers == nil {
+		remote.syncMarkers = map[string]int64{}
+	}
+	box.syncMarkers[remote.syncID] = now
+	remote.syncMarkers[box.syncID] = now
+	return nil
+}
+
+// syncPasswords syncs decrypted passwords and trash of box and remote,
+// both must be unsealed, marker is time of their last sync. Copies of
+// tombstones aren't reported.
+func (box *Box) syncPasswords(remote *Box, strategy MergeStrategy, marker int64, report *SyncReport) {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, passwords := range []map[string]*Password{box.passwords, box.trash, remote.passwords, remote.trash} {
+		for id := range passwords {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		ours, oursLive := box.passwords[id]
+		if !oursLive {
+			ours = box.trash[id]
+		}
+		theirs, theirsLive := remote.passwords[id]
+		if !theirsLive {
+			theirs = remote.trash[id]
+		}
+		switch {
+		case ours == nil:
+			box.syncCopy(theirs, theirsLive)
+			if theirsLive {
+				report.Local.Added = append(report.Local.Added, id)
+			}
+		case theirs == nil:
+			remote.syncCopy(ours, oursLive)
+			if oursLive {
+				report.Remote.Added = append(report.Remote.Added, id)
+			}
+		case !oursLive && !theirsLive:
+			// removed on both sides
+		case oursLive && theirsLive:
+			if ours.sameContent(theirs) {
+				continue
+			}
+			takeTheirs, conflict := resolveSync(strategy, marker, ours.LastUpdatedAt, theirs.LastUpdatedAt)
+			switch {
+			case conflict:
+				report.Conflicts = append(report.Conflicts, newConflict(ours, theirs))
+			case takeTheirs:
+				ours.mergeFrom(theirs, box.historyLimit())
+				ours.dirty = true
+				report.Local.Updated = append(report.Local.Updated, id)
+			default:
+				theirs.mergeFrom(ours, remote.historyLimit())
+				theirs.dirty = true
+				report.Remote.Updated = append(report.Remote.Updated, id)
+			}
+		case oursLive:
+			// removed by remote
+			takeTheirs, conflict := resolveSync(strategy, marker, ours.LastUpdatedAt, theirs.DeletedAt)
+			switch {
+			case conflict:
+				report.Conflicts = append(report.Conflicts, newConflict(ours, theirs))
+			case takeTheirs:
+				box.moveToTrash(ours)
+				ours.DeletedAt = theirs.DeletedAt
+				report.Local.Deleted = append(report.Local.Deleted, id)
+			default:
+				theirs.wipe()
+				delete(remote.trash, id)
+				remote.syncCopy(ours, true)
+				report.Remote.Added = append(report.Remote.Added, id)
+			}
+		default:
+			// removed by box
+			takeTheirs, conflict := resolveSync(strategy, marker, ours.DeletedAt, theirs.LastUpdatedAt)
+			switch {
+			case conflict:
+				report.Conflicts = append(report.Conflicts, newConflict(ours, theirs))
+			case takeTheirs:
+				ours.wipe()
+				delete(box.trash, id)
+				box.syncCopy(theirs, true)
+				report.Local.Added = append(report.Local.Added, id)
+			default:
+				remote.moveToTrash(theirs)
+				theirs.DeletedAt = ours.DeletedAt
+				report.Remote.Deleted = append(report.Remote.Deleted, id)
+			}
+		}
+	}
+}
+
+// syncCopy adds a copy of pw to passwords of box, or to trash if !live
+func (box *Box) syncCopy(pw *Password, live bool) {
+	copied := &Password{}
+	copied.ID = pw.ID
+	copied.mergeFrom(pw, box.historyLimit())
+	copied.dirty = true
+	if live {
+		box.passwords[pw.ID] = copied
+		return
+	}
+	copied.DeletedAt = pw.DeletedAt
+	box.trash[pw.ID] = copied
+}
+
+// resolveSync decides whether theirs wins by strategy, ours and theirs are
+// time of the last change of each side, either update or removal, marker
+// is time of the last sync or 0 if never synced
+func resolveSync(strategy MergeStrategy, marker, ours, theirs int64) (takeTheirs, conflict bool) {
+	switch strategy {
+	case MergeNewest:
+		if marker > 0 {
+			oursChanged, theirsChanged := ours > marker, theirs > marker
+			if oursChanged && theirsChanged {
+				return false, true
+			}
+			if oursChanged != theirsChanged {
+				return theirsChanged, false
+			}
+		}
+		return theirs > ours, theirs == ours
+	case MergeTheirs:
+		return true, false
+	case MergeManual:
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+// syncedPair returns box with a password synced to remote, and marker of
+// their sync
+func syncedPair(t *testing.T) (box, remote *Box, remoteRepo *MemoryRepository, id string, marker int64) {
+	t.Helper()
+	box = newTestBox(t)
+	id, _, err := box.Add(NewPassword("email", "me@example.com", "pw123456", "example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteRepo = NewMemoryRepository(nil)
+	report, err := box.SyncWith(remoteRepo, MergeNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Remote.Added) != 1 {
+		t.Fatalf("remote added %v", report.Remote.Added)
+	}
+	remote = NewBox(remoteRepo)
+	if err := remote.Init(testMasterPassword); err != nil {
+		t.Fatal(err)
+	}
+	if box.syncID == "" || remote.syncID == "" || box.syncID == remote.syncID {
+		t.Fatalf("sync IDs %q and %q", box.syncID, remote.syncID)
+	}
+	marker = box.syncMarkers[remote.syncID]
+	if marker == 0 || remote.syncMarkers[box.syncID] != marker {
+		t.Fatalf("markers %v and %v", box.syncMarkers, remote.syncMarkers)
+	}
+	return
+}
+
+// changeAt updates or removes password id of box, or leaves it unchanged
+// if change is empty, then sets time of its last change to at
+func changeAt(t *testing.T, box *Box, id, change string, at int64) {
+	t.Helper()
+	switch change {
+	case "update":
+		passwd := "changed" + box.syncID
+		if err := box.Update(id, PasswordUpdate{Password: &passwd}); err != nil {
+			t.Fatal(err)
+		}
+	case "remove":
+		if _, err := box.Remove([]string{id}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if pw, ok := box.passwords[id]; ok {
+		pw.LastUpdatedAt = at
+	} else {
+		box.trash[id].DeletedAt = at
+	}
+	if err := box.save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncWithMarker(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		local, remote string
+		localAt       int64
+		remoteAt      int64
+		neverSynced   bool
+		conflict      bool
+		localDeleted  bool
+		remoteDeleted bool
+		localUpdated  bool
+		remoteReadded bool
+		localLive     bool
+		remoteLive    bool
+	}{
+		{name: "updated on both sides", local: "update", localAt: 10, remote: "update", remoteAt: 20,
+			conflict: true, localLive: true, remoteLive: true},
+		{name: "updated here removed there", local: "update", localAt: 10, remote: "remove", remoteAt: 20,
+			conflict: true, localLive: true},
+		{name: "removed here updated there", local: "remove", localAt: 20, remote: "update", remoteAt: 10,
+			conflict: true, remoteLive: true},
+		{name: "updated there", localAt: -10, remote: "update", remoteAt: 10,
+			localUpdated: true, localLive: true, remoteLive: true},
+		{name: "removed there", localAt: -10, remote: "remove", remoteAt: 20,
+			localDeleted: true},
+		{name: "removed here", local: "remove", localAt: 10, remoteAt: -10,
+			remoteDeleted: true},
+		{name: "never synced, removal newer", local: "update", localAt: 10, remote: "remove", remoteAt: 20,
+			neverSynced: true, localDeleted: true},
+		{name: "never synced, update newer", local: "update", localAt: 20, remote: "remove", remoteAt: 10,
+			neverSynced: true, remoteReadded: true, localLive: true, remoteLive: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			box, remote, remoteRepo, id, marker := syncedPair(t)
+			if tt.neverSynced {
+				box.syncMarkers, remote.syncMarkers = nil, nil
+			}
+			changeAt(t, box, id, tt.local, marker+tt.localAt)
+			changeAt(t, remote, id, tt.remote, marker+tt.remoteAt)
+
+			report, err := box.SyncWith(remoteRepo, MergeNewest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(report.Conflicts) == 1; got != tt.conflict {
+				t.Fatalf("conflicts %v", report.Conflicts)
+			}
+			if got := len(report.Local.Deleted) == 1; got != tt.localDeleted {
+				t.Fatalf("local deleted %v", report.Local.Deleted)
+			}
+			if got := len(report.Remote.Deleted) == 1; got != tt.remoteDeleted {
+				t.Fatalf("remote deleted %v", report.Remote.Deleted)
+			}
+			if got := len(report.Local.Updated) == 1; got != tt.localUpdated {
+				t.Fatalf("local updated %v", report.Local.Updated)
+			}
+			if got := len(report.Remote.Added) == 1; got != tt.remoteReadded {
+				t.Fatalf("remote added %v", report.Remote.Added)
+			}
+
+			remote = NewBox(remoteRepo)
+			if err := remote.Init(testMasterPassword); err != nil {
+				t.Fatal(err)
+			}
+			if _, live := box.passwords[id]; live != tt.localLive {
+				t.Fatalf("local live %v", live)
+			}
+			if _, live := remote.passwords[id]; live != tt.remoteLive {
+				t.Fatalf("remote live %v", live)
+			}
+			if box.syncMarkers[remote.syncID] < marker || remote.syncMarkers[box.syncID] != box.syncMarkers[remote.syncID] {
+				t.Fatalf("markers not advanced: %v, %v", box.syncMarkers, remote.syncMarkers)
+			}
+		})
+	}
+}
+
+func TestSyncWithRestoresRemote(t *testing.T) {
+	box, remote, remoteRepo, id, marker := syncedPair(t)
+	changeAt(t, remote, id, "update", marker+10)
+	original := remoteRepo.Bytes()
+
+	localRepo := box.repo.(*MemoryRepository)
+	localRepo.FailSaves(1, nil)
+	if _, err := box.SyncWith(remoteRepo, MergeNewest); err == nil {
+		t.Fatal("sync succeeded without saving box")
+	}
+	if !bytes.Equal(remoteRepo.Bytes(), original) {
+		t.Fatal("remote not restored after box failed to be saved")
+	}
+	pw, err := box.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pw.PlainPassword) != "pw123456" {
+		t.Fatalf("box changed by failed sync: %q", pw.PlainPassword)
+	}
+
+	report, err := box.SyncWith(remoteRepo, MergeNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Local.Updated) != 1 {
+		t.Fatalf("local updated %v after retry", report.Local.Updated)
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"bytes"
+)
+
+// challengeLength is length of challenge sent to hardware token,
+// HMAC-SHA1 challenge-response of YubiKey accepts up to 64 bytes
+const challengeLength = 32
+
+// ChallengeResponder computes response of a hardware token for challenge,
+// e.g. HMAC-SHA1 challenge-response of a YubiKey slot
+type ChallengeResponder interface {
+	Respond(challenge []byte) ([]byte, error)
+}
+
+// EnableChallengeResponse makes hardware token required for unlocking box,
+// a new challenge is generated and its response is mixed into master key.
+// ChallengeResponder must be set in options of box.
+func (box *Box) EnableChallengeResponse() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if box.options.ChallengeResponder == nil {
+		return errTokenRequired
+	}
+	// response of token is mixed into keys of all slots
+	if box.passwordSlotCount() > 1 {
+		return errTokenWithKeySlots
+	}
+	challenge, err := randomBytes(challengeLength)
+	if err != nil {
+		return err
+	}
+	return box.rekeyAndSave(box.kdf, func() {
+		box.challenge = challenge
+	})
+}
+
+// DisableChallengeResponse removes hardware token requirement of box and saves box
+func (box *Box) DisableChallengeResponse() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errBoxNotInitialized); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if len(box.challenge) == 0 {
+		return nil
+	}
+	if box.passwordSlotCount() > 1 {
+		return errTokenWithKeySlots
+	}
+	return box.rekeyAndSave(box.kdf, func() {
+		box.challenge = nil
+	})
+}
+
+// tokenResponse returns response of hardware token for challenge of box
+func (box *Box) tokenResponse() ([]byte, error) {
+	if box.response != nil && bytes.Equal(box.responseChallenge, box.challenge) {
+		return box.response, nil
+	}
+	if box.options.ChallengeResponder == nil {
+		return nil, errTokenRequired
+	}
+	response, err := box.options.ChallengeResponder.Respond(box.challenge)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) == 0 {
+		return nil, errEmptyTokenResponse
+	}
+	box.response = response
+	box.responseChallenge = box.challenge
+	return response, nil
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parameters of TOTP codes, the defaults of RFC 6238 used by most sites
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+)
+
+// decodeTOTPSecret decodes base32 secret, spaces, lower case letters and
+// missing padding are accepted as shown by most sites
+func decodeTOTPSecret(secret []byte) ([]byte, error) {
+	s := strings.ToUpper(strings.Replace(string(secret), " ", "", -1))
+	s = strings.TrimRight(s, "=")
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil || len(key) == 0 {
+		return nil, errInvalidTOTPSecret
+	}
+	return key, nil
+}
+
+// totpCode computes TOTP code of secret at t, and remaining time of the code
+func totpCode(secret []byte, t time.Time) (string, time.Duration, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", 0, err
+	}
+	defer Secret(key).Wipe()
+	counter := uint64(t.Unix()) / uint64(totpPeriod/time.Second)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	next := time.Unix(int64(counter+1)*int64(totpPeriod/time.Second), 0)
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), next.Sub(t), nil
+}
+
+// TOTP returns current TOTP code of password id and how long the code
+// remains valid, id may be a prefix of ID as Remove accepts
+func (box *Box) TOTP(id string) (code string, remaining time.Duration, err error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err = box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return
+	}
+	pw, err := box.lookup(id)
+	if err != nil {
+		return
+	}
+	secret := pw.PlainTOTPSecret
+	if pw.sealed {
+		if len(pw.CipherTOTPSecret) == 0 {
+			err = newErrNoTOTPSecret(pw.ID)
+			return
+		}
+		if secret, err = box.keys.open(pw.Scheme, fieldTOTP, pw.TOTPIV, pw.CipherTOTPSecret, pw.additionalData(fieldTOTP)); err != nil {
+			err = wrapErrPassword(pw.ID, err)
+			return
+		}
+		defer secret.Wipe()
+	}
+	if len(secret) == 0 {
+		err = newErrNoTOTPSecret(pw.ID)
+		return
+	}
+	return totpCode(secret, time.Now())
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mkideal/pkg/textutil"
+)
+
+// moveToTrash moves pw from passwords to trash of box, its ciphers are kept
+func (box *Box) moveToTrash(pw *Password) {
+	delete(box.passwords, pw.ID)
+	pw.DeletedAt = time.Now().Unix()
+	box.trash[pw.ID] = pw
+}
+
+// restoreFromTrash moves pw from trash back to passwords of box
+func (box *Box) restoreFromTrash(pw *Password) {
+	delete(box.trash, pw.ID)
+	pw.DeletedAt = 0
+	box.passwords[pw.ID] = pw
+}
+
+// restoreAllFromTrash moves pws from trash back to passwords of box, it
+// rolls back removal of pws if box fails to be saved
+func (box *Box) restoreAllFromTrash(pws []*Password) {
+	for _, pw := range pws {
+		box.restoreFromTrash(pw)
+	}
+}
+
+// allPasswords returns passwords and trash of box, for encrypting,
+// decrypting and wiping both alike
+func (box *Box) allPasswords() []*Password {
+	all := make([]*Password, 0, len(box.passwords)+len(box.trash))
+	for _, pw := range box.passwords {
+		all = append(all, pw)
+	}
+	for _, pw := range box.trash {
+		all = append(all, pw)
+	}
+	return all
+}
+
+// sortedTrash returns copies of passwords in trash sorted by ID
+func (box *Box) sortedTrash() []Password {
+	trash := make([]Password, 0, len(box.trash))
+	for _, pw := range box.trash {
+		trash = append(trash, *pw)
+	}
+	sort.Stable(passwordSlice(trash))
+	return trash
+}
+
+// lookupTrash returns password in trash whose ID is id, or the only one
+// whose ID has prefix id
+func (box *Box) lookupTrash(id string) (*Password, error) {
+	if pw, ok := box.trash[id]; ok {
+		return pw, nil
+	}
+	found := []*Password{}
+	for _, pw := range box.trash {
+		if strings.HasPrefix(pw.ID, id) {
+			found = append(found, pw)
+		}
+	}
+	switch {
+	case id == "" || len(found) == 0:
+		return nil, newErrPasswordNotFound(id)
+	case len(found) > 1:
+		return nil, newErrAmbiguous(found)
+	}
+	return found[0], nil
+}
+
+// Restore moves password id back from trash and saves box, id may be a
+// prefix of ID as Remove accepts
+func (box *Box) Restore(id string) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.checkWritable(); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	pw, err := box.lookupTrash(id)
+	if err != nil {
+		return err
+	}
+	box.restoreFromTrash(pw)
+	return box.saveOne(pw.ID)
+}
+
+// EmptyTrash removes passwords in trash for good and saves box
+func (box *Box) EmptyTrash() error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.checkWritable(); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if len(box.trash) == 0 {
+		return nil
+	}
+	for id, pw := range box.trash {
+		pw.wipe()
+		delete(box.trash, id)
+	}
+	return box.save()
+}
+
+// ListTrash writes passwords in trash to specified writer, the latest
+// removed first
+func (box *Box) ListTrash(w io.Writer, noHeader bool) error {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	trash := box.sortedTrash()
+	sort.SliceStable(trash, func(i, j int) bool {
+		return trash[i].DeletedAt > trash[j].DeletedAt
+	})
+	wipe, err := box.reveal(trash)
+	if err != nil {
+		return err
+	}
+	defer wipe()
+	var table textutil.Table
+	table = trashSlice(trash)
+	if !noHeader {
+		table = textutil.AddTableHeader(table, trashHeader)
+	}
+	textutil.WriteTable(w, table)
+	return nil
+}
+
+// trashSlice is passwordSlice with time removed column
+type trashSlice []Password
+
+var trashHeader = append(append([]string{}, passwordHeader...), "DELETED_AT")
+
+func (ps trashSlice) RowCount() int { return len(ps) }
+func (ps trashSlice) ColCount() int {
+	if len(ps) == 0 {
+		return 0
+	}
+	return ps[0].colCount() + 1
+}
+func (ps trashSlice) Get(i, j int) string {
+	if j == ps[i].colCount() {
+		return time.Unix(ps[i].DeletedAt, 0).Format(time.RFC3339)
+	}
+	return ps[i].get(j)
+}
@@ -0,0 +1,114 @@
+package core
+
+import "time"
+
+// PasswordUpdate holds changes of a password applied by Box.Update, nil
+// fields are left unchanged
+type PasswordUpdate struct {
+	Category   *string
+	Account    *string
+	Password   *string
+	Notes      *string
+	TOTPSecret *string
+	Site       *string
+	URL        *string
+	Tags       *[]string
+	ExpiresAt  *int64
+}
+
+// Update applies changes to password id and saves box, id may be a prefix
+// of ID as Remove accepts. The replaced password is kept in history. A
+// derived password becomes a stored one if its password is changed, or
+// if its account or site is changed, so it keeps the password derived.
+func (box *Box) Update(id string, changes PasswordUpdate) error {
+	if changes.TOTPSecret != nil && *changes.TOTPSecret != "" {
+		key, err := decodeTOTPSecret(Secret(*changes.TOTPSecret))
+		if err != nil {
+			return err
+		}
+		Secret(key).Wipe()
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return err
+	}
+	if err := box.checkWritable(); err != nil {
+		return err
+	}
+	unlock, err := box.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	pw, err := box.lookup(id)
+	if err != nil {
+		return err
+	}
+	if pw.sealed {
+		if err := box.keys.decrypt(pw); err != nil {
+			return err
+		}
+	}
+	changes.apply(pw, box.historyLimit())
+	pw.LastUpdatedAt = time.Now().Unix()
+	if err := box.encrypt(pw); err != nil {
+		return err
+	}
+	return box.saveOne(pw.ID)
+}
+
+// apply sets non-nil fields of changes to pw, the replaced password is
+// pushed onto history of pw which keeps at most historyLimit entries
+func (changes PasswordUpdate) apply(pw *Password, historyLimit int) {
+	if pw.Derived && changes.changesDeriveInput(pw) {
+		// derived password is stored, it would change with account or site
+		pw.Derived = false
+		pw.DeriveLength, pw.DeriveCharset, pw.DeriveCounter = 0, "", 0
+	}
+	if changes.Category != nil {
+		pw.Category = *changes.Category
+	}
+	if changes.Account != nil {
+		pw.PlainAccount.Wipe()
+		pw.PlainAccount = Secret(*changes.Account)
+	}
+	if changes.Password != nil {
+		passwd := Secret(*changes.Password)
+		if pw.Derived {
+			pw.Derived = false
+			pw.DeriveLength, pw.DeriveCharset, pw.DeriveCounter = 0, "", 0
+		} else {
+			pw.pushHistory(passwd, historyLimit)
+		}
+		pw.PlainPassword.Wipe()
+		pw.PlainPassword = passwd
+	}
+	if changes.Notes != nil {
+		pw.PlainNotes.Wipe()
+		pw.PlainNotes = Secret(*changes.Notes)
+	}
+	if changes.TOTPSecret != nil {
+		pw.PlainTOTPSecret.Wipe()
+		pw.PlainTOTPSecret = Secret(*changes.TOTPSecret)
+	}
+	if changes.Site != nil {
+		pw.Site = *changes.Site
+	}
+	if changes.URL != nil {
+		pw.URL = *changes.URL
+	}
+	if changes.Tags != nil {
+		pw.Tags = append([]string{}, *changes.Tags...)
+	}
+	if changes.ExpiresAt != nil {
+		pw.ExpiresAt = *changes.ExpiresAt
+	}
+}
+
+// changesDeriveInput reports whether changes modify account or site which
+// password of pw is derived from
+func (changes PasswordUpdate) changesDeriveInput(pw *Password) bool {
+	return changes.Account != nil && *changes.Account != string(pw.PlainAccount) ||
+		changes.Site != nil && *changes.Site != pw.Site
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Problem is a failure found by Verify, ID is empty for problems of box
+// itself
+type Problem struct {
+	ID  string
+	Err error
+}
+
+// String returns problem as a line of report
+func (p Problem) String() string {
+	if p.ID == "" {
+		return p.Err.Error()
+	}
+	return p.ID + ": " + p.Err.Error()
+}
+
+// Report is result of Verify
+type Report struct {
+	// Number of entries checked
+	Entries int
+
+	// Problems found, an entry may have several problems
+	Problems []Problem
+}
+
+// OK reports whether no problem was found
+func (r Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) add(id string, err error) {
+	// ids of errors wrapped by wrapErrPassword are reported by Problem
+	if inner := errors.Unwrap(err); inner != nil && id != "" {
+		err = inner
+	}
+	r.Problems = append(r.Problems, Problem{ID: id, Err: err})
+}
+
+// Verify checks that every entry in repository of box, passwords in trash
+// included, decrypts cleanly. IVs, ciphers, UTF-8 of plaintext and uniqueness of IDs are checked, all
+// problems are reported rather than stopping on the first one. An error
+// is returned only if the box couldn't be read at all.
+func (box *Box) Verify() (Report, error) {
+	box.mu.RLock()
+	defer box.mu.RUnlock()
+	report := Report{}
+	if err := box.checkUnlocked(errEmptyMasterPassword); err != nil {
+		return report, err
+	}
+	data, err := box.repo.Load()
+	if err != nil {
+		return report, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return report, nil
+	}
+	if isEncryptedBox(data) {
+		eb, err := parseEncryptedBox(data)
+		if err != nil {
+			return report, err
+		}
+		ad, err := eb.additionalData()
+		if err != nil {
+			return report, err
+		}
+		if data, err = box.keys.open(CipherAESGCM, "", eb.IV, eb.Data, ad); err != nil {
+			return report, err
+		}
+		defer Secret(data).Wipe()
+	}
+	bd, err := parseBoxData(data)
+	if err != nil {
+		return report, err
+	}
+	keys, err := newBoxKeys(box.keys.master, bd.Version, box.keySize)
+	if err != nil {
+		return report, err
+	}
+	if len(bd.MAC) > 0 {
+		macdata, err := bd.macData()
+		if err != nil {
+			return report, err
+		}
+		if !keys.checkMAC(macdata, bd.MAC) {
+			report.add("", errBoxTampered)
+		}
+	}
+
+	// passwords in trash are checked too, since they are brought back by
+	// Restore, IDs must be unique across both
+	all := make([]*Password, 0, len(bd.Passwords)+len(bd.Trash))
+	for i := range bd.Passwords {
+		all = append(all, &bd.Passwords[i])
+	}
+	for i := range bd.Trash {
+		all = append(all, &bd.Trash[i])
+	}
+	seen := map[string]bool{}
+	for _, pw := range all {
+		report.Entries++
+		if seen[pw.ID] {
+			report.add(pw.ID, errDuplicateID)
+		}
+		seen[pw.ID] = true
+		if err := keys.checkIVs(pw); err != nil {
+			report.add(pw.ID, err)
+			continue
+		}
+		if err := keys.decryptMetadata(pw); err != nil {
+			report.add(pw.ID, err)
+			continue
+		}
+		if err := keys.decrypt(pw); err != nil {
+			report.add(pw.ID, err)
+			continue
+		}
+		if err := pw.checkUTF8(); err != nil {
+			report.add(pw.ID, err)
+		}
+		pw.wipe()
+	}
+	return report, nil
+}
+
+// checkIVs checks lengths of IVs of encrypted fields of pw
+func (keys *boxKeys) checkIVs(pw *Password) error {
+	check := func(field string, iv []byte) error {
+		size := keys.legacyBlock.BlockSize()
+		if pw.Scheme != "" && pw.Scheme != schemeCFB {
+			aead, err := keys.aead(pw.Scheme, field)
+			if err != nil {
+				return err
+			}
+			size = aead.NonceSize()
+		}
+		if len(iv) != size {
+			return newErrIVLength(field, len(iv), size)
+		}
+		return nil
+	}
+	if err := check(fieldAccount, pw.AccountIV); err != nil {
+		return err
+	}
+	if !pw.Derived {
+		if err := check(fieldPassword, pw.PasswordIV); err != nil {
+			return err
+		}
+	}
+	optional := []struct {
+		field      string
+		iv, cipher []byte
+	}{
+		{fieldNotes, pw.NotesIV, pw.CipherNotes},
+		{fieldTOTP, pw.TOTPIV, pw.CipherTOTPSecret},
+		{fieldCustom, pw.CustomFieldsIV, pw.CipherCustomFields},
+		{fieldMetadata, pw.MetadataIV, pw.CipherMetadata},
+	}
+	for _, o := range optional {
+		if len(o.cipher) == 0 {
+			continue
+		}
+		if err := check(o.field, o.iv); err != nil {
+			return err
+		}
+	}
+	for _, h := range pw.History {
+		if err := check(fieldHistory, h.IV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUTF8 checks that decrypted plaintext of pw is valid UTF-8
+func (pw *Password) checkUTF8() error {
+	fields := []struct {
+		field string
+		value []byte
+	}{
+		{fieldAccount, pw.PlainAccount},
+		{fieldPassword, pw.PlainPassword},
+		{fieldNotes, pw.PlainNotes},
+		{fieldTOTP, pw.PlainTOTPSecret},
+	}
+	for _, h := range pw.History {
+		fields = append(fields, struct {
+			field string
+			value []byte
+		}{fieldHistory, h.PlainPassword})
+	}
+	for _, f := range fields {
+		if !utf8.Valid(f.value) {
+			return newErrInvalidUTF8(f.field)
+		}
+	}
+	for k, v := range pw.CustomFields {
+		if !utf8.ValidString(v) {
+			return newErrInvalidUTF8(fmt.Sprintf("%s %s", fieldCustom, k))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"testing"
+)
+
+// problemsOf returns errors of problems in report keyed by ID
+func problemsOf(report Report) map[string][]error {
+	problems := map[string][]error{}
+	for _, p := range report.Problems {
+		problems[p.ID] = append(problems[p.ID], p.Err)
+	}
+	return problems
+}
+
+func TestVerifyTrash(t *testing.T) {
+	box := newTestBox(t)
+	ids, err := box.AddBatch([]*Password{
+		NewPassword("github", "me", "pw123456", "github.com"),
+		NewPassword("gitlab", "you", "pw1234567", "gitlab.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := box.Remove([]string{ids[1]}, false); err != nil {
+		t.Fatal(err)
+	}
+	report, err := box.Verify()
+	if err != nil || !report.OK() || report.Entries != 2 {
+		t.Fatalf("Verify: %+v, %v", report, err)
+	}
+	repo := box.repo.(*MemoryRepository)
+	saved := repo.Bytes()
+
+	// cipher of password in trash tampered
+	editSaved(t, repo, func(bd map[string]interface{}) {
+		pw := bd["Trash"].([]interface{})[0].(map[string]interface{})
+		pw["CipherPassword"] = flipBase64(t, pw["CipherPassword"])
+	})
+	report, err = box.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := problemsOf(report); len(problems[ids[1]]) == 0 || len(problems[ids[0]]) != 0 {
+		t.Fatalf("problems: %v", report.Problems)
+	}
+
+	// password in trash has ID of a live one
+	if err := repo.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	editSaved(t, repo, func(bd map[string]interface{}) {
+		bd["Trash"] = append(bd["Trash"].([]interface{}), savedPasswords(bd)[0])
+	})
+	report, err = box.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Entries != 3 {
+		t.Fatalf("%d entries checked", report.Entries)
+	}
+	duplicate := false
+	for _, err := range problemsOf(report)[ids[0]] {
+		duplicate = duplicate || err == errDuplicateID
+	}
+	if !duplicate {
+		t.Fatalf("duplicate ID not reported: %v", report.Problems)
+	}
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+)
+
+// VersionedRepository wraps a BackupRepository, previous box is copied to
+// a numbered backup before every Save. Backup 1 is the most recent one.
+type VersionedRepository struct {
+	inner BoxRepository
+	keep  int
+}
+
+// NewVersionedRepository creates a VersionedRepository which keeps the
+// most recent keep backups of inner. Saving fails if inner doesn't
+// implement BackupRepository.
+func NewVersionedRepository(inner BoxRepository, keep int) *VersionedRepository {
+	return &VersionedRepository{
+		inner: inner,
+		keep:  keep,
+	}
+}
+
+// Load implements BoxRepository.Load method
+func (repo *VersionedRepository) Load() ([]byte, error) {
+	return repo.inner.Load()
+}
+
+// Save implements BoxRepository.Save method, backups are rotated before
+// data is saved to inner repository
+func (repo *VersionedRepository) Save(data []byte) error {
+	inner, ok := repo.inner.(BackupRepository)
+	if !ok {
+		return errBackupUnsupported
+	}
+	old, err := inner.Load()
+	if err != nil {
+		return err
+	}
+	if len(old) > 0 && repo.keep > 0 && !bytes.Equal(old, data) {
+		if err := repo.rotate(inner, old); err != nil {
+			return err
+		}
+	}
+	return inner.Save(data)
+}
+
+func (repo *VersionedRepository) rotate(inner BackupRepository, old []byte) error {
+	for n := repo.keep - 1; n >= 1; n-- {
+		backup, err := inner.Backup(n).Load()
+		if err != nil {
+			return err
+		}
+		if len(backup) == 0 {
+			continue
+		}
+		if err := inner.Backup(n + 1).Save(backup); err != nil {
+			return err
+		}
+	}
+	return inner.Backup(1).Save(old)
+}
+
+// Backups returns numbers of existing backups, the most recent first
+func (repo *VersionedRepository) Backups() ([]int, error) {
+	inner, ok := repo.inner.(BackupRepository)
+	if !ok {
+		return nil, errBackupUnsupported
+	}
+	backups := []int{}
+	for n := 1; n <= repo.keep; n++ {
+		data, err := inner.Backup(n).Load()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			backups = append(backups, n)
+		}
+	}
+	return backups, nil
+}
+
+// Restore restores n-th backup, current box is backed up before restored
+func (repo *VersionedRepository) Restore(n int) error {
+	inner, ok := repo.inner.(BackupRepository)
+	if !ok {
+		return errBackupUnsupported
+	}
+	if n < 1 || n > repo.keep {
+		return newErrBackupNotFound(n)
+	}
+	data, err := inner.Backup(n).Load()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return newErrBackupNotFound(n)
+	}
+	return repo.Save(data)
+}
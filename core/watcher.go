@@ -0,0 +1,95 @@
+package core
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay is how long Watcher waits for more changes before reloading,
+// syncing tools usually write a file in several steps
+const watchDelay = 200 * time.Millisecond
+
+// Watcher reloads box whenever its file is changed by others
+type Watcher struct {
+	box      *Box
+	filename string
+	watcher  *fsnotify.Watcher
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	// OnError is called if box failed to be reloaded, errors are ignored if nil
+	OnError func(error)
+}
+
+// WatchFile watches filename which is the file of box, box is reloaded
+// when the file changed. Directory of the file is watched, so files
+// replaced by rename, e.g. by FileRepository, are watched too.
+func WatchFile(box *Box, filename string) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	filename = filepath.Clean(filename)
+	if err := watcher.Add(filepath.Dir(filename)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	w := &Watcher{
+		box:      box,
+		filename: filename,
+		watcher:  watcher,
+		done:     make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	close(w.done)
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return err
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != w.filename {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer = time.After(watchDelay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.error(err)
+		case <-timer:
+			timer = nil
+			// saves of box itself are skipped by Reload
+			if err := w.box.Reload(); err != nil {
+				w.error(err)
+			}
+		}
+	}
+}
+
+func (w *Watcher) error(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
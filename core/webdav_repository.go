@@ -0,0 +1,253 @@
+package core
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWebDAVTimeout is timeout of requests to WebDAV server
+const DefaultWebDAVTimeout = 30 * time.Second
+
+// WebDAVError is returned by WebDAVRepository if server rejected a request
+// by status 401, 403 or 404. Err tells which, e.g. a wrong password.
+type WebDAVError struct {
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+// Error implements error interface
+func (err *WebDAVError) Error() string {
+	return fmt.Sprintf("%s: %v", err.URL, err.Err)
+}
+
+// Unwrap returns error of status code
+func (err *WebDAVError) Unwrap() error {
+	return err.Err
+}
+
+// WebDAVConflictError is returned by WebDAVRepository.Save if box was saved
+// by others since loaded. Box of server isn't changed, it should be
+// reloaded and changes made again.
+type WebDAVConflictError struct {
+	URL string
+}
+
+// Error implements error interface
+func (err *WebDAVConflictError) Error() string {
+	return fmt.Sprintf("box %s was saved by others since loaded, reload it and try again", err.URL)
+}
+
+// WebDAVRepository implements BoxRepository interface, box is stored as a
+// file of WebDAV server, e.g. Nextcloud. Saving is conditional on ETag of
+// the file loaded, and missing parent collections are created.
+type WebDAVRepository struct {
+	// URL of box file, e.g.
+	// https://cloud.example.com/remote.php/dav/files/user/onepw/password.data
+	URL string
+
+	// Basic auth, used if Token is empty
+	Username string
+	Password string
+
+	// Token of bearer auth, e.g. an app password of Nextcloud
+	Token string
+
+	// Insecure skips verification of TLS certificate of server, it should
+	// only be set for servers of self-signed certificates on trusted networks
+	Insecure bool
+
+	// Client sends requests if not nil, Insecure is ignored then
+	Client *http.Client
+
+	mu     sync.Mutex
+	loaded bool
+	etag   string
+}
+
+// NewWebDAVRepository creates a WebDAVRepository, box is stored as rawURL
+func NewWebDAVRepository(rawURL, username, password string) *WebDAVRepository {
+	return &WebDAVRepository{
+		URL:      rawURL,
+		Username: username,
+		Password: password,
+	}
+}
+
+// Load implements BoxRepository.Load method, empty data returned if file not exist
+func (repo *WebDAVRepository) Load() ([]byte, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	resp, err := repo.do(http.MethodGet, repo.URL, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		repo.loaded, repo.etag = true, ""
+		return []byte{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, repo.statusError(repo.URL, resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	repo.loaded, repo.etag = true, resp.Header.Get("ETag")
+	return data, nil
+}
+
+// Save implements BoxRepository.Save method. A *WebDAVConflictError is
+// returned if file was changed since the last Load or Save, file is
+// overwritten unconditionally if it was never loaded.
+func (repo *WebDAVRepository) Save(data []byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	header := http.Header{}
+	if repo.loaded {
+		if repo.etag == "" {
+			header.Set("If-None-Match", "*")
+		} else {
+			header.Set("If-Match", repo.etag)
+		}
+	}
+	resp, err := repo.put(data, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict {
+		// RFC 4918 responds 409 if parent collection is missing
+		if err := repo.mkcol(parentURL(repo.URL)); err != nil {
+			return err
+		}
+		if resp, err = repo.put(data, header); err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		return &WebDAVConflictError{URL: repo.URL}
+	default:
+		return repo.statusError(repo.URL, resp)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		// some servers respond no ETag to PUT
+		if etag, err = repo.headETag(); err != nil {
+			return err
+		}
+	}
+	repo.loaded, repo.etag = true, etag
+	return nil
+}
+
+func (repo *WebDAVRepository) put(data []byte, header http.Header) (*http.Response, error) {
+	return repo.do(http.MethodPut, repo.URL, bytes.NewReader(data), header)
+}
+
+func (repo *WebDAVRepository) headETag() (string, error) {
+	resp, err := repo.do(http.MethodHead, repo.URL, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", repo.statusError(repo.URL, resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// mkcol creates collection rawURL, its missing parents are created first
+func (repo *WebDAVRepository) mkcol(rawURL string) error {
+	resp, err := repo.do("MKCOL", rawURL, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusMethodNotAllowed:
+		// 405 if collection exists
+		return nil
+	case http.StatusConflict:
+		parent := parentURL(rawURL)
+		if parent == rawURL {
+			return repo.statusError(rawURL, resp)
+		}
+		if err := repo.mkcol(parent); err != nil {
+			return err
+		}
+		return repo.mkcol(rawURL)
+	}
+	return repo.statusError(rawURL, resp)
+}
+
+func (repo *WebDAVRepository) do(method, rawURL string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if repo.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+repo.Token)
+	} else if repo.Username != "" {
+		req.SetBasicAuth(repo.Username, repo.Password)
+	}
+	return repo.client().Do(req)
+}
+
+func (repo *WebDAVRepository) client() *http.Client {
+	if repo.Client != nil {
+		return repo.Client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if repo.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	repo.Client = &http.Client{Transport: transport, Timeout: DefaultWebDAVTimeout}
+	return repo.Client
+}
+
+func (repo *WebDAVRepository) statusError(rawURL string, resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return &WebDAVError{URL: rawURL, StatusCode: resp.StatusCode, Err: errWebDAVUnauthorized}
+	case http.StatusForbidden:
+		return &WebDAVError{URL: rawURL, StatusCode: resp.StatusCode, Err: errWebDAVForbidden}
+	case http.StatusNotFound, http.StatusConflict:
+		return &WebDAVError{URL: rawURL, StatusCode: resp.StatusCode, Err: errWebDAVNotFound}
+	}
+	return fmt.Errorf("%s %s: %s", resp.Request.Method, rawURL, resp.Status)
+}
+
+// parentURL returns URL of collection containing rawURL, rawURL itself if
+// it's the root
+func parentURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	if p == "" {
+		return rawURL
+	}
+	u.Path = path.Dir(p) + "/"
+	if u.Path == "//" {
+		u.Path = "/"
+	}
+	u.RawPath = ""
+	return u.String()
+}
@@ -1,14 +1,26 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/labstack/gommon/color"
 	"github.com/mkideal/cli"
 	"github.com/mkideal/onepw/core"
 	"github.com/mkideal/pkg/textutil"
+	"golang.org/x/term"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -17,16 +29,55 @@ func main() {
 		cli.Tree(help),
 		cli.Tree(version),
 		cli.Tree(initCmd),
+		cli.Tree(passwd),
+		cli.Tree(recoverCmd),
+		cli.Tree(keyfile),
+		cli.Tree(lock),
 		cli.Tree(add),
 		cli.Tree(remove),
+		cli.Tree(trash),
 		cli.Tree(list),
 		cli.Tree(find),
+		cli.Tree(categories),
+		cli.Tree(history),
+		cli.Tree(totp),
+		cli.Tree(copyCmd),
+		cli.Tree(reveal),
+		cli.Tree(export),
+		cli.Tree(importCmd),
+		cli.Tree(syncCmd),
+		cli.Tree(field),
+		cli.Tree(generate),
+		cli.Tree(rotate),
+		cli.Tree(verify),
+		cli.Tree(rekey),
+		cli.Tree(prune),
+		cli.Tree(restore),
+		cli.Tree(convert),
+		cli.Tree(audit),
+		cli.Tree(status),
+		cli.Tree(agentCmd),
+		cli.Tree(share),
+		cli.Tree(accept),
 	).Run(os.Args[1:]); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		printError(err)
 		os.Exit(1)
 	}
 }
 
+func printError(err error) {
+	var weak *core.WeakMasterPasswordError
+	if errors.As(err, &weak) {
+		fmt.Fprintln(os.Stderr, "master password is too weak:")
+		for _, reason := range weak.Reasons {
+			fmt.Fprintf(os.Stderr, "  - %s\n", reason)
+		}
+		fmt.Fprintln(os.Stderr, "use --allow-weak to accept it anyway")
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
+
 //--------
 // Config
 //--------
@@ -35,11 +86,20 @@ func main() {
 type Configure interface {
 	Filename() string
 	MasterPassword() string
+	Keyfile() string
+	IgnoreMAC() bool
+	AllowWeak() bool
+	CacheMasterPassword() bool
+	SetMasterPassword(string)
 }
 
 // Config implementes Configure interface, represents onepw config
 type Config struct {
-	Master string `pw:"master" usage:"master password" dft:"$PASSWORD_MASTER" prompt:"type the master password"`
+	Master  string `pw:"master" usage:"master password, prompted if not set or cached" dft:"$PASSWORD_MASTER"`
+	Key     string `cli:"keyfile" usage:"keyfile for unlocking box which requires a keyfile"`
+	SkipMAC bool   `cli:"ignore-mac" usage:"load box even if it's MAC mismatch, for recovering" dft:"false"`
+	Weak    bool   `cli:"allow-weak" usage:"accept a weak master password for new box or new master password" dft:"false"`
+	Cache   bool   `cli:"cache" usage:"cache master password in keychain of OS, cleared by lock command" dft:"false"`
 }
 
 // Filename returns password data filename
@@ -52,8 +112,49 @@ func (cfg Config) MasterPassword() string {
 	return cfg.Master
 }
 
+// SetMasterPassword sets master password read from cache or prompt
+func (cfg *Config) SetMasterPassword(master string) {
+	cfg.Master = master
+}
+
+// CacheMasterPassword reports whether master password is cached in keychain of OS
+func (cfg Config) CacheMasterPassword() bool {
+	return cfg.Cache
+}
+
+// Keyfile returns keyfile filename
+func (cfg Config) Keyfile() string {
+	return cfg.Key
+}
+
+// IgnoreMAC reports whether MAC mismatch of box should be ignored
+func (cfg Config) IgnoreMAC() bool {
+	return cfg.SkipMAC
+}
+
+// AllowWeak reports whether a weak master password is accepted
+func (cfg Config) AllowWeak() bool {
+	return cfg.Weak
+}
+
 var box *core.Box
 
+// agent is client of agent serving box, commands use it instead of box if
+// it's not nil
+var agent *core.AgentClient
+
+// agentUser is implemented by arguments of commands which can be served by agent
+type agentUser interface {
+	UseAgent() bool
+}
+
+// agentSocket returns path of socket of agent serving box file filename
+func agentSocket(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	// socket is in a directory accessible by owner only
+	return filepath.Join(abs+".agent.d", "agent.sock"), err
+}
+
 //--------------
 // root command
 //--------------
@@ -109,10 +210,64 @@ var root = &cli.Command{
 	OnRootBefore: func(ctx *cli.Context) error {
 		if argv := ctx.Argv(); argv != nil {
 			if t, ok := argv.(Configure); ok {
+				if u, ok := argv.(agentUser); ok && u.UseAgent() {
+					if path, err := agentSocket(t.Filename()); err == nil {
+						if client, err := core.DialAgent(path); err == nil {
+							agent = client
+							return nil
+						}
+					}
+				}
 				repo := core.NewFileRepository(t.Filename())
-				box = core.NewBox(repo)
+				opts := core.Options{IgnoreMAC: t.IgnoreMAC()}
+				if t.AllowWeak() {
+					policy := core.DefaultMasterPasswordPolicy()
+					policy.AllowWeak = true
+					opts.MasterPasswordPolicy = &policy
+				}
+				if t.Keyfile() != "" {
+					keyfile, err := ioutil.ReadFile(t.Keyfile())
+					if err != nil {
+						return err
+					}
+					opts.Keyfile = keyfile
+				}
+				box = core.NewBoxWithOptions(repo, opts)
+				cache := core.NewKeyringCache()
+				cacheKey, err := credentialKey(t.Filename())
+				if err != nil {
+					return err
+				}
+				cached := false
+				if t.MasterPassword() == "" && t.CacheMasterPassword() {
+					master, err := cache.Get(cacheKey)
+					if err != nil {
+						return err
+					}
+					t.SetMasterPassword(string(master))
+					cached = len(master) > 0
+				}
+				if t.MasterPassword() == "" {
+					master, err := promptMasterPassword()
+					if err != nil {
+						return err
+					}
+					t.SetMasterPassword(master)
+				}
 				if t.MasterPassword() != "" {
-					return box.Init(t.MasterPassword())
+					if err := box.Init(t.MasterPassword()); err != nil {
+						if cached {
+							// master password was changed by others
+							cache.Delete(cacheKey)
+						}
+						if hint := box.Hint(); hint != "" {
+							return fmt.Errorf("%v\nhint: %s", err, hint)
+						}
+						return err
+					}
+					if t.CacheMasterPassword() && !cached {
+						return cache.Put(cacheKey, []byte(t.MasterPassword()))
+					}
 				}
 				return nil
 			}
@@ -125,6 +280,22 @@ var root = &cli.Command{
 	},
 }
 
+// credentialKey returns key of master password of box file in keychain
+func credentialKey(filename string) (string, error) {
+	return filepath.Abs(filename)
+}
+
+func promptMasterPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", nil
+	}
+	fmt.Fprint(os.Stderr, "type the master password: ")
+	master, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(master), err
+}
+
 //--------------
 // help command
 //--------------
@@ -155,12 +326,25 @@ type initT struct {
 	cli.Helper
 	Config
 	NewMaster string `cli:"new-master" usage:"new master password"`
+	KeySize   int    `cli:"key-size" usage:"key size of AES in bits, 128 or 256"`
+	Cipher    string `cli:"cipher" usage:"cipher of passwords, aes-gcm or chacha20-poly1305"`
+	Hint      string `cli:"hint" usage:"hint of master password, stored in plaintext"`
+	ClearHint bool   `cli:"clear-hint" usage:"clear hint of master password" dft:"false"`
+	Pepper    string `cli:"pepper" usage:"set or change pepper, it's read from environment variable ONEPW_PEPPER later"`
+	NoPepper  bool   `cli:"remove-pepper" usage:"remove pepper requirement" dft:"false"`
+	Recovery  bool   `cli:"recovery" usage:"generate a recovery phrase which unlocks box if master password is forgotten" dft:"false"`
 }
 
 func (argv *initT) Validate(ctx *cli.Context) error {
 	if argv.Filename() == "" {
 		return fmt.Errorf("FILE is empty")
 	}
+	if argv.Hint != "" && argv.ClearHint {
+		return fmt.Errorf("--hint and --clear-hint are exclusive")
+	}
+	if argv.Pepper != "" && argv.NoPepper {
+		return fmt.Errorf("--pepper and --remove-pepper are exclusive")
+	}
 	return nil
 }
 
@@ -189,13 +373,235 @@ var initCmd = &cli.Command{
 
 	Fn: func(ctx *cli.Context) error {
 		argv := ctx.Argv().(*initT)
+		if argv.Hint != "" || argv.ClearHint {
+			if err := box.SetHint(argv.Hint); err != nil {
+				return err
+			}
+		}
+		if argv.Cipher != "" {
+			if err := box.ChangeCipher(argv.Cipher); err != nil {
+				return err
+			}
+		}
+		if argv.KeySize != 0 && argv.NewMaster == "" {
+			if err := box.ChangeKeySize(argv.KeySize); err != nil {
+				return err
+			}
+		}
+		if argv.Pepper != "" {
+			if err := box.SetPepper([]byte(argv.Pepper)); err != nil {
+				return err
+			}
+		} else if argv.NoPepper {
+			if err := box.RemovePepper(); err != nil {
+				return err
+			}
+		}
+		if argv.Recovery {
+			phrase, err := box.GenerateRecoveryPhrase()
+			if err != nil {
+				return err
+			}
+			ctx.String("recovery phrase, write it down and keep it offline:\n%s\n", phrase)
+		}
 		if argv.NewMaster != "" {
-			return box.Init(argv.NewMaster)
+			// key size is changed by the same re-key
+			if err := box.ChangeMasterPassword(argv.MasterPassword(), argv.NewMaster, argv.KeySize); err != nil {
+				return err
+			}
+			return forgetMasterPassword(argv.Filename())
+		}
+		return nil
+	},
+}
+
+//----------------
+// passwd command
+//----------------
+
+type passwdT struct {
+	cli.Helper
+	Config
+	NewMaster     string `pw:"new-master" usage:"new master password" prompt:"type the new master password"`
+	ConfirmMaster string `pw:"confirm-master" usage:"confirm new master password" prompt:"repeat the new master password"`
+	KeySize       int    `cli:"key-size" usage:"key size of AES in bits, 128 or 256, kept if not set"`
+}
+
+func (argv *passwdT) Validate(ctx *cli.Context) error {
+	if argv.NewMaster != argv.ConfirmMaster {
+		return fmt.Errorf("master password mismatch")
+	}
+	return nil
+}
+
+var passwd = &cli.Command{
+	Name: "passwd",
+	Desc: "change master password",
+	Argv: func() interface{} { return new(passwdT) },
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*passwdT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*passwdT)
+		if err := box.ChangeMasterPassword(argv.MasterPassword(), argv.NewMaster, argv.KeySize); err != nil {
+			return err
+		}
+		if err := forgetMasterPassword(argv.Filename()); err != nil {
+			return err
+		}
+		ctx.String("master password changed\n")
+		return nil
+	},
+}
+
+//-----------------
+// recover command
+//-----------------
+
+type recoverT struct {
+	cli.Helper
+	Key           string `cli:"keyfile" usage:"keyfile for box which requires a keyfile"`
+	Phrase        string `pw:"phrase" usage:"recovery phrase generated by init --recovery" prompt:"type the recovery phrase"`
+	NewMaster     string `pw:"new-master" usage:"new master password" prompt:"type the new master password"`
+	ConfirmMaster string `pw:"confirm-master" usage:"confirm new master password" prompt:"repeat the new master password"`
+}
+
+func (argv *recoverT) Validate(ctx *cli.Context) error {
+	if argv.NewMaster != argv.ConfirmMaster {
+		return fmt.Errorf("master password mismatch")
+	}
+	return nil
+}
+
+var recoverCmd = &cli.Command{
+	Name:   "recover",
+	Desc:   "set a new master password by recovery phrase",
+	Argv:   func() interface{} { return new(recoverT) },
+	NoHook: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*recoverT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*recoverT)
+		filename := Config{}.Filename()
+		opts := core.Options{}
+		if argv.Key != "" {
+			keyfile, err := ioutil.ReadFile(argv.Key)
+			if err != nil {
+				return err
+			}
+			opts.Keyfile = keyfile
+		}
+		box = core.NewBoxWithOptions(core.NewFileRepository(filename), opts)
+		if err := box.RecoverWithPhrase(argv.Phrase, argv.NewMaster); err != nil {
+			return err
+		}
+		if err := forgetMasterPassword(filename); err != nil {
+			return err
+		}
+		ctx.String("master password changed\n")
+		return nil
+	},
+}
+
+//-----------------
+// keyfile command
+//-----------------
+
+type keyfileT struct {
+	cli.Helper
+	Config
+	Set    string `cli:"set" usage:"require keyfile FILE for unlocking box"`
+	Remove bool   `cli:"remove" usage:"remove keyfile requirement of box" dft:"false"`
+}
+
+var keyfile = &cli.Command{
+	Name: "keyfile",
+	Desc: "add or remove keyfile requirement of box",
+	Text: "Usage: onepw keyfile --set=FILE | --remove",
+	Argv: func() interface{} { return new(keyfileT) },
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*keyfileT)
+		if argv.Help || (argv.Set == "") == !argv.Remove {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*keyfileT)
+		if argv.Remove {
+			if err := box.RemoveKeyfile(); err != nil {
+				return err
+			}
+			ctx.String("keyfile requirement removed\n")
+			return nil
+		}
+		data, err := ioutil.ReadFile(argv.Set)
+		if err != nil {
+			return err
+		}
+		if err := box.SetKeyfile(data); err != nil {
+			return err
+		}
+		ctx.String("keyfile %s is required from now on\n", argv.Set)
+		return nil
+	},
+}
+
+//--------------
+// lock command
+//--------------
+
+type lockT struct {
+	cli.Helper
+}
+
+var lock = &cli.Command{
+	Name:   "lock",
+	Desc:   "clear master password cached in keychain of OS",
+	Argv:   func() interface{} { return new(lockT) },
+	NoHook: true,
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*lockT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return nil
+		}
+		if err := forgetMasterPassword(Config{}.Filename()); err != nil {
+			return err
 		}
+		ctx.String("box locked\n")
 		return nil
 	},
 }
 
+// forgetMasterPassword removes master password of box file from keychain
+func forgetMasterPassword(filename string) error {
+	key, err := credentialKey(filename)
+	if err != nil {
+		return err
+	}
+	return core.NewKeyringCache().Delete(key)
+}
+
 //-------------
 // add command
 //-------------
@@ -203,17 +609,32 @@ type addT struct {
 	cli.Helper
 	Config
 	core.Password
-	Pw  string `pw:"pw,password" usage:"the password" prompt:"type the password"`
-	Cpw string `pw:"cpw,confirm-password" usage:"confirm password" prompt:"repeat the password"`
+	Pw      string `pw:"pw,password" usage:"the password" prompt:"type the password"`
+	Cpw     string `pw:"cpw,confirm-password" usage:"confirm password" prompt:"repeat the password"`
+	Expires string `cli:"expires" usage:"expiry date of password, e.g. 2017-01-02"`
+	Gen     bool   `cli:"g,generate" usage:"generate a random password instead of typing one" dft:"false"`
+	Length  int    `cli:"derive-length" usage:"length of derived password, 20 if not set"`
+	Charset string `cli:"derive-charset" usage:"characters of derived password, letters, digits and symbols if not set"`
+}
+
+// UseAgent implements agentUser interface
+func (argv *addT) UseAgent() bool {
+	return true
 }
 
 func (argv *addT) Validate(ctx *cli.Context) error {
+	if argv.Gen || argv.Password.Derived {
+		return nil
+	}
 	if argv.Pw != argv.Cpw {
 		return fmt.Errorf("password mismatch")
 	}
 	return core.CheckPassword(argv.Pw)
 }
 
+// expiryLayout is layout of expiry date of password
+const expiryLayout = "2006-01-02"
+
 var add = &cli.Command{
 	Name: "add",
 	Desc: "add a new password or update old password",
@@ -235,15 +656,35 @@ var add = &cli.Command{
 
 	Fn: func(ctx *cli.Context) error {
 		argv := ctx.Argv().(*addT)
-		argv.Password.PlainPassword = argv.Pw
-		id, ok, err := box.Add(&argv.Password)
+		if argv.Gen {
+			passwd, err := core.GeneratePassword(core.DefaultGenOptions())
+			if err != nil {
+				return err
+			}
+			argv.Pw = passwd
+		}
+		argv.Password.PlainPassword = core.Secret(argv.Pw)
+		argv.Password.DeriveLength = argv.Length
+		argv.Password.DeriveCharset = argv.Charset
+		if argv.Expires != "" {
+			t, err := time.ParseInLocation(expiryLayout, argv.Expires, time.Local)
+			if err != nil {
+				return err
+			}
+			argv.Password.ExpiresAt = t.Unix()
+		}
+		add := box.Add
+		if agent != nil {
+			add = agent.Add
+		}
+		id, ok, err := add(&argv.Password)
 		if err != nil {
 			return err
 		}
 		if ok {
 			ctx.String("password %s updated\n", id)
 		} else {
-			ctx.String("add password %d success\n", id)
+			ctx.String("add password %s success\n", id)
 		}
 		return nil
 	},
@@ -292,13 +733,68 @@ var remove = &cli.Command{
 		if err != nil {
 			return err
 		}
-		ctx.String("deleted passwords:\n")
+		ctx.String("passwords moved to trash:\n")
 		ctx.String(strings.Join(deletedIds, "\n"))
 		ctx.String("\n")
 		return nil
 	},
 }
 
+//-------
+// trash
+//-------
+
+type trashT struct {
+	cli.Helper
+	Config
+	Restore  string `cli:"restore" usage:"move password of the id back from trash"`
+	Empty    bool   `cli:"empty" usage:"remove passwords in trash for good" dft:"false"`
+	NoHeader bool   `cli:"no-header" usage:"don't print header line" dft:"false"`
+}
+
+func (argv *trashT) Validate(ctx *cli.Context) error {
+	if argv.Restore != "" && argv.Empty {
+		return fmt.Errorf("--restore and --empty are exclusive")
+	}
+	return nil
+}
+
+var trash = &cli.Command{
+	Name:        "trash",
+	Desc:        "list removed passwords, restore them or empty trash",
+	Text:        "Usage: onepw trash [--restore=ID | --empty]",
+	Argv:        func() interface{} { return new(trashT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*trashT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*trashT)
+		switch {
+		case argv.Restore != "":
+			if err := box.Restore(argv.Restore); err != nil {
+				return err
+			}
+			ctx.String("password %s restored\n", argv.Restore)
+			return nil
+		case argv.Empty:
+			if err := box.EmptyTrash(); err != nil {
+				return err
+			}
+			ctx.String("trash emptied\n")
+			return nil
+		}
+		return box.ListTrash(ctx, argv.NoHeader)
+	},
+}
+
 //------
 // list
 //------
@@ -306,7 +802,29 @@ var remove = &cli.Command{
 type listT struct {
 	cli.Helper
 	Config
-	NoHeader bool `cli:"no-header" usage:"don't print header line" dft:"false"`
+	NoHeader bool   `cli:"no-header" usage:"don't print header line" dft:"false"`
+	Expiring string `cli:"expiring" usage:"list passwords expired or expiring within the duration, e.g. 0s, 720h"`
+	Sort     string `cli:"sort" usage:"sort passwords by id, category, account or updated, the latest updated first" dft:"id"`
+	Offset   int    `cli:"offset" usage:"skip the first passwords sorted by id" dft:"0"`
+	Limit    int    `cli:"limit" usage:"list at most the number of passwords, all if 0" dft:"0"`
+	JSON     bool   `cli:"json" usage:"write passwords as a JSON array, plaintext included" dft:"false"`
+}
+
+// UseAgent implements agentUser interface
+func (argv *listT) UseAgent() bool {
+	return argv.Expiring == "" && argv.Sort == "id" && !argv.paged() && !argv.JSON
+}
+
+func (argv *listT) paged() bool {
+	return argv.Offset != 0 || argv.Limit != 0
+}
+
+// sortKeys are values of --sort of list command
+var sortKeys = map[string]core.SortKey{
+	"id":       core.SortByID,
+	"category": core.SortByCategory,
+	"account":  core.SortByAccount,
+	"updated":  core.SortByUpdated,
 }
 
 var list = &cli.Command{
@@ -321,12 +839,37 @@ var list = &cli.Command{
 			ctx.WriteUsage()
 			return cli.ExitError
 		}
+		if _, ok := sortKeys[argv.Sort]; !ok {
+			return fmt.Errorf("unknown sort key %s, one of id, category, account and updated", argv.Sort)
+		}
+		if argv.paged() && argv.Sort != "id" {
+			return fmt.Errorf("--offset and --limit list passwords sorted by id only")
+		}
+		if argv.JSON && (argv.paged() || argv.Sort != "id" || argv.Expiring != "") {
+			return fmt.Errorf("--json can't be used with --sort, --offset, --limit or --expiring")
+		}
 		return nil
 	},
 
 	Fn: func(ctx *cli.Context) error {
 		argv := ctx.Argv().(*listT)
-		return box.List(ctx, argv.NoHeader)
+		if argv.Expiring != "" {
+			within, err := time.ParseDuration(argv.Expiring)
+			if err != nil {
+				return err
+			}
+			return box.ListExpiring(ctx, within)
+		}
+		if agent != nil {
+			return agent.List(ctx, argv.NoHeader)
+		}
+		if argv.JSON {
+			return box.ListJSON(ctx)
+		}
+		if argv.paged() {
+			return box.ListPage(ctx, argv.Offset, argv.Limit, argv.NoHeader)
+		}
+		return box.ListSorted(ctx, argv.NoHeader, sortKeys[argv.Sort])
 	},
 }
 
@@ -337,17 +880,101 @@ var list = &cli.Command{
 type findT struct {
 	cli.Helper
 	Config
+	Tag        string `cli:"t,tag" usage:"find passwords tagged by the tag"`
+	IgnoreCase bool   `cli:"i,ignore-case" usage:"match word case-insensitively" dft:"false"`
+	Regexp     bool   `cli:"e,regexp" usage:"match category, account and url by WORD as a regular expression" dft:"false"`
+	Fuzzy      bool   `cli:"f,fuzzy" usage:"rank passwords by how account, category and url resemble WORD, typos are tolerated" dft:"false"`
+	Limit      int    `cli:"n,limit" usage:"max number of passwords found by --fuzzy" dft:"10"`
+}
+
+// UseAgent implements agentUser interface
+func (argv *findT) UseAgent() bool {
+	return argv.Tag == "" && !argv.IgnoreCase && !argv.Regexp && !argv.Fuzzy
 }
 
 var find = &cli.Command{
 	Name:        "find",
 	Desc:        "find password by id,category,account,tag,site and so on",
-	Text:        "Usage: onepw find <WORD>",
+	Text:        "Usage: onepw find <WORD> | onepw find --tag=<TAG>",
 	Argv:        func() interface{} { return new(findT) },
 	CanSubRoute: true,
 
 	OnBefore: func(ctx *cli.Context) error {
 		argv := ctx.Argv().(*findT)
+		if argv.Help || (argv.Tag == "" && len(ctx.Args()) != 1) {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*findT)
+		if argv.Tag != "" {
+			return box.FindByTag(ctx, argv.Tag)
+		}
+		if argv.Regexp {
+			return box.FindRegexp(ctx, ctx.Args()[0])
+		}
+		if argv.Fuzzy {
+			return box.FuzzyFind(ctx, ctx.Args()[0], argv.Limit)
+		}
+		if agent != nil {
+			return agent.Find(ctx, ctx.Args()[0])
+		}
+		box.FindWith(ctx, ctx.Args()[0], core.MatchOptions{IgnoreCase: argv.IgnoreCase})
+		return nil
+	},
+}
+
+//--------------------
+// categories command
+//--------------------
+
+type categoriesT struct {
+	cli.Helper
+	Config
+}
+
+var categories = &cli.Command{
+	Name:        "categories",
+	Desc:        "list categories and their numbers of passwords",
+	Argv:        func() interface{} { return new(categoriesT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*categoriesT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		box.PrintCategories(ctx)
+		return nil
+	},
+}
+
+//-----------------
+// history command
+//-----------------
+
+type historyT struct {
+	cli.Helper
+	Config
+}
+
+var history = &cli.Command{
+	Name:        "history",
+	Desc:        "list previous passwords of a password, the oldest first",
+	Text:        "Usage: onepw history <ID>",
+	Argv:        func() interface{} { return new(historyT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*historyT)
 		if argv.Help || len(ctx.Args()) != 1 {
 			ctx.WriteUsage()
 			return cli.ExitError
@@ -356,7 +983,917 @@ var find = &cli.Command{
 	},
 
 	Fn: func(ctx *cli.Context) error {
-		box.Find(ctx, ctx.Args()[0])
+		entries, err := box.History(ctx.Args()[0])
+		if err != nil {
+			return err
+		}
+		for _, h := range entries {
+			ctx.String("%s  %s\n", time.Unix(h.ReplacedAt, 0).Format(time.RFC3339), h.PlainPassword)
+			h.PlainPassword.Wipe()
+		}
 		return nil
 	},
 }
+
+//--------------
+// totp command
+//--------------
+
+type totpT struct {
+	cli.Helper
+	Config
+}
+
+var totp = &cli.Command{
+	Name:        "totp",
+	Desc:        "print current TOTP code of a password",
+	Text:        "Usage: onepw totp <ID>",
+	Argv:        func() interface{} { return new(totpT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*totpT)
+		if argv.Help || len(ctx.Args()) != 1 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		code, remaining, err := box.TOTP(ctx.Args()[0])
+		if err != nil {
+			return err
+		}
+		ctx.String("%s (%ds left)\n", code, int(remaining.Seconds()))
+		return nil
+	},
+}
+
+//--------------
+// copy command
+//--------------
+
+type copyT struct {
+	cli.Helper
+	Config
+	Field string `cli:"f,field" usage:"field to copy: password, account, notes or totp" dft:"password"`
+	Clear string `cli:"clear" usage:"clear clipboard after the duration, e.g. 30s, 0s never clears it" dft:"30s"`
+}
+
+var copyCmd = &cli.Command{
+	Name:        "copy",
+	Desc:        "copy a field of a password to clipboard and clear it later",
+	Text:        "Usage: onepw copy <ID> [--field=password] [--clear=30s]",
+	Argv:        func() interface{} { return new(copyT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*copyT)
+		if argv.Help || len(ctx.Args()) != 1 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*copyT)
+		field, err := core.ParseField(argv.Field)
+		if err != nil {
+			return err
+		}
+		clearAfter, err := time.ParseDuration(argv.Clear)
+		if err != nil {
+			return err
+		}
+		if err := box.CopyToClipboard(ctx.Args()[0], field, clearAfter); err != nil {
+			return err
+		}
+		if clearAfter <= 0 {
+			ctx.String("%s copied to clipboard\n", field)
+			return nil
+		}
+		ctx.String("%s copied to clipboard, clearing it in %v\n", field, clearAfter)
+		core.WaitClipboard()
+		return nil
+	},
+}
+
+//----------------
+// reveal command
+//----------------
+
+type revealT struct {
+	cli.Helper
+	Config
+	Field   string `cli:"f,field" usage:"field to write: password, account, notes or totp" dft:"password"`
+	Newline bool   `cli:"n,newline" usage:"write a trailing newline" dft:"false"`
+}
+
+var reveal = &cli.Command{
+	Name:        "reveal",
+	Desc:        "write a field of a password to stdout as is, for scripts",
+	Text:        "Usage: onepw reveal <ID> [--field=password] [-n]",
+	Argv:        func() interface{} { return new(revealT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*revealT)
+		if argv.Help || len(ctx.Args()) != 1 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*revealT)
+		field, err := core.ParseField(argv.Field)
+		if err != nil {
+			return err
+		}
+		if err := box.Reveal(ctx.Args()[0], field, ctx); err != nil {
+			return err
+		}
+		if argv.Newline {
+			ctx.String("\n")
+		}
+		return nil
+	},
+}
+
+//----------------
+// export command
+//----------------
+
+type exportT struct {
+	cli.Helper
+	Config
+	CSV        bool   `cli:"csv" usage:"export passwords as plaintext CSV for other password managers, keep the file safe and remove it after use" dft:"false"`
+	Passphrase string `pw:"passphrase" usage:"encrypt export by a key derived from passphrase instead of master password"`
+}
+
+var export = &cli.Command{
+	Name:        "export",
+	Desc:        "export box signed by a key derived from master password",
+	Text:        "Usage: onepw export <OUTFILE>",
+	Argv:        func() interface{} { return new(exportT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*exportT)
+		if argv.Help || len(ctx.Args()) != 1 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		file, err := os.OpenFile(ctx.Args()[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		write := box.Export
+		if argv := ctx.Argv().(*exportT); argv.CSV {
+			write = box.ExportCSV
+		} else if argv.Passphrase != "" {
+			write = func(w io.Writer) error {
+				return box.ExportEncrypted(w, argv.Passphrase)
+			}
+		}
+		if err := write(file); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	},
+}
+
+//----------------
+// import command
+//----------------
+
+type importT struct {
+	cli.Helper
+	Config
+	CSV            bool   `cli:"csv" usage:"import passwords from plaintext CSV with a header row" dft:"false"`
+	Chrome         bool   `cli:"chrome" usage:"import passwords exported by Chrome or Chromium, existing accounts are skipped" dft:"false"`
+	CategoryColumn string `cli:"category-column" usage:"column of category in CSV" dft:"category"`
+	AccountColumn  string `cli:"account-column" usage:"column of account in CSV" dft:"account"`
+	PasswordColumn string `cli:"password-column" usage:"column of password in CSV" dft:"password"`
+	URLColumn      string `cli:"url-column" usage:"column of url in CSV" dft:"url"`
+	NotesColumn    string `cli:"notes-column" usage:"column of notes in CSV" dft:"notes"`
+	Passphrase     string `pw:"passphrase" usage:"passphrase of an export encrypted by passphrase"`
+}
+
+var importCmd = &cli.Command{
+	Name:        "import",
+	Desc:        "verify an export and merge it into box, the newest passwords are kept, or add passwords from CSV",
+	Text:        "Usage: onepw import <FILE>",
+	Argv:        func() interface{} { return new(importT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*importT)
+		if argv.Help || len(ctx.Args()) != 1 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		file, err := os.Open(ctx.Args()[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		argv := ctx.Argv().(*importT)
+		if argv.Chrome {
+			added, err := box.ImportChromeCSV(file)
+			var skipped *core.CSVImportError
+			if errors.As(err, &skipped) {
+				for _, row := range skipped.Rows {
+					ctx.String("line %d skipped: %v\n", row.Line, row.Err)
+				}
+				ctx.String("%d passwords added, %d skipped\n", added, len(skipped.Rows))
+				return nil
+			}
+			ctx.String("%d passwords added\n", added)
+			return err
+		}
+		if argv.CSV {
+			added, err := box.ImportCSV(file, core.ColumnMapping{
+				Category: argv.CategoryColumn,
+				Account:  argv.AccountColumn,
+				Password: argv.PasswordColumn,
+				URL:      argv.URLColumn,
+				Notes:    argv.NotesColumn,
+			})
+			ctx.String("%d passwords added\n", added)
+			return err
+		}
+		var conflicts []core.Conflict
+		if argv.Passphrase != "" {
+			conflicts, err = box.ImportEncrypted(file, argv.Passphrase)
+		} else {
+			conflicts, err = box.Import(file, core.MergeNewest)
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range conflicts {
+			ctx.String("conflict: password %s updated at the same time, ours kept\n", c.ID)
+		}
+		return nil
+	},
+}
+
+//--------------
+// sync command
+//--------------
+
+type syncT struct {
+	cli.Helper
+	Config
+	Strategy string `cli:"strategy" usage:"keep newest, ours, theirs or none of passwords changed on both sides" dft:"newest"`
+}
+
+// mergeStrategies are values of --strategy of sync command
+var mergeStrategies = map[string]core.MergeStrategy{
+	"newest": core.MergeNewest,
+	"ours":   core.MergeOurs,
+	"theirs": core.MergeTheirs,
+	"none":   core.MergeManual,
+}
+
+var syncCmd = &cli.Command{
+	Name: "sync",
+	Desc: "sync box with another box both ways, e.g. a copy of another computer",
+	Text: `Usage: onepw sync <FILE>
+
+The other box is unlocked by master password of box, FILE is a SQLite or
+bbolt database or a JSON file as convert tells. Removed passwords are
+removed from the other box too, unless they were updated there later`,
+	Argv:        func() interface{} { return new(syncT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*syncT)
+		if argv.Help || len(ctx.Args()) != 1 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		if _, ok := mergeStrategies[argv.Strategy]; !ok {
+			return fmt.Errorf("unknown strategy %s, one of newest, ours, theirs and none", argv.Strategy)
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*syncT)
+		other, closeOther, err := openRepository(ctx.Args()[0])
+		if err != nil {
+			return err
+		}
+		defer closeOther()
+		report, err := box.SyncWith(other, mergeStrategies[argv.Strategy])
+		if err != nil {
+			return err
+		}
+		for _, side := range []struct {
+			name    string
+			changes core.SyncChanges
+		}{{"box", report.Local}, {ctx.Args()[0], report.Remote}} {
+			ctx.String("%s: %d added, %d updated, %d removed\n", side.name,
+				len(side.changes.Added), len(side.changes.Updated), len(side.changes.Deleted))
+		}
+		for _, c := range report.Conflicts {
+			ctx.String("conflict: password %s changed on both sides, kept as is\n", c.ID)
+		}
+		return nil
+	},
+}
+
+//---------------
+// field command
+//---------------
+
+type fieldT struct {
+	cli.Helper
+	Config
+}
+
+var field = &cli.Command{
+	Name:        "field",
+	Desc:        "get or set an encrypted custom field of a password, an empty value removes the field",
+	Text:        "Usage: onepw field <ID> <KEY> [VALUE]",
+	Argv:        func() interface{} { return new(fieldT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*fieldT)
+		if n := len(ctx.Args()); argv.Help || n < 2 || n > 3 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		args := ctx.Args()
+		if len(args) == 3 {
+			return box.SetField(args[0], args[1], args[2])
+		}
+		value, err := box.GetField(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		ctx.String("%s\n", value)
+		return nil
+	},
+}
+
+//---------------
+// share command
+//---------------
+
+type shareT struct {
+	cli.Helper
+	Config
+	Keygen bool `cli:"keygen" usage:"generate a key pair for accepting shared passwords, private key written to KEYFILE" dft:"false"`
+}
+
+var share = &cli.Command{
+	Name:        "share",
+	Desc:        "share a password encrypted to public key of recipient, accepted by accept command",
+	Text:        "Usage: onepw share <ID> <PUBLIC_KEY> <OUTFILE>\n       onepw share --keygen <KEYFILE>",
+	Argv:        func() interface{} { return new(shareT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*shareT)
+		if n := len(ctx.Args()); argv.Help || (argv.Keygen && n != 1) || (!argv.Keygen && n != 3) {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*shareT)
+		args := ctx.Args()
+		if argv.Keygen {
+			pub, priv, err := core.GenerateShareKeypair()
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(args[0], []byte(hex.EncodeToString(priv)+"\n"), 0600); err != nil {
+				return err
+			}
+			ctx.String("public key: %s\n", hex.EncodeToString(pub))
+			return nil
+		}
+		pub, err := hex.DecodeString(args[1])
+		if err != nil {
+			return fmt.Errorf("public key must be hex encoded: %v", err)
+		}
+		blob, err := box.ShareEntry(args[0], pub)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(args[2], blob, 0600)
+	},
+}
+
+//----------------
+// accept command
+//----------------
+
+type acceptT struct {
+	cli.Helper
+	Config
+}
+
+var accept = &cli.Command{
+	Name:        "accept",
+	Desc:        "add a password shared by share command with private key in KEYFILE",
+	Text:        "Usage: onepw accept <FILE> <KEYFILE>",
+	Argv:        func() interface{} { return new(acceptT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*acceptT)
+		if argv.Help || len(ctx.Args()) != 2 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		args := ctx.Args()
+		blob, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		keyfile, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		priv, err := hex.DecodeString(strings.TrimSpace(string(keyfile)))
+		if err != nil {
+			return fmt.Errorf("private key must be hex encoded: %v", err)
+		}
+		defer core.Secret(priv).Wipe()
+		id, err := box.ImportShared(blob, priv)
+		if err != nil {
+			return err
+		}
+		ctx.String("password %s added\n", id)
+		return nil
+	},
+}
+
+//------------------
+// generate command
+//------------------
+
+type generateT struct {
+	cli.Helper
+	Length      int  `cli:"n,length" usage:"length of password" dft:"20"`
+	NoLower     bool `cli:"no-lower" usage:"exclude lowercase letters" dft:"false"`
+	NoUpper     bool `cli:"no-upper" usage:"exclude uppercase letters" dft:"false"`
+	NoDigits    bool `cli:"no-digits" usage:"exclude digits" dft:"false"`
+	NoSymbols   bool `cli:"no-symbols" usage:"exclude symbols" dft:"false"`
+	NoAmbiguous bool `cli:"no-ambiguous" usage:"exclude characters easily confused, e.g. 0 and O" dft:"false"`
+}
+
+var generate = &cli.Command{
+	Name:   "generate",
+	Desc:   "generate a random password",
+	Argv:   func() interface{} { return new(generateT) },
+	NoHook: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*generateT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*generateT)
+		passwd, err := core.GeneratePassword(core.GenOptions{
+			Length:           argv.Length,
+			Lower:            !argv.NoLower,
+			Upper:            !argv.NoUpper,
+			Digits:           !argv.NoDigits,
+			Symbols:          !argv.NoSymbols,
+			ExcludeAmbiguous: argv.NoAmbiguous,
+		})
+		if err != nil {
+			return err
+		}
+		ctx.String("%s\n", passwd)
+		return nil
+	},
+}
+
+//----------------
+// rotate command
+//----------------
+
+type rotateT struct {
+	cli.Helper
+	Config
+}
+
+var rotate = &cli.Command{
+	Name:        "rotate",
+	Desc:        "derive a new password for a derived password by increasing its counter",
+	Text:        "Usage: onepw rotate <ID>",
+	Argv:        func() interface{} { return new(rotateT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*rotateT)
+		if argv.Help || len(ctx.Args()) != 1 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		return box.RotateDerived(ctx.Args()[0])
+	},
+}
+
+//----------------
+// verify command
+//----------------
+
+type verifyT struct {
+	cli.Helper
+	Config
+}
+
+var verify = &cli.Command{
+	Name:        "verify",
+	Desc:        "check that every password of box decrypts cleanly",
+	Argv:        func() interface{} { return new(verifyT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*verifyT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		report, err := box.Verify()
+		if err != nil {
+			return err
+		}
+		for _, p := range report.Problems {
+			ctx.String("%s\n", p)
+		}
+		if !report.OK() {
+			return fmt.Errorf("%d problems found in %d passwords", len(report.Problems), report.Entries)
+		}
+		ctx.String("%d passwords verified\n", report.Entries)
+		return nil
+	},
+}
+
+//---------------
+// rekey command
+//---------------
+
+type rekeyT struct {
+	cli.Helper
+	Config
+}
+
+var rekey = &cli.Command{
+	Name:        "rekey",
+	Desc:        "re-encrypt every password with fresh IVs, e.g. after box file leaked",
+	Argv:        func() interface{} { return new(rekeyT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*rekeyT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		n, err := box.Rekey()
+		if err != nil {
+			return err
+		}
+		ctx.String("%d passwords rotated\n", n)
+		return nil
+	},
+}
+
+//---------------
+// prune command
+//---------------
+
+type pruneT struct {
+	cli.Helper
+	Days  int  `cli:"days" usage:"remove backups last modified more than days ago" dft:"30"`
+	Shred bool `cli:"shred" usage:"overwrite backups before removing them, best effort on SSD and copy-on-write filesystems" dft:"false"`
+}
+
+var prune = &cli.Command{
+	Name:   "prune",
+	Desc:   "remove old backups of box",
+	Argv:   func() interface{} { return new(pruneT) },
+	NoHook: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*pruneT)
+		if argv.Help || argv.Days < 0 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*pruneT)
+		repo := core.NewFileRepository(Config{}.Filename())
+		pruned, err := repo.PruneBackups(time.Duration(argv.Days)*24*time.Hour, argv.Shred)
+		for _, name := range pruned {
+			ctx.String("%s removed\n", name)
+		}
+		return err
+	},
+}
+
+//-----------------
+// restore command
+//-----------------
+
+type restoreT struct {
+	cli.Helper
+	Config
+	Backup string `cli:"backup" usage:"time stamp of backup to restore, backups are listed if empty"`
+}
+
+var restore = &cli.Command{
+	Name:        "restore",
+	Desc:        "restore box from a backup made before saving",
+	Argv:        func() interface{} { return new(restoreT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*restoreT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*restoreT)
+		repo := core.NewFileRepository(argv.Filename())
+		if argv.Backup == "" {
+			timestamps, err := repo.TimestampedBackups()
+			if err != nil {
+				return err
+			}
+			for _, timestamp := range timestamps {
+				ctx.String("%s\n", timestamp)
+			}
+			return nil
+		}
+		if err := repo.RestoreBackup(argv.Backup); err != nil {
+			return err
+		}
+		if err := box.Reload(); err != nil {
+			return err
+		}
+		ctx.String("backup %s restored\n", argv.Backup)
+		return nil
+	},
+}
+
+//-----------------
+// convert command
+//-----------------
+
+type convertT struct {
+	cli.Helper
+}
+
+var convert = &cli.Command{
+	Name: "convert",
+	Desc: "convert box between JSON file, SQLite and bbolt databases",
+	Text: `Usage: onepw convert <FROM> <TO>
+
+Files ending with .db, .sqlite or .sqlite3 are SQLite databases, files
+ending with .bolt or .bbolt are bbolt databases, others are JSON files`,
+	Argv:   func() interface{} { return new(convertT) },
+	NoHook: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*convertT)
+		if argv.Help || len(ctx.Args()) != 2 {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		from, closeFrom, err := openRepository(ctx.Args()[0])
+		if err != nil {
+			return err
+		}
+		defer closeFrom()
+		to, closeTo, err := openRepository(ctx.Args()[1])
+		if err != nil {
+			return err
+		}
+		defer closeTo()
+		if err := core.ConvertRepository(from, to); err != nil {
+			return err
+		}
+		ctx.String("%s converted to %s\n", ctx.Args()[0], ctx.Args()[1])
+		return nil
+	},
+}
+
+// openRepository opens repository of box stored as filename, kind of
+// repository is decided by extension of filename
+func openRepository(filename string) (core.BoxRepository, func(), error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".db", ".sqlite", ".sqlite3":
+		db, err := sql.Open("sqlite", filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		repo, err := core.NewSQLiteRepository(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return repo, func() { db.Close() }, nil
+	case ".bolt", ".bbolt":
+		return core.NewBoltRepository(filename), func() {}, nil
+	}
+	return core.NewFileRepository(filename), func() {}, nil
+}
+
+//---------------
+// audit command
+//---------------
+
+type auditT struct {
+	cli.Helper
+	Config
+}
+
+var audit = &cli.Command{
+	Name:        "audit",
+	Desc:        "report reused and expired passwords",
+	Argv:        func() interface{} { return new(auditT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*auditT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		report, err := box.Audit()
+		if err != nil {
+			return err
+		}
+		groups := make([]string, 0, len(report.Reused))
+		for _, ids := range report.Reused {
+			groups = append(groups, strings.Join(ids, ", "))
+		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			ctx.String("reused password: %s\n", group)
+		}
+		for _, id := range report.Expired {
+			ctx.String("expired password: %s\n", id)
+		}
+		if report.OK() {
+			ctx.String("no reused or expired password\n")
+		}
+		return nil
+	},
+}
+
+//----------------
+// status command
+//----------------
+
+type statusT struct {
+	cli.Helper
+	Config
+}
+
+var status = &cli.Command{
+	Name:        "status",
+	Desc:        "print key derivation parameters and key slots of box",
+	Argv:        func() interface{} { return new(statusT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*statusT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		cfg := box.KDFParams()
+		ctx.String("kdf: %s\n", cfg.Type)
+		switch cfg.Type {
+		case core.KDFPBKDF2:
+			ctx.String("iterations: %d\n", cfg.Iterations)
+		case core.KDFScrypt:
+			ctx.String("N: %d, r: %d, p: %d\n", cfg.N, cfg.R, cfg.P)
+		case core.KDFArgon2id:
+			ctx.String("time: %d, memory: %d KiB, threads: %d\n", cfg.Time, cfg.Memory, cfg.Threads)
+		}
+		ctx.String("key slots: %d\n", box.KeySlotCount())
+		ctx.String("passwords: %d\n", box.Count())
+		return nil
+	},
+}
+
+//---------------
+// agent command
+//---------------
+
+type agentT struct {
+	cli.Helper
+	Config
+	Idle string `cli:"idle" usage:"lock box and stop agent after it's idle for the duration, never if 0s" dft:"15m"`
+}
+
+var agentCmd = &cli.Command{
+	Name:        "agent",
+	Desc:        "unlock box once and serve list, find and add of later commands over a unix socket",
+	Argv:        func() interface{} { return new(agentT) },
+	CanSubRoute: true,
+
+	OnBefore: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*agentT)
+		if argv.Help {
+			ctx.WriteUsage()
+			return cli.ExitError
+		}
+		return nil
+	},
+
+	Fn: func(ctx *cli.Context) error {
+		argv := ctx.Argv().(*agentT)
+		idle, err := time.ParseDuration(argv.Idle)
+		if err != nil {
+			return err
+		}
+		path, err := agentSocket(argv.Filename())
+		if err != nil {
+			return err
+		}
+		a, err := core.NewAgent(box, path, idle)
+		if err != nil {
+			return err
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			a.Close()
+		}()
+		ctx.String("agent listening on %s\n", path)
+		return a.Serve()
+	},
+}